@@ -0,0 +1,152 @@
+/**
+ * 登陆防暴力破解
+ * 按账号与ip分别统计验证失败次数，超过阈值后锁定，锁定时间按指数增长
+ * 集群模式下计数保存在redis，所有节点共享
+ * tim_property:
+ *    auth.guard.enable          1开启 0关闭，缺省1
+ *    auth.guard.account.limit   账号连续失败次数阈值，缺省5
+ *    auth.guard.ip.limit        ip连续失败次数阈值，缺省20
+ *    auth.guard.window          失败计数有效期，单位秒，缺省600
+ *    auth.guard.lock            首次锁定时间，单位秒，缺省60，之后每次失败翻倍
+ *    auth.guard.maxlock         最长锁定时间，单位秒，缺省3600
+ */
+package authGuard
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+type store interface {
+	//失败次数加1，返回当前次数
+	incr(key string, window int) int
+	//锁定剩余时间 单位秒
+	ttl(key string) int
+	lock(key string, seconds int)
+	del(key string)
+}
+
+var local = newLocalStore()
+var remote = new(redisStore)
+
+/**统计*/
+var failures, lockouts, rejects, successes int64
+
+func getStore() store {
+	if cluster.IsCluster() {
+		return remote
+	}
+	return local
+}
+
+func IsEnable() bool {
+	return common.CF().GetKV("auth.guard.enable", "1") == "1"
+}
+
+func accountKey(tid *protocol.Tid) string {
+	return fmt.Sprint("a_", tid.GetDomain(), "_", tid.GetName())
+}
+
+func ipKey(ip string) string {
+	return fmt.Sprint("i_", ip)
+}
+
+/**
+ * 验证前检查 账号或ip被锁定时返回剩余锁定时间(秒)
+ * ip为空时只检查账号
+ */
+func Check(tid *protocol.Tid, ip string) (locked bool, retryAfter int) {
+	if !IsEnable() || tid == nil {
+		return
+	}
+	s := getStore()
+	retryAfter = s.ttl(accountKey(tid))
+	if ip != "" {
+		if t := s.ttl(ipKey(ip)); t > retryAfter {
+			retryAfter = t
+		}
+	}
+	if locked = retryAfter > 0; locked {
+		atomic.AddInt64(&rejects, 1)
+	}
+	return
+}
+
+/**验证失败 返回本次失败后的锁定时间(秒)，0为未锁定*/
+func Fail(tid *protocol.Tid, ip string) (lockSeconds int) {
+	atomic.AddInt64(&failures, 1)
+	if !IsEnable() || tid == nil {
+		return
+	}
+	s := getStore()
+	window := utils.Atoi(common.CF().GetKV("auth.guard.window", "600"))
+	lockSeconds = _fail(s, accountKey(tid), window, utils.Atoi(common.CF().GetKV("auth.guard.account.limit", "5")))
+	if ip != "" {
+		if t := _fail(s, ipKey(ip), window, utils.Atoi(common.CF().GetKV("auth.guard.ip.limit", "20"))); t > lockSeconds {
+			lockSeconds = t
+		}
+	}
+	if lockSeconds > 0 {
+		logger.Warn("auth guard lock:", tid.GetName(), "@", tid.GetDomain(), " ip:", ip, " seconds:", lockSeconds)
+	}
+	return
+}
+
+func _fail(s store, key string, window, limit int) (lockSeconds int) {
+	count := s.incr(key, window)
+	if limit <= 0 || count < limit {
+		return
+	}
+	lockSeconds = lockTime(count - limit)
+	s.lock(key, lockSeconds)
+	atomic.AddInt64(&lockouts, 1)
+	return
+}
+
+/**第n次超出阈值的锁定时间 lock*2^n 不超过maxlock*/
+func lockTime(n int) int {
+	base := utils.Atoi(common.CF().GetKV("auth.guard.lock", "60"))
+	max := utils.Atoi(common.CF().GetKV("auth.guard.maxlock", "3600"))
+	if base <= 0 {
+		base = 60
+	}
+	t := base
+	for i := 0; i < n && t < max; i++ {
+		t = t << 1
+	}
+	if max > 0 && t > max {
+		t = max
+	}
+	return t
+}
+
+/**验证成功 清除账号失败计数*/
+func Success(tid *protocol.Tid) {
+	atomic.AddInt64(&successes, 1)
+	if !IsEnable() || tid == nil {
+		return
+	}
+	getStore().del(accountKey(tid))
+}
+
+/**管理员解锁账号*/
+func UnlockAccount(tid *protocol.Tid) {
+	getStore().del(accountKey(tid))
+	logger.Info("auth guard unlock:", tid.GetName(), "@", tid.GetDomain())
+}
+
+/**管理员解锁ip*/
+func UnlockIp(ip string) {
+	getStore().del(ipKey(ip))
+	logger.Info("auth guard unlock ip:", ip)
+}
+
+func Info() string {
+	return fmt.Sprintln("auth success:", atomic.LoadInt64(&successes), " failure:", atomic.LoadInt64(&failures), " lockout:", atomic.LoadInt64(&lockouts), " reject:", atomic.LoadInt64(&rejects))
+}
@@ -0,0 +1,169 @@
+package authGuard
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/cluster"
+)
+
+type counter struct {
+	count  int
+	expire int64
+	lock   int64
+}
+
+/**单机模式 内存计数*/
+type localStore struct {
+	m    map[string]*counter
+	sync *sync.Mutex
+}
+
+func newLocalStore() *localStore {
+	s := &localStore{m: make(map[string]*counter, 0), sync: new(sync.Mutex)}
+	go s.clean()
+	return s
+}
+
+func (this *localStore) incr(key string, window int) int {
+	this.sync.Lock()
+	defer this.sync.Unlock()
+	now := time.Now().Unix()
+	c, ok := this.m[key]
+	if !ok || (c.expire < now && c.lock < now) {
+		c = new(counter)
+		this.m[key] = c
+	}
+	c.count++
+	c.expire = now + int64(window)
+	return c.count
+}
+
+func (this *localStore) ttl(key string) int {
+	this.sync.Lock()
+	defer this.sync.Unlock()
+	if c, ok := this.m[key]; ok {
+		if t := c.lock - time.Now().Unix(); t > 0 {
+			return int(t)
+		}
+	}
+	return 0
+}
+
+func (this *localStore) lock(key string, seconds int) {
+	this.sync.Lock()
+	defer this.sync.Unlock()
+	if c, ok := this.m[key]; ok {
+		c.lock = time.Now().Unix() + int64(seconds)
+		if c.expire < c.lock {
+			c.expire = c.lock
+		}
+	}
+}
+
+func (this *localStore) del(key string) {
+	this.sync.Lock()
+	defer this.sync.Unlock()
+	delete(this.m, key)
+}
+
+// 清除过期计数
+func (this *localStore) clean() {
+	for {
+		time.Sleep(60 * time.Second)
+		now := time.Now().Unix()
+		this.sync.Lock()
+		for k, c := range this.m {
+			if c.expire < now && c.lock < now {
+				delete(this.m, k)
+			}
+		}
+		this.sync.Unlock()
+	}
+}
+
+/**
+ * 集群模式 redis计数
+ * 与单机相同为滑动窗口: 每次失败都把计数有效期延长到 window，锁定期间计数不过期
+ */
+type redisStore struct {
+	incrScript *cluster.ScriptCmd
+	lockScript *cluster.ScriptCmd
+	once       sync.Once
+}
+
+var scriptIncrCmd = `
+local c = redis.call('incr',KEYS[1])
+local w = tonumber(ARGV[1])
+local t = redis.call('ttl',KEYS[2])
+if t > w then
+   w = t
+end
+redis.call('expire',KEYS[1],w)
+return c
+`
+
+var scriptLockCmd = `
+redis.call('setex',KEYS[2],ARGV[1],1)
+if redis.call('ttl',KEYS[1]) < tonumber(ARGV[1]) then
+   redis.call('expire',KEYS[1],ARGV[1])
+end
+return 1
+`
+
+func (this *redisStore) init() {
+	this.once.Do(func() {
+		this.incrScript = cluster.Redis.NewScript(scriptIncrCmd, 2)
+		this.lockScript = cluster.Redis.NewScript(scriptLockCmd, 2)
+	})
+}
+
+func (this *redisStore) incr(key string, window int) (i int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("auth guard incr:", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	this.init()
+	reply, err := this.incrScript.EvalSha("tim_guard_fail_"+key, "tim_guard_lock_"+key, window)
+	if err != nil {
+		logger.Error("auth guard incr:", err.Error())
+		return
+	}
+	if n, ok := reply.(int64); ok {
+		i = int(n)
+	}
+	return
+}
+
+func (this *redisStore) ttl(key string) int {
+	reply, err := cluster.Redis.Do("TTL", "tim_guard_lock_"+key)
+	if err != nil {
+		return 0
+	}
+	if n, ok := reply.(int64); ok && n > 0 {
+		return int(n)
+	}
+	return 0
+}
+
+func (this *redisStore) lock(key string, seconds int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("auth guard lock:", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	this.init()
+	if _, err := this.lockScript.EvalSha("tim_guard_fail_"+key, "tim_guard_lock_"+key, seconds); err != nil {
+		logger.Error("auth guard lock:", err.Error())
+	}
+}
+
+func (this *redisStore) del(key string) {
+	cluster.Redis.Del("tim_guard_fail_" + key)
+	cluster.Redis.Del("tim_guard_lock_" + key)
+}
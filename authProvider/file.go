@@ -0,0 +1,96 @@
+package authProvider
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/password"
+	"github.com/zhangjunfang/im/protocol"
+)
+
+/**
+ * 静态文件验证 tim_property: auth.file 文件路径
+ * 每行一个用户:  domain name password  或  name password(适用于所有domain)
+ * #开头为注释，文件修改后自动重新加载
+ */
+type FileProvider struct {
+	path    string
+	modTime time.Time
+	users   map[string]string
+	lock    sync.RWMutex
+}
+
+func (this *FileProvider) Name() string {
+	return "file"
+}
+
+func (this *FileProvider) Auth(tid *protocol.Tid, pwd string) Result {
+	path := common.CF().GetKV("auth.file", "")
+	if path == "" {
+		return UNKNOWN
+	}
+	if err := this.load(path); err != nil {
+		logger.Error("file provider:", err.Error())
+		return ERROR
+	}
+	this.lock.RLock()
+	stored, ok := this.users[fmt.Sprint(tid.GetDomain(), " ", tid.GetName())]
+	if !ok {
+		stored, ok = this.users[tid.GetName()]
+	}
+	this.lock.RUnlock()
+	if !ok {
+		return UNKNOWN
+	}
+	if ok, _ := password.Verify(stored, pwd); ok {
+		return SUCCESS
+	}
+	return FAILED
+}
+
+func (this *FileProvider) load(path string) (err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	this.lock.RLock()
+	unchanged := this.path == path && this.modTime.Equal(fi.ModTime())
+	this.lock.RUnlock()
+	if unchanged {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	users := make(map[string]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 2:
+			users[fields[0]] = fields[1]
+		case 3:
+			users[fmt.Sprint(fields[0], " ", fields[1])] = fields[2]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	this.lock.Lock()
+	this.path, this.modTime, this.users = path, fi.ModTime(), users
+	this.lock.Unlock()
+	logger.Info("file provider load:", path, " ", len(users))
+	return
+}
@@ -0,0 +1,50 @@
+package authProvider
+
+import (
+	"errors"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/password"
+	"github.com/zhangjunfang/im/protocol"
+)
+
+var ErrOldPassword = errors.New("old password is error")
+
+/**
+ * 设置密码 按 authProvider.passwordHash 加密
+ * 配置了 tim.mysql.passwordUpdateSQL 时更新业务库，否则更新 tim_user
+ */
+func SetPassword(tid *protocol.Tid, newpwd string) (err error) {
+	if tid == nil || newpwd == "" {
+		return errors.New("tid or password is empty")
+	}
+	encryptedpassword, err := password.Hash(newpwd)
+	if err != nil {
+		return
+	}
+	if common.CF().GetKV("tim.mysql.passwordUpdateSQL", "") != "" {
+		return daoService.UpdatePassword4Sql(tid, encryptedpassword)
+	}
+	return daoService.UpdateUserPassword(tid, encryptedpassword)
+}
+
+/**
+ * 修改密码 旧密码只由保存该密码的验证器核对(与 SetPassword 更新的库相同)
+ * 令牌、http、文件验证器不能用于修改密码，MustAuth为0时同样核对
+ */
+func ChangePassword(tid *protocol.Tid, oldpwd, newpwd string) (err error) {
+	p := passwordProvider()
+	if oldpwd == "" || p == nil || isDisabled(tid, "passwd") || _auth(p, tid, oldpwd) != SUCCESS {
+		return ErrOldPassword
+	}
+	return SetPassword(tid, newpwd)
+}
+
+/**保存密码的验证器 配置了 tim.mysql.passwordUpdateSQL 时为 sql，否则为 timuser*/
+func passwordProvider() AuthProvider {
+	if common.CF().GetKV("tim.mysql.passwordUpdateSQL", "") != "" {
+		return GetProvider("sql")
+	}
+	return GetProvider("timuser")
+}
@@ -0,0 +1,178 @@
+/**
+ * 登陆验证
+ * 所有入口(socket,tls,http /tim)统一经过验证链，按配置顺序依次调用 AuthProvider
+ * tim_property:
+ *    auth.providers           验证链顺序，逗号分隔 如 token,sql,timuser
+ *    auth.providers.domain    指定domain的验证链，优先于 auth.providers
+ */
+package authProvider
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/token"
+)
+
+/**验证结果*/
+type Result int
+
+const (
+	/**不处理，交给下一个验证器*/
+	UNKNOWN Result = 0
+	/**验证通过*/
+	SUCCESS Result = 1
+	/**验证失败*/
+	FAILED Result = 2
+	/**验证器出错*/
+	ERROR Result = 3
+)
+
+func (r Result) String() string {
+	switch r {
+	case SUCCESS:
+		return "success"
+	case FAILED:
+		return "failed"
+	case ERROR:
+		return "error"
+	}
+	return "unknown"
+}
+
+type AuthProvider interface {
+	//验证器名称，对应 auth.providers 中的配置
+	Name() string
+	//验证用户名密码
+	Auth(tid *protocol.Tid, pwd string) Result
+}
+
+var providers = make(map[string]AuthProvider, 0)
+var lock = new(sync.RWMutex)
+
+/**注册验证器，同名覆盖*/
+func Register(p AuthProvider) {
+	lock.Lock()
+	defer lock.Unlock()
+	providers[p.Name()] = p
+}
+
+func GetProvider(name string) AuthProvider {
+	lock.RLock()
+	defer lock.RUnlock()
+	return providers[name]
+}
+
+/**
+ * 验证用户 entry为入口名称，用于日志
+ * 依次调用验证链，SUCCESS 即通过，其他结果继续下一个验证器
+ * tim_user 中被禁用的用户直接验证失败，MustAuth为0时同样检查
+ */
+func Auth(tid *protocol.Tid, pwd, entry string) (b bool) {
+	if isDisabled(tid, entry) {
+		return false
+	}
+	if common.CF().MustAuth == 0 {
+		return true
+	}
+	return verify(tid, pwd, entry)
+}
+
+/**
+ * 核对用户密码 不受 MustAuth 影响
+ * 用于修改密码等必须确认本人的操作
+ */
+func Verify(tid *protocol.Tid, pwd, entry string) (b bool) {
+	if isDisabled(tid, entry) {
+		return false
+	}
+	return verify(tid, pwd, entry)
+}
+
+/**tim_user 中被禁用的用户 在 MustAuth 与验证链之前检查*/
+func isDisabled(tid *protocol.Tid, entry string) bool {
+	if tid == nil {
+		return true
+	}
+	if common.CF().Db_Exsit == 1 && daoService.IsUserDisabled(tid) {
+		logger.Warn("auth ", entry, " disabled:", tid.GetName(), "@", tid.GetDomain())
+		return true
+	}
+	return false
+}
+
+func verify(tid *protocol.Tid, pwd, entry string) (b bool) {
+	result, name := UNKNOWN, ""
+	for _, name = range Chain(tid.GetDomain()) {
+		p := GetProvider(name)
+		if p == nil {
+			logger.Warn("auth provider not exist:", name)
+			continue
+		}
+		result = _auth(p, tid, pwd)
+		if result == SUCCESS {
+			break
+		}
+	}
+	b = result == SUCCESS
+	if b {
+		logger.Debug("auth ", entry, " success:", tid.GetName(), "@", tid.GetDomain(), " provider:", name)
+	} else {
+		logger.Warn("auth ", entry, " ", result.String(), ":", tid.GetName(), "@", tid.GetDomain())
+	}
+	return
+}
+
+func _auth(p AuthProvider, tid *protocol.Tid, pwd string) (result Result) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("auth provider ", p.Name(), " error:", err)
+			logger.Error(string(debug.Stack()))
+			result = ERROR
+		}
+	}()
+	result = p.Auth(tid, pwd)
+	if result == ERROR {
+		logger.Error("auth provider ", p.Name(), " error:", tid.GetName())
+	}
+	return
+}
+
+/**
+ * 验证链顺序
+ * 未配置 auth.providers 时，与旧版本行为一致:
+ *    user_auth_url 不为空使用 http，passwordSQL 不为空使用 sql，否则使用 timuser
+ */
+func Chain(domain string) (names []string) {
+	chain := common.CF().GetKV(fmt.Sprint("auth.providers.", domain), "")
+	if chain == "" {
+		chain = common.CF().GetKV("auth.providers", "")
+	}
+	if chain == "" {
+		names = make([]string, 0)
+		if token.IsEnable() {
+			names = append(names, "token")
+		}
+		if len(common.CF().GetKV("user_auth_url", "")) > 9 {
+			names = append(names, "http")
+		} else if common.CF().GetKV("tim.mysql.passwordSQL", "") != "" {
+			names = append(names, "sql")
+		} else {
+			names = append(names, "timuser")
+		}
+		return
+	}
+	names = make([]string, 0)
+	for _, name := range strings.Split(chain, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return
+}
@@ -0,0 +1,167 @@
+package authProvider
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/password"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/tfClient"
+	"github.com/zhangjunfang/im/token"
+)
+
+func init() {
+	Register(new(SqlProvider))
+	Register(new(TimUserProvider))
+	Register(new(HttpProvider))
+	Register(new(TokenProvider))
+	Register(new(FileProvider))
+}
+
+/**tim.mysql.passwordSQL 业务库验证，依次执行 passwordSQL passwordSQL1...passwordSQL4*/
+type SqlProvider struct{}
+
+func (this *SqlProvider) Name() string {
+	return "sql"
+}
+
+func (this *SqlProvider) Auth(tid *protocol.Tid, pwd string) (result Result) {
+	sqls := daoService.PasswordSQLs()
+	if len(sqls) == 0 {
+		return UNKNOWN
+	}
+	for _, passwordSQL := range sqls {
+		stored, ok, err := daoService.GetPassword4Sql(passwordSQL, tid)
+		if err != nil {
+			logger.Error("sql provider:", err.Error())
+			result = ERROR
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if ok, rehash := password.Verify(stored, pwd); ok {
+			if rehash && common.CF().GetKV("tim.mysql.passwordUpdateSQL", "") != "" {
+				go rehashPassword(tid, pwd, daoService.UpdatePassword4Sql)
+			}
+			return SUCCESS
+		}
+		result = FAILED
+	}
+	return
+}
+
+/**tim_user 表验证*/
+type TimUserProvider struct{}
+
+func (this *TimUserProvider) Name() string {
+	return "timuser"
+}
+
+func (this *TimUserProvider) Auth(tid *protocol.Tid, pwd string) Result {
+	stored, ok, err := daoService.GetUserPassword(tid)
+	if err != nil {
+		logger.Error("timuser provider:", err.Error())
+		return ERROR
+	}
+	if !ok {
+		return UNKNOWN
+	}
+	if ok, rehash := password.Verify(stored, pwd); ok {
+		if rehash {
+			go rehashPassword(tid, pwd, daoService.UpdateUserPassword)
+		}
+		return SUCCESS
+	}
+	return FAILED
+}
+
+/**
+ * 回调业务系统验证 TimRemoteUserAuth
+ * user_auth_url.domain 优先于 user_auth_url
+ */
+type HttpProvider struct{}
+
+func (this *HttpProvider) Name() string {
+	return "http"
+}
+
+func (this *HttpProvider) Auth(tid *protocol.Tid, pwd string) (result Result) {
+	user_auth_url := common.CF().GetKV(fmt.Sprint("user_auth_url.", tid.GetDomain()), "")
+	if user_auth_url == "" {
+		user_auth_url = common.CF().GetKV("user_auth_url", "")
+	}
+	if len(user_auth_url) <= 9 {
+		return UNKNOWN
+	}
+	result = FAILED
+	err := tfClient.HttpClient(func(client *protocol.ImClient) (er error) {
+		defer func() {
+			if err := recover(); err != nil {
+				er = errors.New(fmt.Sprint(err))
+				logger.Error(string(debug.Stack()))
+			}
+		}()
+		r, er := client.TimRemoteUserAuth(tid, pwd, nil)
+		if er == nil && r != nil {
+			logger.Debug(r)
+			if r.ExtraMap != nil {
+				if stored, ok := r.ExtraMap["password"]; ok {
+					if password.Equal(pwd, stored) {
+						result = SUCCESS
+					}
+				}
+				if extraAuth, ok := r.ExtraMap["extraAuth"]; ok {
+					if password.Equal(pwd, extraAuth) {
+						result = SUCCESS
+					}
+				}
+			}
+		}
+		return er
+	}, user_auth_url)
+	if err != nil {
+		logger.Error("http provider:", err.Error())
+		return ERROR
+	}
+	return
+}
+
+/**签名令牌 本地验证，pwd不是令牌格式时不处理*/
+type TokenProvider struct{}
+
+func (this *TokenProvider) Name() string {
+	return "token"
+}
+
+func (this *TokenProvider) Auth(tid *protocol.Tid, pwd string) Result {
+	if !token.IsToken(pwd) {
+		return UNKNOWN
+	}
+	_, er := token.Verify(pwd, tid.GetName(), tid.GetDomain())
+	if er != nil {
+		logger.Warn("token provider:", tid.GetName(), " ", er.Error())
+		return FAILED
+	}
+	return SUCCESS
+}
+
+/**旧格式或参数变化的密码，验证通过后按 authProvider.passwordHash 重新加密保存*/
+func rehashPassword(tid *protocol.Tid, pwd string, update func(tid *protocol.Tid, encryptedpassword string) error) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	encryptedpassword, err := password.Hash(pwd)
+	if err == nil {
+		err = update(tid, encryptedpassword)
+	}
+	if err != nil {
+		logger.Error("rehash password error:", tid.GetName(), " ", err.Error())
+	}
+}
@@ -0,0 +1,91 @@
+/**
+ * 输入状态等临时会话状态
+ * 通过presence发送，不保存，不做离线
+ */
+package chatState
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+const (
+	CHAT      = "chatstate"      //单聊 presence type
+	GROUPCHAT = "groupchatstate" //群聊 presence type
+
+	TYPING    = "typing"
+	PAUSED    = "paused"
+	RECORDING = "recording"
+)
+
+type last struct {
+	state string
+	time  int64
+}
+
+var lastMap = make(map[string]*last, 0)
+var lock = new(sync.Mutex)
+
+func init() {
+	go clean()
+}
+
+func IsChatState(_type string) bool {
+	return _type == CHAT || _type == GROUPCHAT
+}
+
+func Valid(state string) bool {
+	switch state {
+	case TYPING, PAUSED, RECORDING:
+		return true
+	}
+	return false
+}
+
+/**
+ * 发送频率限制 同一发送者到同一目标(domain+name，单聊与群分开计算)
+ * 相同状态 chatstate.interval(群 chatstate.room.interval) 毫秒内只发送一次
+ * 不同状态 chatstate.min 毫秒内只发送一次
+ */
+func Allow(from, to *protocol.Tid, state string, room bool) bool {
+	interval := utils.Atoi64(common.CF().GetKV("chatstate.interval", "2000"))
+	if room {
+		interval = utils.Atoi64(common.CF().GetKV("chatstate.room.interval", "5000"))
+	}
+	min := utils.Atoi64(common.CF().GetKV("chatstate.min", "500"))
+	key := fmt.Sprint(from.GetDomain(), "/", from.GetName(), "/", from.GetResource(), "|", room, "|", to.GetDomain(), "/", to.GetName())
+	now := time.Now().UnixNano() / 1e6
+	lock.Lock()
+	defer lock.Unlock()
+	if l, ok := lastMap[key]; ok {
+		if d := now - l.time; d < min || (l.state == state && d < interval) {
+			return false
+		}
+	}
+	lastMap[key] = &last{state, now}
+	return true
+}
+
+/**群人数超过 chatstate.room.max 时不发送，缺省500*/
+func RoomAllow(count int) bool {
+	return count <= utils.Atoi(common.CF().GetKV("chatstate.room.max", "500"))
+}
+
+func clean() {
+	for {
+		time.Sleep(60 * time.Second)
+		now := time.Now().UnixNano() / 1e6
+		lock.Lock()
+		for k, l := range lastMap {
+			if now-l.time > 60000 {
+				delete(lastMap, k)
+			}
+		}
+		lock.Unlock()
+	}
+}
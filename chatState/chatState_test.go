@@ -0,0 +1,29 @@
+package chatState
+
+import (
+	"testing"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/protocol"
+)
+
+func Test_allow(t *testing.T) {
+	defer common.SetCF(common.CF())
+	lastMap = make(map[string]*last, 0)
+	common.SetKV(map[string]string{"chatstate.interval": "60000", "chatstate.min": "0"})
+	from, to := &protocol.Tid{Name: "a"}, &protocol.Tid{Name: "b"}
+	if !Allow(from, to, TYPING, false) {
+		t.Fatal("first typing must allow")
+	}
+	if Allow(from, to, TYPING, false) {
+		t.Fatal("same state must throttle")
+	}
+	if !Allow(from, to, PAUSED, false) {
+		t.Fatal("state change must allow")
+	}
+	//其他domain的同名用户、同名的群分开计算
+	domain := "other.com"
+	if !Allow(from, &protocol.Tid{Name: "b", Domain: &domain}, PAUSED, false) || !Allow(from, to, PAUSED, true) {
+		t.Fatal("other target must allow")
+	}
+}
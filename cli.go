@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zhangjunfang/im/authProvider"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/conf"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/httpSign"
+	"github.com/zhangjunfang/im/password"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/service"
+	"github.com/zhangjunfang/im/tfClient"
+	"github.com/zhangjunfang/im/ticker"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * 命令行
+ *    im serve [-f im.xml] [-c cluster.xml] [-d debug] [-s Key=value]...
+ *    im check-config | version | user add/passwd/disable | domain add/list | room create | send | online
+ * 不带子命令时兼容旧的参数格式 im f im.xml c cluster.xml d debug s Key=value
+ */
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"serve":        {"启动服务", serve},
+		"check-config": {"检查 im 与 cluster 配置", checkConfig},
+		"version":      {"版本信息", version},
+		"user":         {"user add|passwd|disable  用户管理", user},
+		"domain":       {"domain add|list  域名管理", domain},
+		"room":         {"room create  新建群", room},
+		"send":         {"向运行中的节点投递一条信息", send},
+		"online":       {"查询运行中的节点的在线用户", online},
+		"help":         {"帮助", help},
+	}
+}
+
+func help(args []string) error {
+	names := make([]string, 0, len(commands))
+	for name, _ := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("usage: im <command> [flags]")
+	for _, name := range names {
+		fmt.Printf("  %-14s %s\n", name, commands[name].usage)
+	}
+	return nil
+}
+
+/**Key=value 可重复的参数*/
+type kvFlag map[string]string
+
+func (this kvFlag) String() string {
+	return fmt.Sprint(map[string]string(this))
+}
+
+func (this kvFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.New(fmt.Sprint("must be Key=value: ", s))
+	}
+	this[kv[0]] = kv[1]
+	return nil
+}
+
+/**配置相关的公共参数*/
+type confFlags struct {
+	imconf      string
+	clusterconf string
+	loglevel    string
+	sets        kvFlag
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *confFlags) {
+	wd, _ := os.Getwd()
+	cf := &confFlags{sets: make(kvFlag, 0)}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cf.imconf, "f", fmt.Sprint(wd, "/im.xml"), "im配置文件 xml yaml toml")
+	fs.StringVar(&cf.clusterconf, "c", fmt.Sprint(wd, "/cluster.xml"), "集群配置文件")
+	fs.StringVar(&cf.loglevel, "d", "", "日志级别 debug info warn error")
+	fs.Var(cf.sets, "s", "覆盖配置 Key=value，可重复")
+	return fs, cf
+}
+
+/**命令行配置 优先于配置文件与环境变量*/
+func (this *confFlags) flags() map[string]string {
+	flags := make(map[string]string, 0)
+	for k, v := range this.sets {
+		flags[k] = v
+	}
+	if this.loglevel != "" {
+		flags["LogLevel"] = this.loglevel
+	}
+	return flags
+}
+
+func (this *confFlags) load() error {
+	return common.CF().Load(this.imconf, this.flags())
+}
+
+/**管理命令 加载配置并连接数据库*/
+func (this *confFlags) openDb() error {
+	if err := this.load(); err != nil {
+		return err
+	}
+	if common.CF().Db_Exsit == 0 {
+		return daoService.ErrNoDb
+	}
+	service.SetLogLevel(common.CF().LogLevel)
+	initGdao()
+	daoService.AddConf()
+	return nil
+}
+
+/**旧的参数格式 f im.xml 转为 -f im.xml*/
+func legacyArgs(args []string) ([]string, error) {
+	if len(args)%2 != 0 {
+		return nil, errors.New(fmt.Sprint("flag's length is ", len(args)))
+	}
+	flags := make([]string, 0, len(args))
+	for i := 0; i < len(args); i += 2 {
+		switch args[i] {
+		case "f", "c", "d", "s":
+			flags = append(flags, "-"+args[i], args[i+1])
+		default:
+			return nil, errors.New(fmt.Sprint("error arg: ", args[i]))
+		}
+	}
+	return flags, nil
+}
+
+func serve(args []string) error {
+	fs, cf := newFlagSet("serve")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	banner()
+	//初始化im配置 缺省值 -> 配置文件 -> 环境变量IM_* -> 命令行
+	if err := cf.load(); err != nil {
+		return err
+	}
+	service.SetConfFile(cf.imconf, cf.clusterconf, cf.flags())
+	//日志初始化  并使用控制和文件两种通道生成文件
+	initLog()
+	//集群配置解析
+	cluster.InitCluster(cf.clusterconf)
+	//初始化数据访问层
+	initGdao()
+	//初始化数据服务层=============================
+	daoService.InitDaoservice()
+	//
+	ticker.TickerStart()
+	//
+	go handleSignal()
+	service.ServerStart()
+	return nil
+}
+
+func checkConfig(args []string) error {
+	fs, cf := newFlagSet("check-config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cf.load(); err != nil {
+		return err
+	}
+	fmt.Println("im config ok:", cf.imconf)
+	ok, err := common.NewClusterConf().Load(cf.clusterconf)
+	if err != nil {
+		return err
+	}
+	if ok {
+		fmt.Println("cluster config ok:", cf.clusterconf)
+	} else {
+		fmt.Println("cluster not configured")
+	}
+	return nil
+}
+
+func version(args []string) error {
+	fmt.Println(common.VersionName, "build", common.VersionCode, "protocol", protocol.ProtocolversionName)
+	return nil
+}
+
+func newTid(name, domain string) (*protocol.Tid, error) {
+	if name == "" || domain == "" {
+		return nil, errors.New("name and domain are required")
+	}
+	tid := protocol.NewTid()
+	tid.Name, tid.Domain = name, &domain
+	return tid, nil
+}
+
+func user(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: im user add|passwd|disable -name xxx -domain xxx [-password xxx] [-nick xxx] [-addr http://node -token xxx]")
+	}
+	fs, cf := newFlagSet("user " + args[0])
+	name := fs.String("name", "", "用户名")
+	domain := fs.String("domain", "", "域名")
+	pwd := fs.String("password", "", "密码")
+	nick := fs.String("nick", "", "昵称")
+	addr := fs.String("addr", "", "disable时踢下线使用的节点http地址 如 http://127.0.0.1:9090")
+	token := fs.String("token", "", "disable时使用的 admin.api.token，缺省取配置")
+	apikeyFlag, secretFlag := httpFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	tid, err := newTid(*name, *domain)
+	if err != nil {
+		return err
+	}
+	if err = cf.openDb(); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "add":
+		if *pwd == "" {
+			return errors.New("password is required")
+		}
+		var encryptedpassword string
+		if encryptedpassword, err = password.Hash(*pwd); err != nil {
+			return err
+		}
+		err = daoService.AddUser(tid, encryptedpassword, *nick)
+	case "passwd":
+		//重新设置密码同时解除禁用
+		err = authProvider.SetPassword(tid, *pwd)
+	case "disable":
+		//数据库中禁用只阻止新的登陆，在线的连接需要运行中的节点踢下线
+		if err = daoService.DisableUser(tid); err == nil {
+			if er := kickUser(tid, *addr, *token, *apikeyFlag, *secretFlag, cf); er != nil {
+				return errors.New(fmt.Sprint("user disabled, but online sessions are not kicked (a running node is required, see -addr -token): ", er.Error()))
+			}
+		}
+	default:
+		return errors.New(fmt.Sprint("unknown user command: ", args[0]))
+	}
+	if err == nil {
+		fmt.Println("user", args[0], "ok:", *name, "@", *domain)
+	}
+	return err
+}
+
+func domain(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: im domain add -domain xxx [-remark xxx] | im domain list")
+	}
+	fs, cf := newFlagSet("domain " + args[0])
+	domain := fs.String("domain", "", "域名")
+	remark := fs.String("remark", "", "备注")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := cf.openDb(); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "add":
+		if *domain == "" {
+			return errors.New("domain is required")
+		}
+		if err := daoService.AddDomain(*domain, *remark); err != nil {
+			return err
+		}
+		fmt.Println("domain add ok:", *domain)
+	case "list":
+		domains, err := daoService.ListDomains()
+		if err != nil {
+			return err
+		}
+		for _, d := range domains {
+			fmt.Println(d.GetDomain(), "\t", d.GetCreatetime(), "\t", d.GetRemark())
+		}
+	default:
+		return errors.New(fmt.Sprint("unknown domain command: ", args[0]))
+	}
+	return nil
+}
+
+func room(args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New("usage: im room create -room xxx -domain xxx [-name xxx] [-members a,b,c]")
+	}
+	fs, cf := newFlagSet("room create")
+	roomname := fs.String("room", "", "群id")
+	domain := fs.String("domain", "", "域名")
+	name := fs.String("name", "", "群名称")
+	members := fs.String("members", "", "群成员，逗号分隔")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	roomid, err := newTid(*roomname, *domain)
+	if err != nil {
+		return err
+	}
+	if err = cf.openDb(); err != nil {
+		return err
+	}
+	tidnames := make([]string, 0)
+	for _, m := range strings.Split(*members, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			tidnames = append(tidnames, m)
+		}
+	}
+	if err = daoService.CreateRoom(roomid, *name, tidnames); err == nil {
+		fmt.Println("room create ok:", *roomname, "@", *domain, " members:", len(tidnames))
+	}
+	return err
+}
+
+/**运行中节点的http地址 缺省为本机 HttpPort*/
+func nodeAddr(addr string, cf *confFlags) (string, error) {
+	if addr != "" {
+		return strings.TrimSuffix(addr, "/"), nil
+	}
+	if err := cf.load(); err != nil {
+		return "", err
+	}
+	if common.CF().GetHttpPort() <= 0 {
+		return "", errors.New("HttpPort is not set, use -addr")
+	}
+	return fmt.Sprint("http://127.0.0.1:", common.CF().GetHttpPort()), nil
+}
+
+/**通过运行中节点的 /api/users/disable 禁用并踢下线，集群模式下踢所有节点上的连接*/
+func kickUser(tid *protocol.Tid, addr, token, apikey, secret string, cf *confFlags) error {
+	url, err := nodeAddr(addr, cf)
+	if err != nil {
+		return err
+	}
+	if token, err = conf.ReadSecret(token); err != nil {
+		return err
+	}
+	if token == "" {
+		token = common.CF().GetKV("admin.api.token", "")
+	}
+	if token == "" {
+		return errors.New("admin.api.token is not set")
+	}
+	apikey, secret, err = httpCredential(apikey, secret)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(map[string]string{"domain": tid.GetDomain(), "name": tid.GetName()})
+	req, err := http.NewRequest("POST", fmt.Sprint(url, "/api/users/disable"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	_, err = doHttp(req, apikey, secret, body)
+	return err
+}
+
+/**http.auth 为 key 或 hmac 时使用，以 file:// 开头时从文件读取*/
+func httpFlags(fs *flag.FlagSet) (apikey, secret *string) {
+	apikey = fs.String("apikey", "", "请求头 X-Api-Key")
+	secret = fs.String("secret", "", "HMAC签名密钥 http.hmac.secret")
+	return
+}
+
+func httpCredential(apikey, secret string) (string, string, error) {
+	apikey, err := conf.ReadSecret(apikey)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err = conf.ReadSecret(secret)
+	return apikey, secret, err
+}
+
+/**通过运行中节点的 /tim 接口投递信息，与业务系统调用 TimResponseMessage 相同*/
+func send(args []string) error {
+	fs, cf := newFlagSet("send")
+	addr := fs.String("addr", "", "节点http地址 如 http://127.0.0.1:9090")
+	from := fs.String("from", "", "发送者")
+	to := fs.String("to", "", "接收者")
+	domain := fs.String("domain", "", "域名")
+	body := fs.String("body", "", "信息内容")
+	typ := fs.String("type", "chat", "信息类型 chat groupchat")
+	apikeyFlag, secretFlag := httpFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fromTid, err := newTid(*from, *domain)
+	if err != nil {
+		return err
+	}
+	toTid, err := newTid(*to, *domain)
+	if err != nil {
+		return err
+	}
+	url, err := nodeAddr(*addr, cf)
+	if err != nil {
+		return err
+	}
+	mbean := protocol.NewTimMBean()
+	mbean.ThreadId = utils.TimeMills()
+	mbean.FromTid, mbean.ToTid, mbean.Body, mbean.Type = fromTid, toTid, body, typ
+	var r *protocol.TimResponseBean
+	apikey, secret, err := httpCredential(*apikeyFlag, *secretFlag)
+	if err != nil {
+		return err
+	}
+	err = tfClient.SignedHttpClient(func(client *protocol.ImClient) (er error) {
+		r, er = client.TimResponseMessage(mbean, protocol.NewTimAuth())
+		return
+	}, fmt.Sprint(url, "/tim"), apikey, secret)
+	if err != nil {
+		return err
+	}
+	if r == nil || r.Error != nil || r.ExtraMap == nil {
+		return errors.New(fmt.Sprint("send failed: ", r))
+	}
+	fmt.Println("send ok, mid:", r.ExtraMap["mid"], " offline:", r.ExtraMap["offline"])
+	return nil
+}
+
+/**查询运行中节点的 /uinfo*/
+func online(args []string) error {
+	fs, cf := newFlagSet("online")
+	addr := fs.String("addr", "", "节点http地址 如 http://127.0.0.1:9090")
+	apikeyFlag, secretFlag := httpFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	url, err := nodeAddr(*addr, cf)
+	if err != nil {
+		return err
+	}
+	apikey, secret, err := httpCredential(*apikeyFlag, *secretFlag)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", fmt.Sprint(url, "/uinfo"), nil)
+	if err != nil {
+		return err
+	}
+	bs, err := doHttp(req, apikey, secret, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(bs))
+	return nil
+}
+
+/**按 http.auth 加上 X-Api-Key 或签名后请求，非200时返回错误*/
+func doHttp(req *http.Request, apikey, secret string, body []byte) ([]byte, error) {
+	if apikey != "" {
+		req.Header.Set(httpSign.HEADER_KEY, apikey)
+	}
+	if secret != "" {
+		httpSign.SignRequest(req, secret, body)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprint(resp.Status, " ", string(bs)))
+	}
+	return bs, nil
+}
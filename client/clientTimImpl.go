@@ -6,6 +6,7 @@ import (
 
 	"github.com/donnie4w/go-logger/logger"
 	. "github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/connect"
 	"github.com/zhangjunfang/im/daoService"
 	. "github.com/zhangjunfang/im/protocol"
 	"github.com/zhangjunfang/im/route"
@@ -129,6 +130,11 @@ func _TimResponsePresence(pbean *TimPBean, auth *TimAuth) (r *TimResponseBean, e
 			logger.Error("error:", er)
 		}
 	}()
+	if pbean.GetType() == connect.KICK {
+		//其他节点的踢下线
+		connect.TP.Kick(pbean.GetToTid())
+		return
+	}
 	if pbean.GetToTid() == nil {
 		route.RoutePBean(pbean)
 	} else {
@@ -172,7 +178,7 @@ func _TimResponseMessage(mbean *TimMBean, auth *TimAuth) (r *TimResponseBean, er
 	timestamp := utils.TimeMills()
 	mbean.Timestamp = &timestamp
 	if isTotidExist {
-		_, err, _ = route.RouteMBean(mbean, false, false)
+		_, err, _, _ = route.RouteMBean(mbean, false, false)
 	} else {
 		err = errors.New("TimResponseMessage totid not exist")
 	}
@@ -200,7 +206,7 @@ func (this *TimImpl) TimRoser(roster *TimRoster) (err error) {
 }
 
 func checkAuth(a *TimAuth) bool {
-	if a.GetDomain() == ClusterConf.Domain && a.GetUsername() == ClusterConf.Username && a.GetPwd() == ClusterConf.Password {
+	if a.GetDomain() == ClusterConf().Domain && a.GetUsername() == ClusterConf().Username && a.GetPwd() == ClusterConf().Password {
 		return true
 	}
 	return false
@@ -247,8 +253,16 @@ func _TimResponsePresenceList(pbeanList *TimPBeanList, auth *TimAuth) (r *TimRes
 		}
 	}()
 	if pbeanList != nil && pbeanList.GetTimPBeanList() != nil && len(pbeanList.GetTimPBeanList()) > 0 {
-		if ClusterConf.Interflow > 0 && len(pbeanList.GetTimPBeanList()) > 1 {
-			route.RoutePBeanList(pbeanList.GetTimPBeanList())
+		if ClusterConf().Interflow > 0 && len(pbeanList.GetTimPBeanList()) > 1 {
+			pbeans := make([]*TimPBean, 0, len(pbeanList.GetTimPBeanList()))
+			for _, pbean := range pbeanList.GetTimPBeanList() {
+				if pbean.GetType() == connect.KICK {
+					connect.TP.Kick(pbean.GetToTid())
+				} else {
+					pbeans = append(pbeans, pbean)
+				}
+			}
+			route.RoutePBeanList(pbeans)
 		} else {
 			for _, pbean := range pbeanList.GetTimPBeanList() {
 				_TimResponsePresence(pbean, auth)
@@ -282,7 +296,7 @@ func _TimResponseMessageList(mbeanList *TimMBeanList, auth *TimAuth) (r *TimResp
 		}
 	}()
 	if mbeanList != nil && mbeanList.GetTimMBeanList() != nil && len(mbeanList.GetTimMBeanList()) > 0 {
-		if ClusterConf.Interflow > 0 && len(mbeanList.GetTimMBeanList()) > 1 {
+		if ClusterConf().Interflow > 0 && len(mbeanList.GetTimMBeanList()) > 1 {
 			route.RouteMBeanList(mbeanList.GetTimMBeanList(), true)
 		} else {
 			for _, mbean := range mbeanList.GetTimMBeanList() {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,10 +31,14 @@ var sha1Getcmd *ScriptCmd
 
 //集群配置文件解析
 func InitCluster(filexml string) {
-	//解析配置文件  并通过反射给结构体初始化
-	if ClusterConf.Init(filexml) {
+	//解析配置文件与环境变量  并通过反射给结构体初始化
+	ok, err := ClusterConf().Load(filexml)
+	if err != nil {
+		logger.Error("cluster config error:", err.Error())
+		os.Exit(1)
+	}
+	if ok {
 		Redis.initPool() //初始化默认连接pool
-		var err error
 		flag, err = Redis.Ping() //验证redis服务时候正常
 		if !flag && err != nil {
 			logger.Error("redis connect failed:", err.Error())
@@ -44,8 +49,13 @@ func InitCluster(filexml string) {
 	}
 }
 
+/**本节点的集群地址*/
+func LocalAddr() string {
+	return formatAddr(parseAddr(ClusterConf().RequestAddr))
+}
+
 func IsCluster() bool {
-	return flag && ClusterConf.IsCluster == 1
+	return flag && ClusterConf().IsCluster == 1
 }
 
 func GetUserBeans(loginname string) (beans []*CluserUserBean, err error) {
@@ -59,7 +69,7 @@ func GetUserBeans(loginname string) (beans []*CluserUserBean, err error) {
 	if err == nil {
 		beans = make([]*CluserUserBean, 0)
 		for _, addr := range addrs {
-			localAddr := parseAddr(ClusterConf.RequestAddr)
+			localAddr := parseAddr(ClusterConf().RequestAddr)
 			if localAddr != addr {
 				cu := new(CluserUserBean)
 				cu.Tcp_addr = formatAddr(addr)
@@ -76,12 +86,38 @@ func GetUserBeans(loginname string) (beans []*CluserUserBean, err error) {
  *   loginname        ip:tcp_port		http_port
  */
 func SetLoginnameToCluster(loginname string) (err error) {
-	_, err = sha1Addcmd.EvalSha(loginname, utils.MD5(fmt.Sprint(loginname, parseAddr(ClusterConf.RequestAddr))), fmt.Sprint(ClusterConf.Keytimeout), parseAddr(ClusterConf.RequestAddr))
+	_, err = sha1Addcmd.EvalSha(loginname, utils.MD5(fmt.Sprint(loginname, parseAddr(ClusterConf().RequestAddr))), fmt.Sprint(ClusterConf().Keytimeout), parseAddr(ClusterConf().RequestAddr))
+	return
+}
+
+/**
+ * 批量续期本节点的登录名与resource
+ * users key为登录名 value为该登录名在本节点的resource
+ */
+func RefreshToCluster(users map[string][]string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			logger.Error(string(debug.Stack()))
+			err = errors.New(fmt.Sprint(er))
+		}
+	}()
+	addr := parseAddr(ClusterConf().RequestAddr)
+	timeout := fmt.Sprint(ClusterConf().Keytimeout)
+	args := make([][]interface{}, 0, len(users))
+	for loginname, resources := range users {
+		args = append(args, []interface{}{loginname, utils.MD5(fmt.Sprint(loginname, addr)), timeout, addr})
+		for _, resource := range resources {
+			args = append(args, []interface{}{fmt.Sprint("tim_res_", loginname), utils.MD5(fmt.Sprint(loginname, resource, addr)), timeout, fmt.Sprint(resource, " ", addr)})
+		}
+	}
+	if len(args) > 0 {
+		err = sha1Addcmd.EvalBatch(args)
+	}
 	return
 }
 
 func DelLoginnameFromCluter(loginname string) (err error) {
-	err = Redis.Del(utils.MD5(fmt.Sprint(loginname, parseAddr(ClusterConf.RequestAddr))))
+	err = Redis.Del(utils.MD5(fmt.Sprint(loginname, parseAddr(ClusterConf().RequestAddr))))
 	return
 }
 
@@ -90,6 +126,77 @@ func GetLoginnameFromCluter(loginname string) (addrs []string, err error) {
 	return
 }
 
+/**
+ *   resource级别的位置
+ *   key                  field                                   value
+ *   tim_res_loginname    md5(loginname resource ip:tcp_port)     resource ip:tcp_port
+ */
+func SetResourceToCluster(loginname, resource string) (err error) {
+	addr := parseAddr(ClusterConf().RequestAddr)
+	_, err = sha1Addcmd.EvalSha(fmt.Sprint("tim_res_", loginname), utils.MD5(fmt.Sprint(loginname, resource, addr)), fmt.Sprint(ClusterConf().Keytimeout), fmt.Sprint(resource, " ", addr))
+	return
+}
+
+func DelResourceFromCluster(loginname, resource string) (err error) {
+	err = Redis.Del(utils.MD5(fmt.Sprint(loginname, resource, parseAddr(ClusterConf().RequestAddr))))
+	return
+}
+
+/**
+ * resource所在的其他节点
+ * local为true时resource在本节点
+ */
+func GetResourceBeans(loginname, resource string) (beans []*CluserUserBean, local bool, err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			logger.Error(string(debug.Stack()))
+			err = errors.New(fmt.Sprint(er))
+		}
+	}()
+	values, err := sha1Getcmd.EvalShaStrings(fmt.Sprint("tim_res_", loginname))
+	if err != nil {
+		return
+	}
+	localAddr := parseAddr(ClusterConf().RequestAddr)
+	beans = make([]*CluserUserBean, 0)
+	for _, value := range values {
+		i := strings.LastIndex(value, " ")
+		if i < 0 || value[:i] != resource {
+			continue
+		}
+		if addr := value[i+1:]; addr == localAddr {
+			local = true
+		} else {
+			cu := new(CluserUserBean)
+			cu.Tcp_addr = formatAddr(addr)
+			cu.Lock = new(sync.Mutex)
+			beans = append(beans, cu)
+		}
+	}
+	return
+}
+
+/**用户在所有节点的resource key为resource value为节点地址*/
+func GetResources(loginname string) (resources map[string][]string, err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			logger.Error(string(debug.Stack()))
+			err = errors.New(fmt.Sprint(er))
+		}
+	}()
+	values, err := sha1Getcmd.EvalShaStrings(fmt.Sprint("tim_res_", loginname))
+	if err != nil {
+		return
+	}
+	resources = make(map[string][]string, 0)
+	for _, value := range values {
+		if i := strings.LastIndex(value, " "); i >= 0 {
+			resources[value[:i]] = append(resources[value[:i]], formatAddr(value[i+1:]))
+		}
+	}
+	return
+}
+
 func (this *CluserUserBean) SendMBean(tmb *TimMBean) (err error) {
 	defer func() {
 		if er := recover(); er != nil {
@@ -100,7 +207,7 @@ func (this *CluserUserBean) SendMBean(tmb *TimMBean) (err error) {
 	}()
 	this.Lock.Lock()
 	defer this.Lock.Unlock()
-	if ClusterConf.Interflow > 0 {
+	if ClusterConf().Interflow > 0 {
 		er := InterflowSendMBean(this.Tcp_addr, tmb)
 		if er != nil {
 			return _sendMBean(this.Tcp_addr, tmb, 3)
@@ -138,7 +245,7 @@ func (this *CluserUserBean) SendPBean(tmpb *TimPBean) (err error) {
 	}()
 	this.Lock.Lock()
 	defer this.Lock.Unlock()
-	if ClusterConf.Interflow > 0 {
+	if ClusterConf().Interflow > 0 {
 		er := InterflowSendPBean(this.Tcp_addr, tmpb)
 		if er != nil {
 			client := Pool.Get(this.Tcp_addr)
@@ -172,7 +279,7 @@ func GetRandomTBString(length int) (s string) {
 
 func NewAuth() (ta *TimAuth) {
 	ta = NewTimAuth()
-	domain, name, pwd := ClusterConf.Domain, ClusterConf.Username, ClusterConf.Password
+	domain, name, pwd := ClusterConf().Domain, ClusterConf().Username, ClusterConf().Password
 	ta.Domain, ta.Username, ta.Pwd = &domain, &name, &pwd
 	return
 }
@@ -189,6 +296,30 @@ type InterflowPool struct {
 
 var IFPool = &InterflowPool{pool: NewHashTable(), pool2: NewHashTable()}
 
+// 运行中的interflow发送协程
+var interflows = new(sync.WaitGroup)
+
+/**立即发送所有interflow缓存的信息，等待发送完成 超时返回false*/
+func FlushInterflow(timeout time.Duration) bool {
+	for _, v := range append(IFPool.pool.GetValues(), IFPool.pool2.GetValues()...) {
+		ib := v.(*InterflowBean)
+		ib.Lock.Lock()
+		ib.status = 1
+		ib.Lock.Unlock()
+	}
+	done := make(chan bool)
+	go func() {
+		interflows.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func (this *InterflowPool) add(addr string, ib *InterflowBean, _type int) {
 	//	this.lock.Lock()
 	//	defer this.lock.Unlock()
@@ -238,24 +369,27 @@ type InterflowBean struct {
 }
 
 func NewInterflowBean(addr string, _type int) (ib *InterflowBean) {
-	ib = &InterflowBean{Addr: addr, Lock: new(sync.Mutex), sendTimestamp: utils.Atoi64(utils.TimeMills()) + int64(ClusterConf.Interflow*1000)}
+	ib = &InterflowBean{Addr: addr, Lock: new(sync.Mutex), sendTimestamp: utils.Atoi64(utils.TimeMills()) + int64(ClusterConf().Interflow*1000)}
 	if _type == 1 {
+		interflows.Add(1)
 		go ib._sendMBean()
 	}
 	if _type == 2 {
+		interflows.Add(1)
 		go ib._sendPBean()
 	}
 	return
 }
 
 func (this *InterflowBean) _sendMBean() {
+	defer interflows.Done()
 	defer func() {
 		if er := recover(); er != nil {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
 	for {
-		timer := time.NewTicker(time.Duration(ClusterConf.Interflow) * time.Millisecond)
+		timer := time.NewTicker(time.Duration(ClusterConf().Interflow) * time.Millisecond)
 		select {
 		case <-timer.C:
 			if this.status == 1 || this.TimMBeanList != nil {
@@ -281,13 +415,14 @@ ENDPOOL:
 }
 
 func (this *InterflowBean) _sendPBean() {
+	defer interflows.Done()
 	defer func() {
 		if er := recover(); er != nil {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
 	for {
-		timer := time.NewTicker(time.Duration(ClusterConf.Interflow) * time.Millisecond)
+		timer := time.NewTicker(time.Duration(ClusterConf().Interflow) * time.Millisecond)
 		select {
 		case <-timer.C:
 			if this.status == 1 || this.TimPBeanList != nil {
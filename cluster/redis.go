@@ -22,14 +22,14 @@ func (this *RedisClient) initPool() {
 		MaxActive:   30,
 		IdleTimeout: 180 * time.Second,
 		Dial: func() (redisConn redis.Conn, err error) {
-			redisConn, err = redis.DialTimeout("tcp", ClusterConf.RedisAddr, 5*time.Second, 2*time.Second, 2*time.Second)
+			redisConn, err = redis.DialTimeout("tcp", ClusterConf().RedisAddr, 5*time.Second, 2*time.Second, 2*time.Second)
 			if err != nil {
 				return nil, err
 			}
-			if ClusterConf.RedisPwd != "" {
-				redisConn.Do("AUTH", ClusterConf.RedisPwd)
+			if ClusterConf().RedisPwd != "" {
+				redisConn.Do("AUTH", ClusterConf().RedisPwd)
 			}
-			redisConn.Do("SELECT", ClusterConf.RedisDB)
+			redisConn.Do("SELECT", ClusterConf().RedisDB)
 			return
 		},
 	}
@@ -45,10 +45,10 @@ func (this *RedisClient) initRedisClient(addr, pwd string) {
 			if err != nil {
 				return nil, err
 			}
-			if ClusterConf.RedisPwd != "" {
+			if ClusterConf().RedisPwd != "" {
 				redisConn.Do("AUTH", pwd)
 			}
-			redisConn.Do("SELECT", ClusterConf.RedisDB)
+			redisConn.Do("SELECT", ClusterConf().RedisDB)
 			return
 		},
 	}
@@ -287,6 +287,26 @@ func (this *ScriptCmd) EvalShaStrings(arg ...interface{}) (reply []string, err e
 	return
 }
 
+/**批量执行 使用pipeline，一次往返*/
+func (this *ScriptCmd) EvalBatch(args [][]interface{}) (err error) {
+	c := Redis.RedisClient.Get()
+	defer c.Close()
+	for _, arg := range args {
+		if err = this.script.Send(c, arg...); err != nil {
+			return
+		}
+	}
+	if err = c.Flush(); err != nil {
+		return
+	}
+	for _ = range args {
+		if _, er := c.Receive(); er != nil {
+			err = er
+		}
+	}
+	return
+}
+
 func (this *RedisClient) NewScript(scriptStr string, keycount int) (scriptcmd *ScriptCmd) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -65,9 +65,39 @@ func ClusterRoutePBean(pbean *TimPBean) (er error) {
 	return
 }
 
+/**抄送到发送者在其他节点的连接*/
+func ClusterRouteCarbon(carbon *TimMBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("ClusterRouteCarbon:", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if cluster.IsCluster() {
+		for _, bean := range OtherClusterUserBean(CarbonTid(carbon)) {
+			bean.SendMBean(carbon)
+		}
+	}
+}
+
+/**
+ * tid带resource时，resource所在节点优先
+ * resource在本节点时返回nil 由本节点投递
+ */
 func OtherClusterUserBean(tid *Tid) (beans []*cluster.CluserUserBean) {
 	var err error
 	loginname, _ := GetLoginName(tid)
+	if resource := tid.GetResource(); resource != "" {
+		rbeans, local, er := cluster.GetResourceBeans(loginname, resource)
+		if er == nil {
+			if local {
+				return nil
+			}
+			if len(rbeans) > 0 {
+				return rbeans
+			}
+		}
+	}
 	beans, err = cluster.GetUserBeans(loginname)
 	if beans == nil || len(beans) == 0 || err != nil {
 		return nil
@@ -16,7 +16,7 @@ type Controlloer struct {
 
 func ServerStart() {
 	s := new(Controlloer)
-	s.SetAddr(ClusterConf.RequestAddr)
+	s.SetAddr(ClusterConf().RequestAddr)
 	s.Server()
 }
 
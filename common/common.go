@@ -1,6 +1,11 @@
 package common
 
-import "github.com/zhangjunfang/im/conf"
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/zhangjunfang/im/conf"
+)
 
 /*版本*/
 var VersionName = "im 1.0"
@@ -8,6 +13,72 @@ var VersionCode = 2
 var Author = "ocean"
 var Email = "zhangjunfang0505@163.com"
 
-var CF = &conf.ConfBean{KV: make(map[string]string, 0), Db_Exsit: 1, MustAuth: 1}
+/**
+ * 当前配置 热加载时生成新的配置整体替换，读取时不需要加锁
+ * 每次使用时调用 CF()，不要长期保存返回值
+ * 已经发布的配置(包括KV map)不能修改，通过 UpdateCF 复制后修改
+ */
+var cf, clusterConf atomic.Value
+
+// 修改配置之间互斥，避免热加载与定时加载KV互相覆盖
+var updateLock = new(sync.Mutex)
+
+func init() {
+	cf.Store(NewConf())
+	clusterConf.Store(NewClusterConf())
+}
+
+func CF() *conf.ConfBean {
+	return cf.Load().(*conf.ConfBean)
+}
+
+func SetCF(c *conf.ConfBean) {
+	cf.Store(c)
+}
+
+/**复制当前配置，修改副本后整体替换*/
+func UpdateCF(f func(old, next *conf.ConfBean)) {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	old := CF()
+	next := *old
+	f(old, &next)
+	SetCF(&next)
+}
+
+/**数据库中的KV 定时加载后整体替换*/
+func SetDbKV(kv map[string]string) {
+	UpdateCF(func(old, next *conf.ConfBean) {
+		next.SetDbKV(kv)
+	})
+}
+
+/**修改部分KV 生成新的map*/
+func SetKV(kv map[string]string) {
+	UpdateCF(func(old, next *conf.ConfBean) {
+		next.KV = make(map[string]string, len(old.KV)+len(kv))
+		for k, v := range old.KV {
+			next.KV[k] = v
+		}
+		for k, v := range kv {
+			next.KV[k] = v
+		}
+	})
+}
+
+func ClusterConf() *conf.ClusterBean {
+	return clusterConf.Load().(*conf.ClusterBean)
+}
+
+func SetClusterConf(c *conf.ClusterBean) {
+	clusterConf.Store(c)
+}
+
+/**带缺省值的配置对象*/
+func NewConf() *conf.ConfBean {
+	return &conf.ConfBean{KV: make(map[string]string, 0), Db_Exsit: 1, MustAuth: 1}
+}
 
-var ClusterConf = &conf.ClusterBean{IsCluster: 1}
+func NewClusterConf() *conf.ClusterBean {
+	return &conf.ClusterBean{IsCluster: 1}
+}
@@ -2,13 +2,9 @@ package conf
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
-	"reflect"
 	"runtime/debug"
-	"strconv"
 
-	"github.com/donnie4w/dom4g"
 	"github.com/donnie4w/go-logger/logger"
 )
 
@@ -37,49 +33,45 @@ func (cb *ClusterBean) Init(filexml string) (b bool) {
 			b = false
 		}
 	}()
-	//判断文件不存在
-	if !isExist(filexml) {
-		return false
-	}
-	//打开文件
-	xmlconfig, err := os.Open(filexml)
-	//异常处理
+	b, err := cb.Load(filexml)
 	if err != nil {
-		panic(fmt.Sprint("xmlconfig is error:", err.Error()))
-		os.Exit(0)
+		logger.Error("Init error", err.Error())
+		b = false
 	}
-	config, err := ioutil.ReadAll(xmlconfig)
-	if err != nil {
-		panic(fmt.Sprint("config is error:", err.Error()))
-		os.Exit(1)
+	return
+}
+
+/**分层加载 配置文件 -> 环境变量IM_CLUSTER_*  文件与环境变量都没有时返回false*/
+func (cb *ClusterBean) Load(filexml string) (ok bool, err error) {
+	if isExist(filexml) {
+		ok = true
+		if err = loadFile(cb, filexml); err != nil {
+			return
+		}
 	}
-	//使用dom4g解析xml
-	dom, err := dom4g.LoadByXml(string(config))
-	if err == nil {
-		nodes := dom.AllNodes()
-		if nodes != nil {
-			for _, node := range nodes {
-				name := node.Name()
-				value := node.Value
-				v := reflect.ValueOf(cb).Elem().FieldByName(name)
-				if v.CanSet() {
-					switch v.Type().Name() {
-					case "string":
-						v.Set(reflect.ValueOf(value))
-					case "int":
-						i, _ := strconv.Atoi(value)
-						v.Set(reflect.ValueOf(i))
-					default:
-						fmt.Println("other type:", v.Type().Name(), ">>>", name)
-					}
-				}
-			}
+	if hasEnv("IM_CLUSTER_") {
+		ok = true
+		if err = loadEnv(cb, "IM_CLUSTER_", ""); err != nil {
+			return
 		}
 	}
-	b = true
+	if ok {
+		err = cb.Validate()
+	}
 	return
 }
 
+/**检查配置是否有效*/
+func (cb *ClusterBean) Validate() error {
+	if cb.IsCluster == 1 && cb.RedisAddr == "" {
+		return &ConfError{"", "RedisAddr", "required when IsCluster is 1"}
+	}
+	if cb.Keytimeout < 0 {
+		return &ConfError{"", "Keytimeout", fmt.Sprint("must not be negative: ", cb.Keytimeout)}
+	}
+	return nil
+}
+
 func isExist(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil || os.IsExist(err)
@@ -3,9 +3,10 @@ package conf
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
-	"reflect"
-	"strconv"
+	"sort"
+	"strings"
 
 	"github.com/donnie4w/dom4g"
 )
@@ -30,7 +31,7 @@ type ConfBean struct {
 
 	Presence int //出席
 
-	KV map[string]string //系统key value
+	KV map[string]string //系统key value 由数据库与配置文件中的KV合并生成，不要直接修改
 
 	ConfirmAck int //发送消息是否需要回执 1需要 0不需要
 
@@ -55,6 +56,17 @@ type ConfBean struct {
 	HbaseIdleTimeOut  int
 
 	DataBase int //0 mysql 1 hbase
+
+	LogLevel string //日志级别 debug info warn error，命令行参数d优先
+
+	HttpAllowIps string //允许访问http接口的ip或CIDR，逗号分隔，为空时不限制
+
+	HttpTrustedProxies string //可信代理的ip或CIDR，逗号分隔，只有来自可信代理的请求才使用X-Forwarded-For
+
+	AdminAddr string //管理端口地址(pprof)，如127.0.0.1:6060，为空时不开启
+
+	fileKV map[string]string //配置文件、环境变量与命令行中的KV，优先于数据库
+	dbKV   map[string]string //数据库 im_config im_property 中的KV
 }
 
 /**设置Ip信息*/
@@ -91,6 +103,54 @@ func (cf *ConfBean) GetDB() (dataSourceName string, maxOpenConns, maxIdleConns i
 	return cf.Db_dataSourceName, cf.Db_MaxOpenConns, cf.Db_MaxIdleConns
 }
 
+/**配置文件、环境变量与命令行中的KV*/
+func (cf *ConfBean) setFileKV(key, value string) {
+	fileKV := make(map[string]string, len(cf.fileKV)+1)
+	for k, v := range cf.fileKV {
+		fileKV[k] = v
+	}
+	fileKV[key] = value
+	cf.fileKV = fileKV
+	cf.mergeKV()
+}
+
+/**数据库中的KV*/
+func (cf *ConfBean) SetDbKV(kv map[string]string) {
+	cf.dbKV = kv
+	cf.mergeKV()
+}
+
+/**热加载时使用新加载的配置文件中的KV 返回变化的key*/
+func (cf *ConfBean) ReloadFileKV(loaded *ConfBean) (changed []string) {
+	old := cf.KV
+	cf.fileKV = loaded.fileKV
+	cf.mergeKV()
+	for k, v := range cf.KV {
+		if ov, ok := old[k]; !ok || ov != v {
+			changed = append(changed, k)
+		}
+	}
+	for k, _ := range old {
+		if _, ok := cf.KV[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return
+}
+
+/**重新生成KV 同名时配置文件优先*/
+func (cf *ConfBean) mergeKV() {
+	kv := make(map[string]string, len(cf.dbKV)+len(cf.fileKV))
+	for k, v := range cf.dbKV {
+		kv[k] = v
+	}
+	for k, v := range cf.fileKV {
+		kv[k] = v
+	}
+	cf.KV = kv
+}
+
 func (cf *ConfBean) GetKV(keyword string, defaultValue string) (value string) {
 	if v, ok := cf.KV[keyword]; ok {
 		value = v
@@ -136,52 +196,144 @@ func (cf *ConfBean) GetConfLoad(defaultTime int) int {
 	return defaultTime
 }
 
-//读取配置文件并解析
+//读取配置文件并解析 文件不存在时使用默认配置
 func (cf *ConfBean) Init(filexml string) {
-	xmlstr := ""
-	if isExist(filexml) {
-		xmlconfig, err := os.Open(filexml)
-		if err != nil {
-			panic(fmt.Sprint("xmlconfig is error:", err.Error()))
-			os.Exit(0)
-		}
-		config, err := ioutil.ReadAll(xmlconfig)
-		if err != nil {
-			panic(fmt.Sprint("config is error:", err.Error()))
-			os.Exit(1)
-		}
-		xmlstr = string(config)
+	if err := cf.Load(filexml, nil); err != nil {
+		panic(fmt.Sprint("config is error:", err.Error()))
+	}
+}
+
+/**分层加载 缺省值 -> 配置文件(不存在时使用默认配置) -> 环境变量IM_* -> 命令行参数，并检查配置*/
+func (cf *ConfBean) Load(file string, flags map[string]string) (err error) {
+	if isExist(file) {
+		err = loadFile(cf, file)
 	} else {
-		xmlstr = imxml
+		err = loadXml(cf, "default", imxml)
+	}
+	if err == nil {
+		err = loadEnv(cf, "IM_", "IM_CLUSTER_")
+	}
+	if err == nil {
+		err = loadFlags(cf, flags)
+	}
+	if err == nil {
+		err = cf.Validate()
 	}
+	return
+}
+
+//读取配置文件并解析
+func (cf *ConfBean) LoadFromXml(filexml string) (err error) {
+	xmlconfig, err := os.Open(filexml)
+	if err != nil {
+		return
+	}
+	defer xmlconfig.Close()
+	config, err := ioutil.ReadAll(xmlconfig)
+	if err != nil {
+		return
+	}
+	return loadXml(cf, filexml, string(config))
+}
+
+func loadXml(bean interface{}, source, xmlstr string) (err error) {
 	//dom4g解析xm文件
 	dom, err := dom4g.LoadByXml(xmlstr)
-	if err == nil {
-		//拿到所有节点
-		nodes := dom.AllNodes()
-		if nodes != nil {
-			for _, node := range nodes {
-				//获取节点名称
-				name := node.Name()
-				//节点对应的值
-				value := node.Value
-				//反射获取ConfBean对象，对应的元素的名称
-				v := reflect.ValueOf(cf).Elem().FieldByName(name)
-				//是否可以设置参数
-				if v.CanSet() {
-					switch v.Type().Name() { //获取数据类型
-					case "string":
-						v.Set(reflect.ValueOf(value)) //获取节点数据  并设置
-					case "int":
-						i, _ := strconv.Atoi(value) //类型转换
-						v.Set(reflect.ValueOf(i))
-					default:
-						fmt.Println("other type:", v.Type().Name(), ">>>", name)
-					}
+	if err != nil {
+		return &ConfError{source, "", err.Error()}
+	}
+	//拿到所有节点
+	nodes := dom.AllNodes()
+	for _, node := range nodes {
+		if normalize(node.Name()) == "kv" {
+			//<KV><admin.api.token>...</admin.api.token></KV>
+			for _, kv := range node.AllNodes() {
+				if err = setField(bean, source, kvPrefix+kv.Name(), kv.Value); err != nil {
+					return
 				}
 			}
+			continue
+		}
+		//按节点名称给对应的元素赋值
+		if err = setField(bean, source, node.Name(), node.Value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**检查配置是否有效*/
+func (cf *ConfBean) Validate() error {
+	if cf.Port <= 0 || cf.Port > 65535 {
+		return &ConfError{"", "Port", fmt.Sprint("invalid port: ", cf.Port)}
+	}
+	for name, v := range map[string]int{"HttpPort": cf.HttpPort, "TLSPort": cf.TLSPort} {
+		if v < 0 || v > 65535 {
+			return &ConfError{"", name, fmt.Sprint("invalid port: ", v)}
+		}
+	}
+	if cf.HeartBeat < 0 {
+		return &ConfError{"", "HeartBeat", fmt.Sprint("must not be negative: ", cf.HeartBeat)}
+	}
+	for name, v := range map[string]int{"Presence": cf.Presence, "ConfirmAck": cf.ConfirmAck, "SingleClient": cf.SingleClient, "MustAuth": cf.MustAuth, "Db_Exsit": cf.Db_Exsit} {
+		if v != 0 && v != 1 {
+			return &ConfError{"", name, fmt.Sprint("must be 0 or 1: ", v)}
+		}
+	}
+	switch cf.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return &ConfError{"", "LogLevel", fmt.Sprint("unknown level: ", cf.LogLevel)}
+	}
+	if cf.TLSServerPem != "" && !isExist(cf.TLSServerPem) {
+		return &ConfError{"", "TLSServerPem", fmt.Sprint("not exist: ", cf.TLSServerPem)}
+	}
+	if cf.TLSServerKey != "" && !isExist(cf.TLSServerKey) {
+		return &ConfError{"", "TLSServerKey", fmt.Sprint("not exist: ", cf.TLSServerKey)}
+	}
+	for name, ips := range map[string][]string{"HttpAllowIps": cf.HttpAllowIpList(), "HttpTrustedProxies": cf.HttpTrustedProxyList()} {
+		for _, ip := range ips {
+			if !ValidIp(ip) {
+				return &ConfError{"", name, fmt.Sprint("invalid ip: ", ip)}
+			}
+		}
+	}
+	if cf.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(cf.AdminAddr); err != nil {
+			return &ConfError{"", "AdminAddr", err.Error()}
+		}
+	}
+	return nil
+}
+
+/**允许访问http接口的ip 为空时不限制*/
+func (cf *ConfBean) HttpAllowIpList() []string {
+	return SplitList(cf.HttpAllowIps)
+}
+
+/**可信代理 为空时不使用X-Forwarded-For*/
+func (cf *ConfBean) HttpTrustedProxyList() []string {
+	return SplitList(cf.HttpTrustedProxies)
+}
+
+/**逗号分隔的列表 去掉空项*/
+func SplitList(s string) (list []string) {
+	list = make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
 		}
 	}
+	return
+}
+
+/**ip或CIDR是否有效*/
+func ValidIp(ip string) bool {
+	if strings.Contains(ip, "/") {
+		_, _, err := net.ParseCIDR(ip)
+		return err == nil
+	}
+	return net.ParseIP(ip) != nil
 }
 
 //默认配置参数
@@ -2,6 +2,9 @@ package conf
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -10,3 +13,73 @@ func Test_init(t *testing.T) {
 	cf.LoadFromXml(`D:\liteIDEspace\tim\src\tim.xml`)
 	fmt.Println(cf)
 }
+
+func Test_validate(t *testing.T) {
+	cf := &ConfBean{Port: 3737, HeartBeat: 30, Presence: 1, MustAuth: 1, LogLevel: "info"}
+	if err := cf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cf.Presence, cf.HttpAllowIps = 2, "127.0.0.1"
+	if cf.Validate() == nil {
+		t.Fatal("Presence 2 should be invalid")
+	}
+	cf.Presence, cf.HttpAllowIps = 1, "127.0.0.1,x"
+	if cf.Validate() == nil {
+		t.Fatal("HttpAllowIps x should be invalid")
+	}
+	cf.HttpAllowIps, cf.HttpTrustedProxies = "127.0.0.1,10.0.0.0/8,::1", "172.16.0.0/33"
+	if cf.Validate() == nil {
+		t.Fatal("HttpTrustedProxies 172.16.0.0/33 should be invalid")
+	}
+	cf.HttpTrustedProxies, cf.AdminAddr = "172.16.0.0/12", "127.0.0.1:6060"
+	if err := cf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_layer(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "dsn")
+	ioutil.WriteFile(secret, []byte("root:pwd@tcp(127.0.0.1:3306)/im\n"), 0600)
+	os.Setenv("IM_HEARTBEAT", "45")
+	os.Setenv("IM_DB_DATASOURCENAME_FILE", secret)
+	os.Setenv("IM_KV_admin.api.token_FILE", secret)
+	defer os.Unsetenv("IM_HEARTBEAT")
+	defer os.Unsetenv("IM_DB_DATASOURCENAME_FILE")
+	defer os.Unsetenv("IM_KV_admin.api.token_FILE")
+	cf := new(ConfBean)
+	if err := cf.Load("notexist.xml", map[string]string{"Port": "3737", "presence": "1", "kv.http.apiKeys": "k1"}); err != nil {
+		t.Fatal(err)
+	}
+	if cf.HeartBeat != 45 || cf.Db_dataSourceName != "root:pwd@tcp(127.0.0.1:3306)/im" || cf.Presence != 1 {
+		t.Fatal(cf.HeartBeat, cf.Db_dataSourceName, cf.Presence)
+	}
+	if cf.GetKV("admin.api.token", "") != cf.Db_dataSourceName || cf.GetKV("http.apiKeys", "") != "k1" {
+		t.Fatal("kv:", cf.KV)
+	}
+	err := cf.Load("notexist.xml", map[string]string{"Port": "3737", "Unknown": "1"})
+	if e, ok := err.(*ConfError); !ok || e.Key != "Unknown" {
+		t.Fatal("unknown key:", err)
+	}
+	err = cf.Load("notexist.xml", map[string]string{"Port": "70000"})
+	if e, ok := err.(*ConfError); !ok || e.Key != "Port" {
+		t.Fatal("invalid port:", err)
+	}
+}
+
+func Test_reloadFileKV(t *testing.T) {
+	cf := new(ConfBean)
+	cf.SetDbKV(map[string]string{"a": "db", "b": "db"})
+	cf.setFileKV("b", "file")
+	cf.setFileKV("c", "file")
+	kv := cf.KV
+	loaded := new(ConfBean)
+	loaded.setFileKV("a", "file")
+	changed := cf.ReloadFileKV(loaded)
+	//b 恢复为数据库中的值，c 删除
+	if fmt.Sprint(changed) != "[a b c]" || fmt.Sprint(cf.KV) != "map[a:file b:db]" {
+		t.Fatal(changed, cf.KV)
+	}
+	if fmt.Sprint(kv) != "map[a:db b:file c:file]" {
+		t.Fatal("old KV modified:", kv)
+	}
+}
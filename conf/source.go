@@ -0,0 +1,222 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+/**
+ * 分层配置 缺省值 -> 配置文件(xml yaml toml) -> 环境变量 -> 命令行参数
+ * 配置名不区分大小写，下划线可省略，如 Db_dataSourceName 可写为 DB_DATASOURCENAME
+ * 环境变量名为 前缀+配置名，im.xml 前缀 IM_，cluster.xml 前缀 IM_CLUSTER_
+ * 环境变量以 _FILE 结尾或配置值以 file:// 开头时从文件读取配置值(用于密码等)
+ * KV 配置名为 kv.+key，如命令行 kv.admin.api.token=x，环境变量 IM_KV_admin.api.token(key区分大小写)
+ * 配置文件中为 KV 节点(xml的子节点，yaml toml的表)
+ */
+const secretPrefix = "file://"
+
+const kvPrefix = "kv."
+
+/**以 file:// 开头时从文件读取值*/
+func ReadSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, nil
+	}
+	bs, err := ioutil.ReadFile(value[len(secretPrefix):])
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+/**配置错误 Source为配置来源(文件名、环境变量名、flag)*/
+type ConfError struct {
+	Source string
+	Key    string
+	Msg    string
+}
+
+func (e *ConfError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprint(e.Key, ": ", e.Msg)
+	}
+	return fmt.Sprint(e.Source, ": ", e.Key, ": ", e.Msg)
+}
+
+func normalize(key string) string {
+	return strings.ToLower(strings.Replace(key, "_", "", -1))
+}
+
+/**按配置名给对象中的 string int 字段赋值 kv.或kv_开头的为KV*/
+func setField(bean interface{}, source, key, value string) error {
+	if len(key) > len(kvPrefix) && (strings.EqualFold(key[:len(kvPrefix)], kvPrefix) || strings.EqualFold(key[:len(kvPrefix)], "kv_")) {
+		cf, ok := bean.(*ConfBean)
+		if !ok {
+			return &ConfError{source, key, "unknown key"}
+		}
+		value, err := ReadSecret(value)
+		if err != nil {
+			return &ConfError{source, key, err.Error()}
+		}
+		cf.setFileKV(key[len(kvPrefix):], value)
+		return nil
+	}
+	v := reflect.ValueOf(bean).Elem()
+	var field reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		if normalize(v.Type().Field(i).Name) == normalize(key) {
+			field = v.Field(i)
+			break
+		}
+	}
+	if !field.IsValid() || !field.CanSet() {
+		return &ConfError{source, key, "unknown key"}
+	}
+	value, err := ReadSecret(value)
+	if err != nil {
+		return &ConfError{source, key, err.Error()}
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return &ConfError{source, key, fmt.Sprint("not int: ", value)}
+		}
+		field.SetInt(int64(i))
+	default:
+		return &ConfError{source, key, fmt.Sprint("unsupported type: ", field.Type())}
+	}
+	return nil
+}
+
+/**按扩展名解析配置文件 .yaml .yml .toml，其他按xml解析*/
+func loadFile(bean interface{}, file string) (err error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	m := make(map[string]interface{}, 0)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bs, &m)
+	case ".toml":
+		_, err = toml.Decode(string(bs), &m)
+	default:
+		return loadXml(bean, file, string(bs))
+	}
+	if err != nil {
+		return &ConfError{file, "", err.Error()}
+	}
+	keys := make([]string, 0, len(m))
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if normalize(k) == "kv" {
+			if err = loadKV(bean, file, m[k]); err != nil {
+				return
+			}
+			continue
+		}
+		switch value := m[k].(type) {
+		case string, int, int64, bool:
+			err = setField(bean, file, k, fmt.Sprint(value))
+		case nil:
+			err = setField(bean, file, k, "")
+		default:
+			err = &ConfError{file, k, "not a scalar"}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**配置文件中的KV表 yaml解析为map[interface{}]interface{}*/
+func loadKV(bean interface{}, file string, table interface{}) (err error) {
+	kv := make(map[string]interface{}, 0)
+	switch t := table.(type) {
+	case map[string]interface{}:
+		kv = t
+	case map[interface{}]interface{}:
+		for k, v := range t {
+			kv[fmt.Sprint(k)] = v
+		}
+	default:
+		return &ConfError{file, "KV", "not a table"}
+	}
+	keys := make([]string, 0, len(kv))
+	for k, _ := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch value := kv[k].(type) {
+		case string, int, int64, bool:
+			err = setField(bean, file, kvPrefix+k, fmt.Sprint(value))
+		default:
+			err = &ConfError{file, kvPrefix + k, "not a scalar"}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**环境变量 exclude为其他配置使用的前缀*/
+func loadEnv(bean interface{}, prefix, exclude string) (err error) {
+	envs := os.Environ()
+	sort.Strings(envs)
+	for _, env := range envs {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], prefix) || (exclude != "" && strings.HasPrefix(kv[0], exclude)) {
+			continue
+		}
+		key, value := kv[0][len(prefix):], kv[1]
+		if strings.HasSuffix(key, "_FILE") {
+			key, value = strings.TrimSuffix(key, "_FILE"), secretPrefix+value
+		}
+		if err = setField(bean, kv[0], key, value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**是否设置了该前缀的环境变量*/
+func hasEnv(prefix string) bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadFlags(bean interface{}, flags map[string]string) (err error) {
+	keys := make([]string, 0, len(flags))
+	for k, _ := range flags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err = setField(bean, "flag", k, flags[k]); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package connect
+
+import (
+	. "github.com/zhangjunfang/im/protocol"
+)
+
+/**
+ * 抄送 发送者其他在线设备收到的已发送消息副本
+ * mbean.ExtraMap["tim_carbon"]="sent"
+ */
+const CARBON = "tim_carbon"
+
+func IsCarbon(mbean *TimMBean) bool {
+	return mbean != nil && mbean.ExtraMap != nil && mbean.ExtraMap[CARBON] == "sent"
+}
+
+/**消息副本 标记为抄送*/
+func NewCarbon(mbean *TimMBean) *TimMBean {
+	carbon := *mbean
+	carbon.ExtraMap = make(map[string]string, len(mbean.ExtraMap)+1)
+	for k, v := range mbean.ExtraMap {
+		carbon.ExtraMap[k] = v
+	}
+	carbon.ExtraMap[CARBON] = "sent"
+	return &carbon
+}
+
+/**抄送的目标用户，即发送者 群消息为LeaguerTid*/
+func CarbonTid(mbean *TimMBean) *Tid {
+	if mbean.GetType() == "groupchat" && mbean.GetLeaguerTid() != nil {
+		return mbean.GetLeaguerTid()
+	}
+	return mbean.GetFromTid()
+}
+
+/**
+ * 已经保存的消息 mbean.ExtraMap["tim_stored"]=mid
+ * 管理接口先保存再投递，转发到其他节点时不再重复保存，也不再调用 POST_STORE 拦截器
+ */
+const STORED = "tim_stored"
+
+func IsStored(mbean *TimMBean) bool {
+	return mbean != nil && mbean.ExtraMap != nil && mbean.ExtraMap[STORED] != ""
+}
+
+/**消息副本 标记为已经保存*/
+func NewStored(mbean *TimMBean, mid string) *TimMBean {
+	stored := *mbean
+	stored.ExtraMap = make(map[string]string, len(mbean.ExtraMap)+1)
+	for k, v := range mbean.ExtraMap {
+		stored.ExtraMap[k] = v
+	}
+	stored.ExtraMap[STORED] = mid
+	stored.Mid = &mid
+	return &stored
+}
@@ -0,0 +1,316 @@
+package connect
+
+import (
+	"errors"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	. "github.com/zhangjunfang/im/common"
+	. "github.com/zhangjunfang/im/fw"
+	. "github.com/zhangjunfang/im/protocol"
+	. "github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * 连接的异步发送队列
+ * 路由协程只把信息放入队列，由每个连接的发送协程写入socket
+ * ConfirmAck=1 时最多有 send.window 条(批)未确认的信息，超过 send.ack.timeout 秒未确认视为慢连接
+ * 慢连接按 send.slow 处理: close 断开连接(缺省)  offline 未确认与新的信息转为离线信息
+ */
+
+/**未投递成功的信息 由route设置为保存离线信息*/
+var Undelivered func(mbeans []*TimMBean)
+
+// 运行中的发送协程
+var queues = new(sync.WaitGroup)
+
+type outItem struct {
+	mbeans []*TimMBean
+	pbeans []*TimPBean
+	list   bool //按列表发送
+}
+
+type unacked struct {
+	threadId string
+	mbeans   []*TimMBean
+	time     int64
+}
+
+type outQueue struct {
+	tu         *TimUser
+	ch         chan *outItem
+	acks       chan string
+	quit       chan bool
+	closed     int32
+	window     []*unacked
+	windowSize int
+	batch      int
+	ackTimeout int64
+	slowClose  bool
+}
+
+/**send.async=1(缺省)时启动发送协程*/
+func (t *TimUser) StartQueue() {
+	if CF().GetKV("send.async", "1") != "1" {
+		return
+	}
+	q := &outQueue{tu: t, quit: make(chan bool), window: make([]*unacked, 0)}
+	q.ch = make(chan *outItem, Atoi(CF().GetKV("send.queue", "1000")))
+	q.windowSize = Atoi(CF().GetKV("send.window", "16"))
+	if q.windowSize <= 0 {
+		q.windowSize = 1
+	}
+	q.acks = make(chan string, q.windowSize+16)
+	q.batch = Atoi(CF().GetKV("send.batch", "50"))
+	q.ackTimeout = Atoi64(CF().GetKV("send.ack.timeout", "5")) * 1000
+	q.slowClose = CF().GetKV("send.slow", "close") != "offline"
+	t.queue = q
+	queues.Add(1)
+	go q.run()
+}
+
+/**等待队列中的信息写出 超时返回false*/
+func (t *TimUser) Flush(timeout time.Duration) bool {
+	if t.queue == nil {
+		return true
+	}
+	deadline := time.Now().Add(timeout)
+	for len(t.queue.ch) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+/**等待所有发送协程结束(未投递的信息已转为离线) 超时返回false*/
+func WaitQueues(timeout time.Duration) bool {
+	done := make(chan bool)
+	go func() {
+		queues.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+/**信息确认 使用发送队列时返回true*/
+func (t *TimUser) Ack(threadId string) bool {
+	if t.queue == nil || CF().ConfirmAck != 1 {
+		return false
+	}
+	select {
+	case t.queue.acks <- threadId:
+	default:
+	}
+	return true
+}
+
+func (this *outQueue) push(item *outItem) (er error) {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return errors.New("timuser is close")
+	}
+	select {
+	case this.ch <- item:
+	default:
+		er = errors.New("send queue full")
+		if item.mbeans != nil {
+			logger.Warn("slow consumer, send queue full:", this.tu.UserTid.GetName())
+			if this.slowClose {
+				//不在路由协程中修改连接状态(Fw)，关闭后由连接协程清理
+				go this.tu.Close()
+			}
+		}
+		return
+	}
+	//已经停止时由放入的协程转为离线
+	if atomic.LoadInt32(&this.closed) == 1 {
+		this.drain()
+	}
+	return
+}
+
+func (this *outQueue) stop() {
+	if atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		close(this.quit)
+	}
+}
+
+func (this *outQueue) run() {
+	closeConn := true
+	defer queues.Done()
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+		this.stop()
+		mbeans := make([]*TimMBean, 0)
+		for _, u := range this.window {
+			mbeans = append(mbeans, u.mbeans...)
+		}
+		this.window = nil
+		if len(mbeans) > 0 && Undelivered != nil {
+			Undelivered(mbeans)
+		}
+		this.drain()
+		if closeConn {
+			this.tu.Sync.Lock()
+			this.tu.IsClose, this.tu.Fw = true, CLOSE
+			this.tu.Sync.Unlock()
+			this.tu.Close()
+		}
+	}()
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		if len(this.window) >= this.windowSize {
+			//窗口已满 等待确认
+			select {
+			case id := <-this.acks:
+				this.ack(id)
+			case <-ticker.C:
+				if !this.checkTimeout() {
+					return
+				}
+			case <-this.quit:
+				closeConn = false
+				return
+			}
+			continue
+		}
+		select {
+		case item := <-this.ch:
+			if this.write(item) != nil {
+				return
+			}
+		case id := <-this.acks:
+			this.ack(id)
+		case <-ticker.C:
+			if !this.checkTimeout() {
+				return
+			}
+		case <-this.quit:
+			closeConn = false
+			return
+		}
+	}
+}
+
+/**队列中剩余的信息转为离线*/
+func (this *outQueue) drain() {
+	mbeans := make([]*TimMBean, 0)
+	for {
+		select {
+		case item := <-this.ch:
+			mbeans = append(mbeans, item.mbeans...)
+		default:
+			if len(mbeans) > 0 && Undelivered != nil {
+				Undelivered(mbeans)
+			}
+			return
+		}
+	}
+}
+
+func (this *outQueue) write(item *outItem) (er error) {
+	t := this.tu
+	if item.pbeans != nil {
+		t.Sync.Lock()
+		if len(item.pbeans) == 1 && !item.list {
+			er = t.Client.TimPresence(item.pbeans[0])
+		} else {
+			pbeanList := NewTimPBeanList()
+			pbeanList.ThreadId = TimeMills()
+			pbeanList.TimPBeanList = item.pbeans
+			er = t.Client.TimPresenceList(pbeanList)
+		}
+		t.Sync.Unlock()
+		return
+	}
+	mbeans, list := item.mbeans, item.list
+	var next *outItem
+	//积压时合并为TimMessageList
+	if t.Interflow > 0 {
+	BATCH:
+		for len(mbeans) < this.batch {
+			select {
+			case it := <-this.ch:
+				if it.mbeans == nil {
+					next = it
+					break BATCH
+				}
+				mbeans, list = append(mbeans, it.mbeans...), true
+			default:
+				break BATCH
+			}
+		}
+	}
+	var threadId string
+	t.Sync.Lock()
+	if list {
+		mbeanList := NewTimMBeanList()
+		mbeanList.TimMBeanList = mbeans
+		mbeanList.ThreadId = TimeMills()
+		threadId = mbeanList.GetThreadId()
+		er = t.Client.TimMessageList(mbeanList)
+	} else {
+		threadId = mbeans[0].GetThreadId()
+		er = t.Client.TimMessage(mbeans[0])
+	}
+	t.Sync.Unlock()
+	if er != nil {
+		logger.Error("sendMBean:", er.Error())
+		this.window = append(this.window, &unacked{threadId, mbeans, 0})
+		//已经从队列中取出的下一项同样没有写出，与未确认的信息一起转为离线(presence与drain时一样不保存)
+		if next != nil {
+			this.window = append(this.window, &unacked{"", next.mbeans, 0})
+		}
+		return
+	}
+	if CF().ConfirmAck == 1 {
+		this.window = append(this.window, &unacked{threadId, mbeans, TimeMillsInt64()})
+	}
+	if next != nil {
+		er = this.write(next)
+	}
+	return
+}
+
+func (this *outQueue) ack(threadId string) {
+	for i, u := range this.window {
+		if u.threadId == threadId {
+			this.window = append(this.window[:i], this.window[i+1:]...)
+			return
+		}
+	}
+}
+
+/**超时未确认 断开连接时返回false*/
+func (this *outQueue) checkTimeout() bool {
+	if len(this.window) == 0 || TimeMillsInt64()-this.window[0].time < this.ackTimeout {
+		return true
+	}
+	logger.Warn("slow consumer, ack overtime:", this.tu.UserTid.GetName(), " unacked:", len(this.window))
+	if this.slowClose {
+		return false
+	}
+	now := TimeMillsInt64()
+	mbeans := make([]*TimMBean, 0)
+	i := 0
+	for ; i < len(this.window) && now-this.window[i].time >= this.ackTimeout; i++ {
+		mbeans = append(mbeans, this.window[i].mbeans...)
+	}
+	this.window = this.window[i:]
+	if Undelivered != nil {
+		Undelivered(mbeans)
+	}
+	return true
+}
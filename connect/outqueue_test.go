@@ -0,0 +1,221 @@
+package connect
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/zhangjunfang/im/common"
+	. "github.com/zhangjunfang/im/protocol"
+)
+
+/**测试用的连接 记录写出的信息条数(Flush次数)*/
+type testTransport struct {
+	lock    sync.Mutex
+	flushes int
+	closed  bool
+	block   chan bool //不为nil时Flush等待关闭
+}
+
+func (this *testTransport) Open() error                 { return nil }
+func (this *testTransport) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (this *testTransport) Write(p []byte) (int, error) { return len(p), nil }
+func (this *testTransport) RemainingBytes() uint64      { return ^uint64(0) }
+
+func (this *testTransport) IsOpen() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return !this.closed
+}
+
+func (this *testTransport) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closed = true
+	return nil
+}
+
+func (this *testTransport) Flush() error {
+	if this.block != nil {
+		<-this.block
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.flushes++
+	return nil
+}
+
+func (this *testTransport) count() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.flushes
+}
+
+func newTestUser(name string) (*TimUser, *testTransport) {
+	tt := new(testTransport)
+	domain := "test.com"
+	tid := NewTid()
+	tid.Name, tid.Domain = name, &domain
+	tu := &TimUser{UserTid: tid, Client: NewITimClientFactory(tt, thrift.NewTCompactProtocolFactory()), Sync: new(sync.Mutex)}
+	return tu, tt
+}
+
+/**测试时替换配置 返回恢复函数*/
+func setTestConf(confirmAck int, kv map[string]string) func() {
+	old := CF()
+	cf := *old
+	cf.ConfirmAck, cf.KV = confirmAck, kv
+	SetCF(&cf)
+	return func() { SetCF(old) }
+}
+
+/**转为离线的信息 按threadId中的测试名记录*/
+var undelivered = struct {
+	lock sync.Mutex
+	ids  map[string][]string
+}{ids: make(map[string][]string)}
+
+func init() {
+	Undelivered = func(mbeans []*TimMBean) {
+		undelivered.lock.Lock()
+		defer undelivered.lock.Unlock()
+		for _, mbean := range mbeans {
+			s := strings.SplitN(mbean.GetThreadId(), "-", 2)
+			undelivered.ids[s[0]] = append(undelivered.ids[s[0]], s[1])
+		}
+	}
+}
+
+func resetUndelivered(name string) {
+	undelivered.lock.Lock()
+	defer undelivered.lock.Unlock()
+	delete(undelivered.ids, name)
+}
+
+func undeliveredIds(name string) string {
+	undelivered.lock.Lock()
+	defer undelivered.lock.Unlock()
+	ids := append([]string{}, undelivered.ids[name]...)
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func testMBean(name, id string) *TimMBean {
+	mbean := NewTimMBean()
+	mbean.ThreadId = name + "-" + id
+	return mbean
+}
+
+func waitFor(t *testing.T, timeout time.Duration, f func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/**关闭连接 等待发送协程把未确认的信息转为离线后结束*/
+func stopQueue(t *testing.T, tu *TimUser, name, want string) {
+	tu.Close()
+	waitFor(t, 3*time.Second, func() bool { return undeliveredIds(name) == want })
+}
+
+func Test_ackWindow(t *testing.T) {
+	defer setTestConf(1, map[string]string{"send.window": "2"})()
+	resetUndelivered("window")
+	tu, tt := newTestUser("window")
+	tu.StartQueue()
+	defer stopQueue(t, tu, "window", "3,4")
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if err := tu.SendMBean(testMBean("window", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, time.Second, func() bool { return tt.count() == 2 })
+	time.Sleep(100 * time.Millisecond)
+	if tt.count() != 2 {
+		t.Fatal("window not full:", tt.count())
+	}
+	tu.Ack("window-1")
+	waitFor(t, time.Second, func() bool { return tt.count() == 3 })
+	tu.Ack("window-2")
+	waitFor(t, time.Second, func() bool { return tt.count() == 4 })
+}
+
+func Test_overflow(t *testing.T) {
+	defer setTestConf(1, map[string]string{"send.window": "1", "send.queue": "2"})()
+	resetUndelivered("overflow")
+	tu, tt := newTestUser("overflow")
+	tu.StartQueue()
+	defer stopQueue(t, tu, "overflow", "1,2,3")
+	tu.SendMBean(testMBean("overflow", "1"))
+	waitFor(t, time.Second, func() bool { return tt.count() == 1 })
+	//窗口已满 后面的信息留在队列中
+	for _, id := range []string{"2", "3"} {
+		if err := tu.SendMBean(testMBean("overflow", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tu.SendMBean(testMBean("overflow", "4")); err == nil {
+		t.Fatal("queue not full")
+	}
+	waitFor(t, time.Second, func() bool { return !tt.IsOpen() })
+}
+
+func Test_slowOffline(t *testing.T) {
+	defer setTestConf(1, map[string]string{"send.ack.timeout": "1", "send.slow": "offline"})()
+	resetUndelivered("slowOffline")
+	tu, tt := newTestUser("slowOffline")
+	tu.StartQueue()
+	defer stopQueue(t, tu, "slowOffline", "1,2,3")
+	tu.SendMBean(testMBean("slowOffline", "1"))
+	tu.SendMBean(testMBean("slowOffline", "2"))
+	waitFor(t, 3*time.Second, func() bool { return undeliveredIds("slowOffline") == "1,2" })
+	if !tt.IsOpen() {
+		t.Fatal("connection closed")
+	}
+	tu.SendMBean(testMBean("slowOffline", "3"))
+	waitFor(t, time.Second, func() bool { return tt.count() == 3 })
+}
+
+func Test_slowClose(t *testing.T) {
+	defer setTestConf(1, map[string]string{"send.ack.timeout": "1"})()
+	resetUndelivered("slowClose")
+	tu, tt := newTestUser("slowClose")
+	tu.StartQueue()
+	defer stopQueue(t, tu, "slowClose", "1")
+	tu.SendMBean(testMBean("slowClose", "1"))
+	waitFor(t, 3*time.Second, func() bool { return !tt.IsOpen() })
+	waitFor(t, time.Second, func() bool { return undeliveredIds("slowClose") == "1" })
+	if tu.SendMBean(testMBean("slowClose", "2")) == nil {
+		t.Fatal("send after close")
+	}
+}
+
+func Test_drainOnClose(t *testing.T) {
+	defer setTestConf(1, map[string]string{})()
+	resetUndelivered("drain")
+	tu, tt := newTestUser("drain")
+	tt.block = make(chan bool)
+	tu.StartQueue()
+	tu.SendMBean(testMBean("drain", "1"))
+	//第一条正在写出
+	waitFor(t, time.Second, func() bool { return len(tu.queue.ch) == 0 })
+	tu.SendMBean(testMBean("drain", "2"))
+	tu.SendMBean(testMBean("drain", "3"))
+	go tu.Close()
+	waitFor(t, time.Second, func() bool { return atomic.LoadInt32(&tu.queue.closed) == 1 })
+	if tu.SendMBean(testMBean("drain", "4")) == nil {
+		t.Fatal("send after close")
+	}
+	close(tt.block)
+	//未确认与队列中剩下的信息都转为离线
+	stopQueue(t, tu, "drain", "1,2,3")
+}
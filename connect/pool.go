@@ -0,0 +1,252 @@
+package connect
+
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/donnie4w/go-logger/logger"
+	. "github.com/zhangjunfang/im/fw"
+	. "github.com/zhangjunfang/im/protocol"
+	. "github.com/zhangjunfang/im/utils"
+)
+
+/**踢下线 presence type 其他节点转发时使用*/
+const KICK = "tim_kick"
+
+/**
+ * 连接池 分片存储，减少登录、下线与投递之间的锁竞争
+ * 连接按加入顺序分片，在线用户按登录名hash分片
+ * 关闭连接在锁外进行
+ */
+const poolShards = 64
+
+type connShard struct {
+	// 注册了的连接器
+	pool   map[*TimUser]bool
+	rwLock *sync.RWMutex
+}
+
+type userShard struct {
+	// 在线用户  domain+name
+	poolUser map[string]map[*TimUser]bool
+	rwLock   *sync.RWMutex
+}
+
+type TimPool struct {
+	conns []*connShard
+	users []*userShard
+	seq   uint32
+}
+
+func NewTimPool() *TimPool {
+	t := &TimPool{conns: make([]*connShard, poolShards), users: make([]*userShard, poolShards)}
+	for i := 0; i < poolShards; i++ {
+		t.conns[i] = &connShard{pool: make(map[*TimUser]bool), rwLock: new(sync.RWMutex)}
+		t.users[i] = &userShard{poolUser: make(map[string]map[*TimUser]bool), rwLock: new(sync.RWMutex)}
+	}
+	return t
+}
+
+func (this *TimPool) connShard(tu *TimUser) *connShard {
+	return this.conns[tu.seq%poolShards]
+}
+
+func (this *TimPool) userShard(loginname string) *userShard {
+	h := fnv.New32a()
+	h.Write([]byte(loginname))
+	return this.users[h.Sum32()%poolShards]
+}
+
+func (this *TimPool) AddConnect(c *TimUser) {
+	c.seq = atomic.AddUint32(&this.seq, 1)
+	s := this.connShard(c)
+	s.rwLock.Lock()
+	defer s.rwLock.Unlock()
+	s.pool[c] = true
+}
+
+func (this *TimPool) hasConnect(tu *TimUser) bool {
+	s := this.connShard(tu)
+	s.rwLock.RLock()
+	defer s.rwLock.RUnlock()
+	return s.pool[tu]
+}
+
+func (this *TimPool) delConnect(tu *TimUser) {
+	s := this.connShard(tu)
+	s.rwLock.Lock()
+	defer s.rwLock.Unlock()
+	delete(s.pool, tu)
+}
+
+func (this *TimPool) AddAuthUser(tu *TimUser) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("AddAuthUser,", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	loginname, _ := GetLoginName(tu.UserTid)
+	s := this.userShard(loginname)
+	s.rwLock.Lock()
+	defer s.rwLock.Unlock()
+	if this.hasConnect(tu) {
+		if s.poolUser[loginname] == nil {
+			s.poolUser[loginname] = make(map[*TimUser]bool)
+		}
+		s.poolUser[loginname][tu] = true
+	}
+}
+
+/**同一用户只保留一个连接 其他连接在锁外关闭*/
+func (this *TimPool) AddSingleAuthUser(tu *TimUser) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("AddSingleAuthUser,", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	loginname, _ := GetLoginName(tu.UserTid)
+	s := this.userShard(loginname)
+	s.rwLock.Lock()
+	olds := s.poolUser[loginname]
+	delete(s.poolUser, loginname)
+	for k, _ := range olds {
+		if k != tu {
+			this.delConnect(k)
+		}
+	}
+	if this.hasConnect(tu) {
+		s.poolUser[loginname] = map[*TimUser]bool{tu: true}
+	}
+	s.rwLock.Unlock()
+	for k, _ := range olds {
+		if k != tu {
+			k.Close()
+		}
+	}
+}
+
+func (this *TimPool) GetAllLoginName() (ss []string) {
+	ss = make([]string, 0)
+	for _, s := range this.users {
+		s.rwLock.RLock()
+		for k, _ := range s.poolUser {
+			ss = append(ss, k)
+		}
+		s.rwLock.RUnlock()
+	}
+	return
+}
+
+func (this *TimPool) DeleteTimUser(tu *TimUser) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("DeleteTimUser,", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	this.delConnect(tu)
+	if tu.UserTid != nil {
+		loginname, _ := GetLoginName(tu.UserTid)
+		s := this.userShard(loginname)
+		s.rwLock.Lock()
+		if tumap, ok := s.poolUser[loginname]; ok {
+			if _, ok := tumap[tu]; ok {
+				delete(tumap, tu)
+				if len(tumap) == 0 {
+					delete(s.poolUser, loginname)
+				}
+			}
+		}
+		s.rwLock.Unlock()
+	}
+	tu.Close()
+}
+
+/**遍历所有连接 f返回false时停止，f在锁外调用*/
+func (this *TimPool) Range(f func(tu *TimUser) bool) {
+	for _, s := range this.conns {
+		s.rwLock.RLock()
+		tus := make([]*TimUser, 0, len(s.pool))
+		for tu, _ := range s.pool {
+			tus = append(tus, tu)
+		}
+		s.rwLock.RUnlock()
+		for _, tu := range tus {
+			if !f(tu) {
+				return
+			}
+		}
+	}
+}
+
+/**
+ * 踢下线 关闭用户在本节点的连接，tid带resource时只关闭该resource的连接
+ * 返回关闭的连接数
+ */
+func (this *TimPool) Kick(tid *Tid) (n int) {
+	loginname, err := GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	for _, tu := range this.GetLoginUser(loginname) {
+		if resource := tid.GetResource(); resource != "" && tu.UserTid.GetResource() != resource {
+			continue
+		}
+		tu.Fw = CLOSE
+		tu.Close()
+		n++
+	}
+	return
+}
+
+func (t *TimPool) Len4PU() (n int) {
+	for _, s := range t.users {
+		s.rwLock.RLock()
+		n += len(s.poolUser)
+		s.rwLock.RUnlock()
+	}
+	return
+}
+
+func (t *TimPool) Len4P() (n int) {
+	for _, s := range t.conns {
+		s.rwLock.RLock()
+		n += len(s.pool)
+		s.rwLock.RUnlock()
+	}
+	return
+}
+
+func (t *TimPool) PrintUsersInfo() string {
+	str := fmt.Sprintln(t.Len4P(), "======>", t.Len4PU())
+	for _, s := range t.users {
+		s.rwLock.RLock()
+		for _, vmap := range s.poolUser {
+			for tu, _ := range vmap {
+				str = fmt.Sprintln(str, tu.UserTid.GetName(), " # ", TimeMills2TimeFormat(tu.IdCardNo), " # ", tu.UserTid.GetResource())
+			}
+		}
+		s.rwLock.RUnlock()
+	}
+	return str
+}
+
+func (t *TimPool) GetLoginUser(loginname string) (tus []*TimUser) {
+	s := t.userShard(loginname)
+	s.rwLock.RLock()
+	defer s.rwLock.RUnlock()
+	if tumap, ok := s.poolUser[loginname]; ok {
+		tus = make([]*TimUser, 0, len(tumap))
+		for tu, _ := range tumap {
+			tus = append(tus, tu)
+		}
+	}
+	return
+}
+
+var TP = NewTimPool()
@@ -0,0 +1,108 @@
+package connect
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func Test_pool(t *testing.T) {
+	pool := NewTimPool()
+	tus := make([]*TimUser, 0)
+	for i := 0; i < 200; i++ {
+		tu, _ := newTestUser(fmt.Sprint("user", i))
+		pool.AddConnect(tu)
+		pool.AddAuthUser(tu)
+		tus = append(tus, tu)
+	}
+	if pool.Len4P() != 200 || pool.Len4PU() != 200 {
+		t.Fatal("len:", pool.Len4P(), pool.Len4PU())
+	}
+	shards := 0
+	for _, s := range pool.users {
+		if len(s.poolUser) > 0 {
+			shards++
+		}
+	}
+	if shards < 2 {
+		t.Fatal("users in one shard")
+	}
+	for _, tu := range tus {
+		loginname, _ := GetLoginName(tu.UserTid)
+		if got := pool.GetLoginUser(loginname); len(got) != 1 || got[0] != tu {
+			t.Fatal("GetLoginUser:", tu.UserTid.GetName(), got)
+		}
+	}
+	n := 0
+	pool.Range(func(tu *TimUser) bool {
+		n++
+		return true
+	})
+	if n != 200 {
+		t.Fatal("range:", n)
+	}
+	for _, tu := range tus {
+		pool.DeleteTimUser(tu)
+	}
+	if pool.Len4P() != 0 || pool.Len4PU() != 0 {
+		t.Fatal("len after delete:", pool.Len4P(), pool.Len4PU())
+	}
+}
+
+func Test_singleClient(t *testing.T) {
+	pool := NewTimPool()
+	tu1, tt1 := newTestUser("single")
+	tu2, tt2 := newTestUser("single")
+	pool.AddConnect(tu1)
+	pool.AddSingleAuthUser(tu1)
+	pool.AddConnect(tu2)
+	pool.AddSingleAuthUser(tu2)
+	loginname, _ := GetLoginName(tu2.UserTid)
+	if got := pool.GetLoginUser(loginname); len(got) != 1 || got[0] != tu2 {
+		t.Fatal("GetLoginUser:", got)
+	}
+	if pool.Len4P() != 1 || !tt1.closed || tt2.closed {
+		t.Fatal("old connection not replaced:", pool.Len4P(), tt1.closed, tt2.closed)
+	}
+	//已经删除的连接不能再登陆
+	pool.DeleteTimUser(tu2)
+	pool.AddAuthUser(tu2)
+	if pool.Len4PU() != 0 {
+		t.Fatal("deleted connection added")
+	}
+}
+
+func Test_concurrent(t *testing.T) {
+	pool := NewTimPool()
+	tus := make([]*TimUser, 0)
+	for i := 0; i < 100; i++ {
+		tu, _ := newTestUser(fmt.Sprint("user", i%10))
+		pool.AddConnect(tu)
+		pool.AddAuthUser(tu)
+		tus = append(tus, tu)
+	}
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				loginname, _ := GetLoginName(tus[(i+j)%len(tus)].UserTid)
+				for _, tu := range pool.GetLoginUser(loginname) {
+					tu.UserTid.GetName()
+				}
+				pool.Range(func(tu *TimUser) bool { return true })
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := i; j < len(tus); j += 10 {
+				pool.DeleteTimUser(tus[j])
+			}
+		}(i)
+	}
+	wg.Wait()
+	if pool.Len4P() != 0 || pool.Len4PU() != 0 || len(pool.GetAllLoginName()) != 0 {
+		t.Fatal("len after delete:", pool.Len4P(), pool.Len4PU())
+	}
+}
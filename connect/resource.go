@@ -0,0 +1,42 @@
+package connect
+
+import (
+	. "github.com/zhangjunfang/im/common"
+	. "github.com/zhangjunfang/im/protocol"
+)
+
+/**
+ * 按resource选择投递的连接
+ * tid带resource且有对应连接时，只投递到该连接
+ * 否则按route.bare策略：all 所有连接(默认)  priority 优先级最高的连接
+ */
+func FilterResource(tus []*TimUser, tid *Tid) []*TimUser {
+	if len(tus) == 0 || tid == nil {
+		return tus
+	}
+	if resource := tid.GetResource(); resource != "" {
+		ret := make([]*TimUser, 0, 1)
+		for _, tu := range tus {
+			if tu.UserTid != nil && tu.UserTid.GetResource() == resource {
+				ret = append(ret, tu)
+			}
+		}
+		if len(ret) > 0 {
+			return ret
+		}
+	}
+	if CF().GetKV("route.bare", "all") != "priority" {
+		return tus
+	}
+	var max int32
+	ret := make([]*TimUser, 0, 1)
+	for _, tu := range tus {
+		if len(ret) == 0 || tu.Priority > max {
+			max = tu.Priority
+			ret = append(ret[:0], tu)
+		} else if tu.Priority == max {
+			ret = append(ret, tu)
+		}
+	}
+	return ret
+}
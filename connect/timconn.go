@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/chatState"
 	//	. "tim.FW"
 	//	. "tim.common"
 	//	. "tim.protocol"
@@ -16,153 +18,10 @@ import (
 	. "github.com/zhangjunfang/im/common"
 	. "github.com/zhangjunfang/im/fw"
 	. "github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/rateLimit"
 	. "github.com/zhangjunfang/im/utils"
 )
 
-type TimPool struct {
-	// 注册了的连接器
-	pool map[*TimUser]bool
-	//	pool *HashTable
-	// 在线用户  domain+name
-	poolUser map[string]map[*TimUser]bool
-	//	poolUser *HashTable
-	// 从连接器中注册请求
-	register chan *TimUser
-	// 从连接器中注销请求
-	unregister chan *TimUser
-	// 锁
-	rwLock *sync.RWMutex
-}
-
-func (this *TimPool) AddAuthUser(tu *TimUser) {
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error("AddAuthUser,", err)
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	this.rwLock.Lock()
-	defer this.rwLock.Unlock()
-	if _, ok := this.pool[tu]; ok {
-		loginname, _ := GetLoginName(tu.UserTid)
-		if len(this.poolUser[loginname]) == 0 {
-			this.poolUser[loginname] = make(map[*TimUser]bool)
-		}
-		this.poolUser[loginname][tu] = true
-	}
-}
-
-func (this *TimPool) AddSingleAuthUser(tu *TimUser) {
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error("AddSingleAuthUser,", err)
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	this.rwLock.Lock()
-	defer this.rwLock.Unlock()
-	loginname, _ := GetLoginName(tu.UserTid)
-	if pu, ok := this.poolUser[loginname]; ok {
-		if pu != nil && len(pu) > 0 {
-			for k, _ := range pu {
-				k.Close()
-				delete(pu, k)
-				delete(this.pool, k)
-			}
-		}
-	}
-	if _, ok := this.pool[tu]; ok {
-		if this.poolUser[loginname] == nil {
-			this.poolUser[loginname] = make(map[*TimUser]bool)
-		}
-		this.poolUser[loginname][tu] = true
-	}
-}
-
-func (this *TimPool) GetAllLoginName() (ss []string) {
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error("GetAllLoginName,", err)
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	//	this.rwLock.RLock()
-	//	defer this.rwLock.RUnlock()
-	ss = make([]string, 0)
-	for k, _ := range this.poolUser {
-		ss = append(ss, k)
-	}
-	return
-}
-
-func (this *TimPool) DeleteTimUser(tu *TimUser) {
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error("DeleteTimUser,", err)
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	this.rwLock.Lock()
-	defer this.rwLock.Unlock()
-	tu.Close()
-	if tu.UserTid != nil {
-		loginname, _ := GetLoginName(tu.UserTid)
-		if tumap, ok := this.poolUser[loginname]; ok {
-			if _, ok := tumap[tu]; ok {
-				delete(tumap, tu)
-				if len(tumap) == 0 {
-					delete(this.poolUser, loginname)
-				}
-			}
-		}
-	}
-	if _, ok := this.pool[tu]; ok {
-		delete(this.pool, tu)
-	}
-}
-
-func (t *TimPool) Len4PU() int {
-	t.rwLock.RLock()
-	defer t.rwLock.RUnlock()
-	return len(t.poolUser)
-}
-
-func (t *TimPool) Len4P() int {
-	t.rwLock.RLock()
-	defer t.rwLock.RUnlock()
-	return len(t.pool)
-}
-
-func (t *TimPool) PrintUsersInfo() string {
-	t.rwLock.RLock()
-	defer t.rwLock.RUnlock()
-	str := fmt.Sprintln(len(t.pool), "======>", len(t.poolUser))
-	for _, vmap := range t.poolUser {
-		for tu, _ := range vmap {
-			str = fmt.Sprintln(str, tu.UserTid.GetName(), " # ", TimeMills2TimeFormat(tu.IdCardNo), " # ", tu.UserTid.GetResource())
-		}
-	}
-	return str
-}
-
-func (t *TimPool) GetLoginUser(loginname string) (tus []*TimUser) {
-	t.rwLock.RLock()
-	defer t.rwLock.RUnlock()
-	if tumap, ok := t.poolUser[loginname]; ok {
-		tus = make([]*TimUser, 0)
-		for tu, _ := range tumap {
-			tus = append(tus, tu)
-		}
-	}
-	return
-}
-
-func (t *TimPool) AddConnect(c *TimUser) {
-	t.rwLock.Lock()
-	defer t.rwLock.Unlock()
-	t.pool[c] = true
-}
-
 type TimUser struct {
 	UserTid          *Tid
 	Client           *ITimClient
@@ -177,6 +36,33 @@ type TimUser struct {
 	TLS              int //
 	Interflow        int
 	Version          int16
+	Bucket           *rateLimit.Bucket //连接级频率限制
+	Priority         int32             //presence优先级 按优先级投递时使用
+	active           int64             //最后活动时间(秒) 原子读写
+	autoAway         int32             //1 空闲自动设置为away 原子读写
+	queue            *outQueue         //异步发送队列
+	seq              uint32            //连接池分片序号
+}
+
+/**记录最后活动时间*/
+func (t *TimUser) Touch() {
+	atomic.StoreInt64(&t.active, time.Now().Unix())
+}
+
+func (t *TimUser) Active() int64 {
+	return atomic.LoadInt64(&t.active)
+}
+
+func (t *TimUser) IsAutoAway() bool {
+	return atomic.LoadInt32(&t.autoAway) == 1
+}
+
+/**设置空闲自动away 状态有变化时返回true*/
+func (t *TimUser) SetAutoAway(b bool) bool {
+	if b {
+		return atomic.CompareAndSwapInt32(&t.autoAway, 0, 1)
+	}
+	return atomic.CompareAndSwapInt32(&t.autoAway, 1, 0)
 }
 
 func (t *TimUser) Auth(tid *Tid) (er error) {
@@ -190,7 +76,7 @@ func (t *TimUser) Auth(tid *Tid) (er error) {
 	t.Sync.Lock()
 	defer t.Sync.Unlock()
 	t.UserTid = tid
-	if CF.SingleClient == 1 {
+	if CF().SingleClient == 1 {
 		TP.AddSingleAuthUser(t)
 	} else {
 		TP.AddAuthUser(t)
@@ -206,13 +92,16 @@ func (t *TimUser) SendMBean(mbean *TimMBean) (er error) {
 			er = errors.New("sendMBean err")
 		}
 	}()
+	if t.queue != nil {
+		return t.queue.push(&outItem{mbeans: []*TimMBean{mbean}})
+	}
 	t.Sync.Lock()
 	defer t.Sync.Unlock()
 	if t.IsClose {
 		er = errors.New("timuser is close")
 		return
 	}
-	if CF.ConfirmAck == 1 {
+	if CF().ConfirmAck == 1 {
 		timer := time.NewTicker(3 * time.Second)
 		t.LastSyncThreadId = mbean.GetThreadId()
 		er = t.Client.TimMessage(mbean)
@@ -248,6 +137,9 @@ func (t *TimUser) SendMBeanList(mbeans []*TimMBean) (er error) {
 			er = errors.New("SendMBeanList err")
 		}
 	}()
+	if t.queue != nil {
+		return t.queue.push(&outItem{mbeans: mbeans, list: true})
+	}
 	t.Sync.Lock()
 	defer t.Sync.Unlock()
 	if t.IsClose {
@@ -257,7 +149,7 @@ func (t *TimUser) SendMBeanList(mbeans []*TimMBean) (er error) {
 	mbeanList := NewTimMBeanList()
 	mbeanList.TimMBeanList = mbeans
 	mbeanList.ThreadId = TimeMills()
-	if CF.ConfirmAck == 1 {
+	if CF().ConfirmAck == 1 {
 		timer := time.NewTicker(5 * time.Second)
 		t.LastSyncThreadId = mbeanList.GetThreadId()
 		er = t.Client.TimMessageList(mbeanList)
@@ -303,9 +195,15 @@ func (t *TimUser) SendPBean(pbean *TimPBean) (er error) {
 			er = errors.New("sendPBean err")
 		}
 	}()
-	if CF.Presence != 1 {
+	//输入状态不受presence开关影响
+	if CF().Presence != 1 && !chatState.IsChatState(pbean.GetType()) {
 		return
 	}
+	if t.queue != nil {
+		//路由时会修改pbean.ToTid 放入队列的是副本
+		p := *pbean
+		return t.queue.push(&outItem{pbeans: []*TimPBean{&p}})
+	}
 	t.Sync.Lock()
 	defer t.Sync.Unlock()
 	er = t.Client.TimPresence(pbean)
@@ -320,9 +218,12 @@ func (t *TimUser) SendPBeanList(pbean []*TimPBean) (er error) {
 			er = errors.New("SendPBeanList err")
 		}
 	}()
-	if CF.Presence != 1 {
+	if CF().Presence != 1 {
 		return
 	}
+	if t.queue != nil && len(pbean) > 0 {
+		return t.queue.push(&outItem{pbeans: pbean, list: true})
+	}
 	t.Sync.Lock()
 	defer t.Sync.Unlock()
 	pbeanList := NewTimPBeanList()
@@ -354,6 +255,9 @@ func (t *TimUser) Close() (er error) {
 			er = errors.New("Close err")
 		}
 	}()
+	if t.queue != nil {
+		t.queue.stop()
+	}
 	t.Sync.Lock()
 	defer t.Sync.Unlock()
 	er = t.Client.Transport.Close()
@@ -376,11 +280,3 @@ func GetLoginName(tid *Tid) (loginname string, er error) {
 	loginname = MD5(fmt.Sprint(domain, "tim", name))
 	return
 }
-
-var TP = TimPool{
-	register:   make(chan *TimUser),
-	unregister: make(chan *TimUser),
-	pool:       make(map[*TimUser]bool),
-	poolUser:   make(map[string]map[*TimUser]bool),
-	rwLock:     new(sync.RWMutex),
-}
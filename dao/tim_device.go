@@ -0,0 +1,474 @@
+package dao
+
+import (
+	"reflect"
+
+	"github.com/donnie4w/gdao"
+)
+
+type tim_device_Id struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *int32
+}
+
+func (c *tim_device_Id) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Id) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Loginname struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Loginname) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Loginname) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Domain struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Domain) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Domain) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Username struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Username) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Username) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Resource struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Resource) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Resource) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Provider struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Provider) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Provider) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Token struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Token) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Token) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Mute struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *int32
+}
+
+func (c *tim_device_Mute) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Mute) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Quietstart struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Quietstart) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Quietstart) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Quietend struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Quietend) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Quietend) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Createtime struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Createtime) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Createtime) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_device_Updatetime struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_device_Updatetime) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_device_Updatetime) Value() interface{} {
+	return c.FieldValue
+}
+
+type Tim_device struct {
+	gdao.Table
+	Id         *tim_device_Id
+	Loginname  *tim_device_Loginname
+	Domain     *tim_device_Domain
+	Username   *tim_device_Username
+	Resource   *tim_device_Resource
+	Provider   *tim_device_Provider
+	Token      *tim_device_Token
+	Mute       *tim_device_Mute
+	Quietstart *tim_device_Quietstart
+	Quietend   *tim_device_Quietend
+	Createtime *tim_device_Createtime
+	Updatetime *tim_device_Updatetime
+}
+
+func (u *Tim_device) GetId() int32 {
+	return *u.Id.FieldValue
+}
+
+func (u *Tim_device) SetId(arg int64) {
+	u.Table.ModifyMap[u.Id.fieldName] = arg
+	v := int32(arg)
+	u.Id.FieldValue = &v
+}
+
+func (u *Tim_device) GetLoginname() string {
+	return *u.Loginname.FieldValue
+}
+
+func (u *Tim_device) SetLoginname(arg string) {
+	u.Table.ModifyMap[u.Loginname.fieldName] = arg
+	v := string(arg)
+	u.Loginname.FieldValue = &v
+}
+
+func (u *Tim_device) GetDomain() string {
+	return *u.Domain.FieldValue
+}
+
+func (u *Tim_device) SetDomain(arg string) {
+	u.Table.ModifyMap[u.Domain.fieldName] = arg
+	v := string(arg)
+	u.Domain.FieldValue = &v
+}
+
+func (u *Tim_device) GetUsername() string {
+	return *u.Username.FieldValue
+}
+
+func (u *Tim_device) SetUsername(arg string) {
+	u.Table.ModifyMap[u.Username.fieldName] = arg
+	v := string(arg)
+	u.Username.FieldValue = &v
+}
+
+func (u *Tim_device) GetResource() string {
+	return *u.Resource.FieldValue
+}
+
+func (u *Tim_device) SetResource(arg string) {
+	u.Table.ModifyMap[u.Resource.fieldName] = arg
+	v := string(arg)
+	u.Resource.FieldValue = &v
+}
+
+func (u *Tim_device) GetProvider() string {
+	return *u.Provider.FieldValue
+}
+
+func (u *Tim_device) SetProvider(arg string) {
+	u.Table.ModifyMap[u.Provider.fieldName] = arg
+	v := string(arg)
+	u.Provider.FieldValue = &v
+}
+
+func (u *Tim_device) GetToken() string {
+	return *u.Token.FieldValue
+}
+
+func (u *Tim_device) SetToken(arg string) {
+	u.Table.ModifyMap[u.Token.fieldName] = arg
+	v := string(arg)
+	u.Token.FieldValue = &v
+}
+
+func (u *Tim_device) GetMute() int32 {
+	return *u.Mute.FieldValue
+}
+
+func (u *Tim_device) SetMute(arg int64) {
+	u.Table.ModifyMap[u.Mute.fieldName] = arg
+	v := int32(arg)
+	u.Mute.FieldValue = &v
+}
+
+func (u *Tim_device) GetQuietstart() string {
+	return *u.Quietstart.FieldValue
+}
+
+func (u *Tim_device) SetQuietstart(arg string) {
+	u.Table.ModifyMap[u.Quietstart.fieldName] = arg
+	v := string(arg)
+	u.Quietstart.FieldValue = &v
+}
+
+func (u *Tim_device) GetQuietend() string {
+	return *u.Quietend.FieldValue
+}
+
+func (u *Tim_device) SetQuietend(arg string) {
+	u.Table.ModifyMap[u.Quietend.fieldName] = arg
+	v := string(arg)
+	u.Quietend.FieldValue = &v
+}
+
+func (u *Tim_device) GetCreatetime() string {
+	return *u.Createtime.FieldValue
+}
+
+func (u *Tim_device) SetCreatetime(arg string) {
+	u.Table.ModifyMap[u.Createtime.fieldName] = arg
+	v := string(arg)
+	u.Createtime.FieldValue = &v
+}
+
+func (u *Tim_device) GetUpdatetime() string {
+	return *u.Updatetime.FieldValue
+}
+
+func (u *Tim_device) SetUpdatetime(arg string) {
+	u.Table.ModifyMap[u.Updatetime.fieldName] = arg
+	v := string(arg)
+	u.Updatetime.FieldValue = &v
+}
+
+func (t *Tim_device) Query(columns ...gdao.Column) ([]Tim_device, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Loginname, t.Domain, t.Username, t.Resource, t.Provider, t.Token, t.Mute, t.Quietstart, t.Quietend, t.Createtime, t.Updatetime}
+	}
+	rs, err := t.Table.Query(columns...)
+	if rs == nil || err != nil {
+		return nil, err
+	}
+	ts := make([]Tim_device, 0, len(rs))
+	c := make(chan int16, len(rs))
+	for _, rows := range rs {
+		t := NewTim_device()
+		go copyTim_device(c, rows, t, columns)
+		<-c
+		ts = append(ts, *t)
+	}
+	return ts, nil
+}
+
+func copyTim_device(channle chan int16, rows []interface{}, t *Tim_device, columns []gdao.Column) {
+	defer func() { channle <- 1 }()
+	for j, core := range rows {
+		if core == nil {
+			continue
+		}
+		field := columns[j].Name()
+		setfield := "Set" + gdao.ToUpperFirstLetter(field)
+		reflect.ValueOf(t).MethodByName(setfield).Call([]reflect.Value{reflect.ValueOf(gdao.GetValue(&core))})
+	}
+}
+
+func (t *Tim_device) QuerySingle(columns ...gdao.Column) (*Tim_device, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Loginname, t.Domain, t.Username, t.Resource, t.Provider, t.Token, t.Mute, t.Quietstart, t.Quietend, t.Createtime, t.Updatetime}
+	}
+	rs, err := t.Table.QuerySingle(columns...)
+	if rs == nil || err != nil {
+		return nil, err
+	}
+	rt := NewTim_device()
+	for j, core := range rs {
+		if core == nil {
+			continue
+		}
+		field := columns[j].Name()
+		setfield := "Set" + gdao.ToUpperFirstLetter(field)
+		reflect.ValueOf(rt).MethodByName(setfield).Call([]reflect.Value{reflect.ValueOf(gdao.GetValue(&core))})
+	}
+	return rt, nil
+}
+
+func (t *Tim_device) Select(columns ...gdao.Column) (*Tim_device, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Loginname, t.Domain, t.Username, t.Resource, t.Provider, t.Token, t.Mute, t.Quietstart, t.Quietend, t.Createtime, t.Updatetime}
+	}
+	rows, err := t.Table.Selects(columns...)
+	defer rows.Close()
+	if err != nil || rows == nil {
+		return nil, err
+	}
+	buff := make([]interface{}, len(columns))
+	if rows.Next() {
+		n := NewTim_device()
+		cpTim_device(buff, n, columns)
+		row_err := rows.Scan(buff...)
+		if row_err != nil {
+			return nil, row_err
+		}
+		return n, nil
+	}
+	return nil, nil
+}
+
+func (t *Tim_device) Selects(columns ...gdao.Column) ([]*Tim_device, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Loginname, t.Domain, t.Username, t.Resource, t.Provider, t.Token, t.Mute, t.Quietstart, t.Quietend, t.Createtime, t.Updatetime}
+	}
+	rows, err := t.Table.Selects(columns...)
+	defer rows.Close()
+	if err != nil || rows == nil {
+		return nil, err
+	}
+	ns := make([]*Tim_device, 0)
+	buff := make([]interface{}, len(columns))
+	for rows.Next() {
+		n := NewTim_device()
+		cpTim_device(buff, n, columns)
+		row_err := rows.Scan(buff...)
+		if row_err != nil {
+			return nil, row_err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+func cpTim_device(buff []interface{}, t *Tim_device, columns []gdao.Column) {
+	for i, column := range columns {
+		field := column.Name()
+		switch field {
+		case "id":
+			buff[i] = &t.Id.FieldValue
+		case "loginname":
+			buff[i] = &t.Loginname.FieldValue
+		case "domain":
+			buff[i] = &t.Domain.FieldValue
+		case "username":
+			buff[i] = &t.Username.FieldValue
+		case "resource":
+			buff[i] = &t.Resource.FieldValue
+		case "provider":
+			buff[i] = &t.Provider.FieldValue
+		case "token":
+			buff[i] = &t.Token.FieldValue
+		case "mute":
+			buff[i] = &t.Mute.FieldValue
+		case "quietstart":
+			buff[i] = &t.Quietstart.FieldValue
+		case "quietend":
+			buff[i] = &t.Quietend.FieldValue
+		case "createtime":
+			buff[i] = &t.Createtime.FieldValue
+		case "updatetime":
+			buff[i] = &t.Updatetime.FieldValue
+		}
+	}
+}
+
+func NewTim_device(tableName ...string) *Tim_device {
+	id := &tim_device_Id{fieldName: "id"}
+	id.Field.FieldName = "id"
+	loginname := &tim_device_Loginname{fieldName: "loginname"}
+	loginname.Field.FieldName = "loginname"
+	domain := &tim_device_Domain{fieldName: "domain"}
+	domain.Field.FieldName = "domain"
+	username := &tim_device_Username{fieldName: "username"}
+	username.Field.FieldName = "username"
+	resource := &tim_device_Resource{fieldName: "resource"}
+	resource.Field.FieldName = "resource"
+	provider := &tim_device_Provider{fieldName: "provider"}
+	provider.Field.FieldName = "provider"
+	token := &tim_device_Token{fieldName: "token"}
+	token.Field.FieldName = "token"
+	mute := &tim_device_Mute{fieldName: "mute"}
+	mute.Field.FieldName = "mute"
+	quietstart := &tim_device_Quietstart{fieldName: "quietstart"}
+	quietstart.Field.FieldName = "quietstart"
+	quietend := &tim_device_Quietend{fieldName: "quietend"}
+	quietend.Field.FieldName = "quietend"
+	createtime := &tim_device_Createtime{fieldName: "createtime"}
+	createtime.Field.FieldName = "createtime"
+	updatetime := &tim_device_Updatetime{fieldName: "updatetime"}
+	updatetime.Field.FieldName = "updatetime"
+	table := &Tim_device{Id: id, Loginname: loginname, Domain: domain, Username: username, Resource: resource, Provider: provider, Token: token, Mute: mute, Quietstart: quietstart, Quietend: quietend, Createtime: createtime, Updatetime: updatetime}
+	table.Table.ModifyMap = make(map[string]interface{})
+	if len(tableName) == 1 {
+		table.Table.TableName = tableName[0]
+	} else {
+		table.Table.TableName = "tim_device"
+	}
+	return table
+}
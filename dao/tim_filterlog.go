@@ -0,0 +1,387 @@
+package dao
+
+import (
+	"reflect"
+
+	"github.com/donnie4w/gdao"
+)
+
+type tim_filterlog_Id struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *int32
+}
+
+func (c *tim_filterlog_Id) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Id) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Domain struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_filterlog_Domain) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Domain) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Fromuser struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_filterlog_Fromuser) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Fromuser) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Touser struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_filterlog_Touser) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Touser) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Msgmode struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *int32
+}
+
+func (c *tim_filterlog_Msgmode) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Msgmode) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Words struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_filterlog_Words) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Words) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Action struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_filterlog_Action) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Action) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Body struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_filterlog_Body) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Body) Value() interface{} {
+	return c.FieldValue
+}
+
+type tim_filterlog_Createtime struct {
+	gdao.Field
+	fieldName  string
+	FieldValue *string
+}
+
+func (c *tim_filterlog_Createtime) Name() string {
+	return c.fieldName
+}
+
+func (c *tim_filterlog_Createtime) Value() interface{} {
+	return c.FieldValue
+}
+
+type Tim_filterlog struct {
+	gdao.Table
+	Id         *tim_filterlog_Id
+	Domain     *tim_filterlog_Domain
+	Fromuser   *tim_filterlog_Fromuser
+	Touser     *tim_filterlog_Touser
+	Msgmode    *tim_filterlog_Msgmode
+	Words      *tim_filterlog_Words
+	Action     *tim_filterlog_Action
+	Body       *tim_filterlog_Body
+	Createtime *tim_filterlog_Createtime
+}
+
+func (u *Tim_filterlog) GetId() int32 {
+	return *u.Id.FieldValue
+}
+
+func (u *Tim_filterlog) SetId(arg int64) {
+	u.Table.ModifyMap[u.Id.fieldName] = arg
+	v := int32(arg)
+	u.Id.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetDomain() string {
+	return *u.Domain.FieldValue
+}
+
+func (u *Tim_filterlog) SetDomain(arg string) {
+	u.Table.ModifyMap[u.Domain.fieldName] = arg
+	v := string(arg)
+	u.Domain.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetFromuser() string {
+	return *u.Fromuser.FieldValue
+}
+
+func (u *Tim_filterlog) SetFromuser(arg string) {
+	u.Table.ModifyMap[u.Fromuser.fieldName] = arg
+	v := string(arg)
+	u.Fromuser.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetTouser() string {
+	return *u.Touser.FieldValue
+}
+
+func (u *Tim_filterlog) SetTouser(arg string) {
+	u.Table.ModifyMap[u.Touser.fieldName] = arg
+	v := string(arg)
+	u.Touser.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetMsgmode() int32 {
+	return *u.Msgmode.FieldValue
+}
+
+func (u *Tim_filterlog) SetMsgmode(arg int64) {
+	u.Table.ModifyMap[u.Msgmode.fieldName] = arg
+	v := int32(arg)
+	u.Msgmode.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetWords() string {
+	return *u.Words.FieldValue
+}
+
+func (u *Tim_filterlog) SetWords(arg string) {
+	u.Table.ModifyMap[u.Words.fieldName] = arg
+	v := string(arg)
+	u.Words.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetAction() string {
+	return *u.Action.FieldValue
+}
+
+func (u *Tim_filterlog) SetAction(arg string) {
+	u.Table.ModifyMap[u.Action.fieldName] = arg
+	v := string(arg)
+	u.Action.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetBody() string {
+	return *u.Body.FieldValue
+}
+
+func (u *Tim_filterlog) SetBody(arg string) {
+	u.Table.ModifyMap[u.Body.fieldName] = arg
+	v := string(arg)
+	u.Body.FieldValue = &v
+}
+
+func (u *Tim_filterlog) GetCreatetime() string {
+	return *u.Createtime.FieldValue
+}
+
+func (u *Tim_filterlog) SetCreatetime(arg string) {
+	u.Table.ModifyMap[u.Createtime.fieldName] = arg
+	v := string(arg)
+	u.Createtime.FieldValue = &v
+}
+
+func (t *Tim_filterlog) Query(columns ...gdao.Column) ([]Tim_filterlog, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Domain, t.Fromuser, t.Touser, t.Msgmode, t.Words, t.Action, t.Body, t.Createtime}
+	}
+	rs, err := t.Table.Query(columns...)
+	if rs == nil || err != nil {
+		return nil, err
+	}
+	ts := make([]Tim_filterlog, 0, len(rs))
+	c := make(chan int16, len(rs))
+	for _, rows := range rs {
+		t := NewTim_filterlog()
+		go copyTim_filterlog(c, rows, t, columns)
+		<-c
+		ts = append(ts, *t)
+	}
+	return ts, nil
+}
+
+func copyTim_filterlog(channle chan int16, rows []interface{}, t *Tim_filterlog, columns []gdao.Column) {
+	defer func() { channle <- 1 }()
+	for j, core := range rows {
+		if core == nil {
+			continue
+		}
+		field := columns[j].Name()
+		setfield := "Set" + gdao.ToUpperFirstLetter(field)
+		reflect.ValueOf(t).MethodByName(setfield).Call([]reflect.Value{reflect.ValueOf(gdao.GetValue(&core))})
+	}
+}
+
+func (t *Tim_filterlog) QuerySingle(columns ...gdao.Column) (*Tim_filterlog, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Domain, t.Fromuser, t.Touser, t.Msgmode, t.Words, t.Action, t.Body, t.Createtime}
+	}
+	rs, err := t.Table.QuerySingle(columns...)
+	if rs == nil || err != nil {
+		return nil, err
+	}
+	rt := NewTim_filterlog()
+	for j, core := range rs {
+		if core == nil {
+			continue
+		}
+		field := columns[j].Name()
+		setfield := "Set" + gdao.ToUpperFirstLetter(field)
+		reflect.ValueOf(rt).MethodByName(setfield).Call([]reflect.Value{reflect.ValueOf(gdao.GetValue(&core))})
+	}
+	return rt, nil
+}
+
+func (t *Tim_filterlog) Select(columns ...gdao.Column) (*Tim_filterlog, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Domain, t.Fromuser, t.Touser, t.Msgmode, t.Words, t.Action, t.Body, t.Createtime}
+	}
+	rows, err := t.Table.Selects(columns...)
+	defer rows.Close()
+	if err != nil || rows == nil {
+		return nil, err
+	}
+	buff := make([]interface{}, len(columns))
+	if rows.Next() {
+		n := NewTim_filterlog()
+		cpTim_filterlog(buff, n, columns)
+		row_err := rows.Scan(buff...)
+		if row_err != nil {
+			return nil, row_err
+		}
+		return n, nil
+	}
+	return nil, nil
+}
+
+func (t *Tim_filterlog) Selects(columns ...gdao.Column) ([]*Tim_filterlog, error) {
+	if columns == nil {
+		columns = []gdao.Column{t.Id, t.Domain, t.Fromuser, t.Touser, t.Msgmode, t.Words, t.Action, t.Body, t.Createtime}
+	}
+	rows, err := t.Table.Selects(columns...)
+	defer rows.Close()
+	if err != nil || rows == nil {
+		return nil, err
+	}
+	ns := make([]*Tim_filterlog, 0)
+	buff := make([]interface{}, len(columns))
+	for rows.Next() {
+		n := NewTim_filterlog()
+		cpTim_filterlog(buff, n, columns)
+		row_err := rows.Scan(buff...)
+		if row_err != nil {
+			return nil, row_err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+func cpTim_filterlog(buff []interface{}, t *Tim_filterlog, columns []gdao.Column) {
+	for i, column := range columns {
+		field := column.Name()
+		switch field {
+		case "id":
+			buff[i] = &t.Id.FieldValue
+		case "domain":
+			buff[i] = &t.Domain.FieldValue
+		case "fromuser":
+			buff[i] = &t.Fromuser.FieldValue
+		case "touser":
+			buff[i] = &t.Touser.FieldValue
+		case "msgmode":
+			buff[i] = &t.Msgmode.FieldValue
+		case "words":
+			buff[i] = &t.Words.FieldValue
+		case "action":
+			buff[i] = &t.Action.FieldValue
+		case "body":
+			buff[i] = &t.Body.FieldValue
+		case "createtime":
+			buff[i] = &t.Createtime.FieldValue
+		}
+	}
+}
+
+func NewTim_filterlog(tableName ...string) *Tim_filterlog {
+	id := &tim_filterlog_Id{fieldName: "id"}
+	id.Field.FieldName = "id"
+	domain := &tim_filterlog_Domain{fieldName: "domain"}
+	domain.Field.FieldName = "domain"
+	fromuser := &tim_filterlog_Fromuser{fieldName: "fromuser"}
+	fromuser.Field.FieldName = "fromuser"
+	touser := &tim_filterlog_Touser{fieldName: "touser"}
+	touser.Field.FieldName = "touser"
+	msgmode := &tim_filterlog_Msgmode{fieldName: "msgmode"}
+	msgmode.Field.FieldName = "msgmode"
+	words := &tim_filterlog_Words{fieldName: "words"}
+	words.Field.FieldName = "words"
+	action := &tim_filterlog_Action{fieldName: "action"}
+	action.Field.FieldName = "action"
+	body := &tim_filterlog_Body{fieldName: "body"}
+	body.Field.FieldName = "body"
+	createtime := &tim_filterlog_Createtime{fieldName: "createtime"}
+	createtime.Field.FieldName = "createtime"
+	table := &Tim_filterlog{Id: id, Domain: domain, Fromuser: fromuser, Touser: touser, Msgmode: msgmode, Words: words, Action: action, Body: body, Createtime: createtime}
+	table.Table.ModifyMap = make(map[string]interface{})
+	if len(tableName) == 1 {
+		table.Table.TableName = tableName[0]
+	} else {
+		table.Table.TableName = "tim_filterlog"
+	}
+	return table
+}
@@ -0,0 +1,310 @@
+package daoService
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/dao"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * 管理命令使用的数据操作 用户、域名、群
+ * 禁用用户时在 tim_user.encryptedpassword 前加 DISABLED 标记，重新设置密码后解除
+ */
+const DISABLED = "!"
+
+var ErrNoDb = errors.New("db not exist")
+
+func getUser(tid *protocol.Tid) (user *dao.Tim_user, err error) {
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	tim_user := dao.NewTim_user()
+	tim_user.Where(tim_user.Loginname.EQ(loginname))
+	return tim_user.Select()
+}
+
+/*新增tim_user用户 encryptedpassword为加密后的密码*/
+func AddUser(tid *protocol.Tid, encryptedpassword, nick string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	if !CheckDomain(tid.GetDomain()) {
+		return errors.New(fmt.Sprint("domain not exist:", tid.GetDomain()))
+	}
+	if user, _ := getUser(tid); user != nil && user.GetId() > 0 {
+		return errors.New(fmt.Sprint("user exist:", tid.GetName()))
+	}
+	loginname, _ := connect.GetLoginName(tid)
+	tim_user := dao.NewTim_user()
+	tim_user.SetLoginname(loginname)
+	tim_user.SetUsername(tid.GetName())
+	tim_user.SetUsernick(nick)
+	tim_user.SetEncryptedpassword(encryptedpassword)
+	tim_user.SetPlainpassword("")
+	tim_user.SetCreatetime(utils.NowTime())
+	tim_user.SetUpdatetime(utils.NowTime())
+	_, err = tim_user.Insert()
+	return
+}
+
+/*禁用tim_user用户*/
+func DisableUser(tid *protocol.Tid) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	user, err := getUser(tid)
+	if err != nil {
+		return
+	}
+	if user == nil || user.GetId() <= 0 {
+		return errors.New(fmt.Sprint("user not exist:", tid.GetName()))
+	}
+	if strings.HasPrefix(user.GetEncryptedpassword(), DISABLED) {
+		return
+	}
+	tim_user := dao.NewTim_user()
+	tim_user.SetEncryptedpassword(DISABLED + user.GetEncryptedpassword())
+	tim_user.SetUpdatetime(utils.NowTime())
+	tim_user.Where(tim_user.Id.EQ(user.GetId()))
+	_, err = tim_user.Update()
+	return
+}
+
+/*tim_user用户是否被禁用*/
+func IsUserDisabled(tid *protocol.Tid) bool {
+	stored, ok, err := GetUserPassword(tid)
+	return err == nil && ok && strings.HasPrefix(stored, DISABLED)
+}
+
+/*新增域名*/
+func AddDomain(domain, remark string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	if CheckDomain(domain) {
+		return errors.New(fmt.Sprint("domain exist:", domain))
+	}
+	tim_domain := dao.NewTim_domain()
+	tim_domain.SetDomain(domain)
+	tim_domain.SetRemark(remark)
+	tim_domain.SetCreatetime(utils.NowTime())
+	_, err = tim_domain.Insert()
+	return
+}
+
+func ListDomains() (domains []*dao.Tim_domain, err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return nil, ErrNoDb
+	}
+	return dao.NewTim_domain().Selects()
+}
+
+/*新建群 同时加入成员*/
+func CreateRoom(roomid *protocol.Tid, name string, members []string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	domain := roomid.GetDomain()
+	tim_mucroom := dao.NewTim_mucroom()
+	tim_mucroom.Where(tim_mucroom.Domain.EQ(domain), tim_mucroom.Roomtid.EQ(roomid.GetName()))
+	if room, _ := tim_mucroom.Select(); room != nil && room.GetId() > 0 {
+		return errors.New(fmt.Sprint("room exist:", roomid.GetName()))
+	}
+	tim_mucroom = dao.NewTim_mucroom()
+	tim_mucroom.SetRoomtid(roomid.GetName())
+	tim_mucroom.SetName(name)
+	tim_mucroom.SetDomain(domain)
+	tim_mucroom.SetCreatetime(utils.NowTime())
+	tim_mucroom.SetUpdatetime(utils.NowTime())
+	if _, err = tim_mucroom.Insert(); err != nil {
+		return
+	}
+	for _, member := range members {
+		tim_mucmember := dao.NewTim_mucmember()
+		tim_mucmember.SetRoomtid(roomid.GetName())
+		tim_mucmember.SetDomain(domain)
+		tim_mucmember.SetTidname(member)
+		tim_mucmember.SetCreatetime(utils.NowTime())
+		tim_mucmember.SetUpdatetime(utils.NowTime())
+		if _, err = tim_mucmember.Insert(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/*用户的联系人 tim_roster*/
+func LoadRoster(tid *protocol.Tid) (rosters []*dao.Tim_roster, err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return nil, ErrNoDb
+	}
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	tim_roster := dao.NewTim_roster()
+	tim_roster.Where(tim_roster.Loginname.EQ(loginname))
+	return tim_roster.Selects()
+}
+
+/*新增联系人 已经存在时不处理*/
+func AddRoster(tid *protocol.Tid, rostername, rostertype, remarknick string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	tim_roster := dao.NewTim_roster()
+	tim_roster.Where(tim_roster.Loginname.EQ(loginname), tim_roster.Rostername.EQ(rostername))
+	if r, _ := tim_roster.Select(); r != nil && r.GetId() > 0 {
+		return
+	}
+	tim_roster = dao.NewTim_roster()
+	tim_roster.SetLoginname(loginname)
+	tim_roster.SetUsername(tid.GetName())
+	tim_roster.SetRostername(rostername)
+	tim_roster.SetRostertype(rostertype)
+	tim_roster.SetRemarknick(remarknick)
+	tim_roster.SetCreatetime(utils.NowTime())
+	_, err = tim_roster.Insert()
+	return
+}
+
+func DelRoster(tid *protocol.Tid, rostername string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	tim_roster := dao.NewTim_roster()
+	tim_roster.Where(tim_roster.Loginname.EQ(loginname), tim_roster.Rostername.EQ(rostername))
+	_, err = tim_roster.Delete()
+	return
+}
+
+/*加入群成员 已经是成员时不处理*/
+func AddMucmember(roomid *protocol.Tid, tidname string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	tid := protocol.NewTid()
+	tid.Name = tidname
+	if AuthMucmember(roomid, tid) {
+		return
+	}
+	tim_mucmember := dao.NewTim_mucmember()
+	tim_mucmember.SetRoomtid(roomid.GetName())
+	tim_mucmember.SetDomain(roomid.GetDomain())
+	tim_mucmember.SetTidname(tidname)
+	tim_mucmember.SetCreatetime(utils.NowTime())
+	tim_mucmember.SetUpdatetime(utils.NowTime())
+	_, err = tim_mucmember.Insert()
+	return
+}
+
+func DelMucmember(roomid *protocol.Tid, tidname string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return ErrNoDb
+	}
+	tim_mucmember := dao.NewTim_mucmember()
+	tim_mucmember.Where(tim_mucmember.Domain.EQ(roomid.GetDomain()), tim_mucmember.Roomtid.EQ(roomid.GetName()), tim_mucmember.Tidname.EQ(tidname))
+	_, err = tim_mucmember.Delete()
+	return
+}
+
+/*群离线信息条数*/
+func CountOfflineMucMBean(tid *protocol.Tid) (count int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || common.CF().DataBase == 1 {
+		return
+	}
+	tim_mucoffline := dao.NewTim_mucoffline()
+	tim_mucoffline.Where(tim_mucoffline.Domain.EQ(tid.GetDomain()), tim_mucoffline.Username.EQ(tid.GetName()))
+	gbbeans, err := tim_mucoffline.QueryBeen(tim_mucoffline.Id.Count())
+	if err == nil && gbbeans != nil && len(gbbeans) > 0 {
+		switch v := gbbeans[0].MapIndex(1).Value().(type) {
+		case []byte:
+			count = utils.Atoi(string(v))
+		default:
+			count = utils.Atoi(fmt.Sprint(v))
+		}
+	}
+	return
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"runtime/debug"
 	"strconv"
-	"strings"
 	"time"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
@@ -21,6 +20,7 @@ import (
 	"github.com/zhangjunfang/im/dao"
 	"github.com/zhangjunfang/im/hbase"
 	"github.com/zhangjunfang/im/hbaseService"
+	"github.com/zhangjunfang/im/interceptor"
 	"github.com/zhangjunfang/im/myDb"
 	"github.com/zhangjunfang/im/myMap"
 	"github.com/zhangjunfang/im/protocol"
@@ -33,13 +33,13 @@ var domainmap *myMap.HashTable = myMap.NewHashTable()
 
 func initAuthProviderDB() {
 	logger.Info("initAuthProviderDB")
-	authProviderDB, _ = myDb.GetDB(common.CF.GetKV("tim.mysql.connection", ""), 100, 10)
+	authProviderDB, _ = myDb.GetDB(common.CF().GetKV("tim.mysql.connection", ""), 100, 10)
 }
 
 func InitDaoservice() {
 	AddConf()
 	updateVersion()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbase.Init()
 	}
 }
@@ -48,23 +48,38 @@ func InitDaoservice() {
 func SaveOfflineMBeanList(mbeans []*protocol.TimMBean) {
 	if mbeans != nil && len(mbeans) > 0 {
 		for _, mbean := range mbeans {
-			SaveOfflineMBean(mbean)
+			if storeOfflineMBean(mbean) {
+				interceptor.Offline(mbean)
+			}
 		}
 	}
 }
 
 func SaveOfflineMBean(mbean *protocol.TimMBean) {
+	if storeOfflineMBean(mbean) {
+		interceptor.Offline(mbean)
+	}
+}
+
+/**保存成功后返回true 抄送不保存*/
+func storeOfflineMBean(mbean *protocol.TimMBean) (saved bool) {
 	defer func() {
 		if err := recover(); err != nil {
+			saved = false
 			logger.Error("SaveOfflineMBean,", err)
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if connect.IsCarbon(mbean) {
+		//抄送不保存为离线消息
+		return
+	}
+	if common.CF().DataBase == 1 {
 		hbaseService.SaveOfflineMBean(mbean)
 	} else {
 		_SaveOfflineMBean(mbean)
 	}
+	return true
 }
 
 /*保存离线信息*/
@@ -75,7 +90,7 @@ func _SaveOfflineMBean(mbean *protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	if mbean.GetType() == "groupchat" {
@@ -125,10 +140,10 @@ func LoadOfflineMBean(tid *protocol.Tid) (mbeans []*protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		return hbaseService.LoadOfflineMBean(tid)
 	} else {
 		return _LoadOfflineMBean(tid)
@@ -142,7 +157,7 @@ func _LoadOfflineMBean(tid *protocol.Tid) (mbeans []*protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_offline := dao.NewTim_offline()
@@ -172,7 +187,7 @@ func LoadOfflineMucMBean(tid *protocol.Tid) (mbeans []*protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		return hbaseService.LoadOfflineMucMBean(tid)
 	} else {
 		return _LoadOfflineMucMBean(tid)
@@ -186,7 +201,7 @@ func _LoadOfflineMucMBean(tid *protocol.Tid) (mbeans []*protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_mucoffline := dao.NewTim_mucoffline()
@@ -202,7 +217,7 @@ func _LoadOfflineMucMBean(tid *protocol.Tid) (mbeans []*protocol.TimMBean) {
 		tim_mucmessage.Where(tim_mucmessage.Id.IN(mids...))
 		mucmessages, err := tim_mucmessage.Selects()
 		if err == nil && mucmessages != nil && len(mucmessages) > 0 {
-			mbeans := make([]*protocol.TimMBean, 0)
+			mbeans = make([]*protocol.TimMBean, 0)
 			for _, mucmsg := range mucmessages {
 				var timmbean *protocol.TimMBean = protocol.NewTimMBean()
 				bb, er := base64Util.Base64Decode(mucmsg.GetStanza())
@@ -224,10 +239,10 @@ func LoadMucmember(roomid *protocol.Tid) (tids []*protocol.Tid) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return nil
 	}
-	mucRoomSQL := common.CF.GetKV("tim.mysql.mucRoomSQL", "")
+	mucRoomSQL := common.CF().GetKV("tim.mysql.mucRoomSQL", "")
 	if mucRoomSQL == "" {
 		tim_mucmember := dao.NewTim_mucmember()
 		tim_mucmember.Where(tim_mucmember.Domain.EQ(roomid.GetDomain()), tim_mucmember.Roomtid.EQ(roomid.GetName()))
@@ -269,10 +284,10 @@ func AuthMucmember(roomid, tid *protocol.Tid) (b bool) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return true
 	}
-	mucAuthSQL := common.CF.GetKV("tim.mysql.mucAuthSQL", "")
+	mucAuthSQL := common.CF().GetKV("tim.mysql.mucAuthSQL", "")
 	if mucAuthSQL == "" {
 		tim_mucmember := dao.NewTim_mucmember()
 		tim_mucmember.Where(tim_mucmember.Domain.EQ(roomid.GetDomain()), tim_mucmember.Roomtid.EQ(roomid.GetName()), tim_mucmember.Tidname.EQ(tid.GetName()))
@@ -302,10 +317,10 @@ func DelOfflineMBean(mid *string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.DelOfflineMBean(mid)
 	} else {
 		_DelOfflineMBean(mid)
@@ -320,7 +335,7 @@ func _DelOfflineMBean(mid *string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_offline := dao.NewTim_offline()
@@ -335,10 +350,10 @@ func DelOfflineMucMBean(mid *string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.DelOfflineMucMBean(mid)
 	} else {
 		_DelOfflineMucMBean(mid)
@@ -352,7 +367,7 @@ func _DelOfflineMucMBean(mid *string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_mucoffline := dao.NewTim_mucoffline()
@@ -367,10 +382,10 @@ func DelOfflineMBeanList(mids ...interface{}) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.DelOfflineMBeanList(mids...)
 	} else {
 		_DelOfflineMBeanList(mids...)
@@ -385,7 +400,7 @@ func _DelOfflineMBeanList(mids ...interface{}) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_offline := dao.NewTim_offline()
@@ -400,10 +415,10 @@ func DelOfflineMucMBeanList(mids ...interface{}) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.DelOfflineMucMBeanList(mids...)
 	} else {
 		_DelOfflineMucMBeanList(mids...)
@@ -417,7 +432,7 @@ func _DelOfflineMucMBeanList(mids ...interface{}) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_mucoffline := dao.NewTim_mucoffline()
@@ -433,10 +448,10 @@ func SaveMBean(mbean *protocol.TimMBean) (mid string, timestamp string, err erro
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		return hbaseService.SaveMBean(mbean)
 	} else {
 		return _saveMBean(mbean, 1, 1)
@@ -451,7 +466,7 @@ func SaveSingleMBean(mbean *protocol.TimMBean) (mid string, timestamp string, er
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		return hbaseService.SaveSingleMBean(mbean)
 	} else {
 		return _SaveSingleMBean(mbean)
@@ -466,7 +481,7 @@ func _SaveSingleMBean(mbean *protocol.TimMBean) (mid string, timestamp string, e
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		if mbean.GetMid() == "" {
 			mid = fmt.Sprint(utils.GetRand(100000000))
 			mbean.Mid = &mid
@@ -493,7 +508,7 @@ func _saveMBean(mbean *protocol.TimMBean, small, large int) (mid string, timesta
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		if mbean.GetMid() == "" {
 			mid := fmt.Sprint(utils.GetRand(100000000))
 			mbean.Mid = &mid
@@ -534,7 +549,7 @@ func SaveMucMBean(mbean *protocol.TimMBean) (mid string, err error) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		return hbaseService.SaveMucMBean(mbean)
 	} else {
 		return _SaveMucMBean(mbean)
@@ -578,7 +593,7 @@ func UpdateOffMessage(mbean *protocol.TimMBean, status int) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.UpdateOffMessage(mbean, status)
 	} else {
 		_UpdateOffMessage(mbean, status)
@@ -595,7 +610,7 @@ func _UpdateOffMessage(mbean *protocol.TimMBean, status int) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	//	domain := mbean.GetFromTid().GetDomain()
@@ -619,7 +634,7 @@ func UpdateOffMessageList(mbeans []*protocol.TimMBean, status int) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.UpdateOffMessageList(mbeans, status)
 	} else {
 		_UpdateOffMessageList(mbeans, status)
@@ -636,7 +651,7 @@ func _UpdateOffMessageList(mbeans []*protocol.TimMBean, status int) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	if len(mbeans) == 0 {
@@ -664,7 +679,7 @@ func LoadMBean(fidname, tidname, domain string, fromstamp, tostamp *string, limi
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		return hbaseService.LoadMBean(fidname, tidname, domain, fromstamp, tostamp, limitcount)
 	} else {
 		return _LoadMBean(fidname, tidname, domain, fromstamp, tostamp, limitcount)
@@ -679,7 +694,7 @@ func _LoadMBean(fidname, tidname, domain string, fromstamp, tostamp *string, lim
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return nil
 	}
 	chatid := utils.Chatid(fidname, tidname, domain)
@@ -730,7 +745,7 @@ func DelMBean(fidname, tidname, domain, mid string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.DelMBean(fidname, tidname, domain, mid)
 	} else {
 		_DelMBean(fidname, tidname, domain, mid)
@@ -744,7 +759,7 @@ func _DelMBean(fidname, tidname, domain, mid string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	chatid := utils.Chatid(fidname, tidname, domain)
@@ -759,13 +774,43 @@ func _DelMBean(fidname, tidname, domain, mid string) {
 	timMessage.Update()
 }
 
+/**删除刚保存的消息 POST_STORE 拦截器拒绝时调用*/
+func DelStoredMBean(mbean *protocol.TimMBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("DelStoredMBean,", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || mbean.GetMid() == "" {
+		return
+	}
+	if common.CF().DataBase == 1 {
+		hbaseService.DelStoredMBean(mbean)
+	} else {
+		_DelStoredMBean(mbean)
+	}
+}
+
+func _DelStoredMBean(mbean *protocol.TimMBean) {
+	if mbean.GetType() == "groupchat" {
+		tim_mucmessage := dao.NewTim_mucmessage()
+		tim_mucmessage.Where(tim_mucmessage.Id.EQ(mbean.GetMid()))
+		tim_mucmessage.Delete()
+	} else {
+		tim_message := dao.NewTim_message()
+		tim_message.Where(tim_message.Id.EQ(mbean.GetMid()))
+		tim_message.Delete()
+	}
+}
+
 func DelAllMBean(fidname, tidname, domain string) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.DataBase == 1 {
+	if common.CF().DataBase == 1 {
 		hbaseService.DelAllMBean(fidname, tidname, domain)
 	} else {
 		_DelAllMBean(fidname, tidname, domain)
@@ -778,7 +823,7 @@ func _DelAllMBean(fidname, tidname, domain string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	chatid := utils.Chatid(fidname, tidname, domain)
@@ -798,111 +843,251 @@ func _DelAllMBean(fidname, tidname, domain string) {
 //	return
 //}
 
-/**ip地址是否被限制*/
+/**ip地址是否被限制 HttpAllowIps(ip或CIDR)为空时不限制*/
 func AllowHttpIp(ip string) bool {
-	return true
+	ips := common.CF().HttpAllowIpList()
+	return len(ips) == 0 || utils.MatchIp(ip, ips)
 }
 
 func IsTidExist(tid *protocol.Tid) bool {
 	return true
 }
 
-func Auth(tid *protocol.Tid, pwd string) (b bool) {
-	if common.CF.MustAuth == 0 {
-		return true
-	}
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error(string(debug.Stack()))
+/*配置的密码验证sql tim.mysql.passwordSQL passwordSQL1...passwordSQL4*/
+func PasswordSQLs() (sqls []string) {
+	sqls = make([]string, 0)
+	for i := 0; i < 5; i++ {
+		index := ""
+		if i > 0 {
+			index = fmt.Sprint(i)
 		}
-	}()
-	authProvider_passwordSQL := common.CF.GetKV("tim.mysql.passwordSQL", "")
-	if authProvider_passwordSQL == "" {
-		b = _auth(tid, pwd)
-	} else {
-		provider()
-		if authProviderDB == nil {
-			logger.Error("authProviderDB is nil")
-			return false
-		}
-		for i := 0; i < 5; i++ {
-			index := ""
-			if i > 0 {
-				index = fmt.Sprint(i)
-			}
-			authProvider_passwordSQL := common.CF.GetKV(fmt.Sprint("tim.mysql.passwordSQL", index), "")
-			if authProvider_passwordSQL == "" {
-				continue
-			}
-			b = _auth4Sql(authProvider_passwordSQL, tid, pwd)
-			if b {
-				break
-			}
+		passwordSQL := common.CF().GetKV(fmt.Sprint("tim.mysql.passwordSQL", index), "")
+		if passwordSQL != "" {
+			sqls = append(sqls, passwordSQL)
 		}
 	}
 	return
 }
 
-func _auth4Sql(authProvider_passwordSQL string, tid *protocol.Tid, pwd string) (b bool) {
+/*执行passwordSQL 获取password值*/
+func GetPassword4Sql(passwordSQL string, tid *protocol.Tid) (password string, ok bool, err error) {
 	defer func() {
-		if err := recover(); err != nil {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
 			logger.Error(string(debug.Stack()))
 		}
 	}()
 	provider()
 	if authProviderDB == nil {
-		logger.Error("authProviderDB is nil")
-		return false
+		return "", false, errors.New("authProviderDB is nil")
 	}
-	gbbean, err := gdao.Query(authProviderDB, authProvider_passwordSQL, tid.GetName())
+	gbbean, err := gdao.Query(authProviderDB, passwordSQL, tid.GetName())
 	if err == nil && gbbean != nil && len(gbbean) == 1 {
-		if bean, ok := gbbean[0].FieldMapName["password"]; ok {
-			switch common.CF.GetKV("authProvider.passwordType", "") {
-			case "plain":
-				b = eqString(bean.ValueString(), pwd)
-			case "md5":
-				b = eqString(bean.ValueString(), utils.MD5(pwd))
-			case "sha1":
-				b = eqString(bean.ValueString(), utils.Sha1(pwd))
-			default:
-				b = eqString(bean.ValueString(), pwd)
-			}
+		if bean, exist := gbbean[0].FieldMapName["password"]; exist {
+			password, ok = bean.ValueString(), true
 		}
 	}
 	return
 }
 
-func _auth(tid *protocol.Tid, pwd string) (b bool) {
+/*tim_user 中的加密密码*/
+func GetUserPassword(tid *protocol.Tid) (password string, ok bool, err error) {
 	defer func() {
-		if err := recover(); err != nil {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
 			logger.Error(string(debug.Stack()))
 		}
 	}()
+	if common.CF().Db_Exsit == 0 {
+		return
+	}
 	loginname, _ := connect.GetLoginName(tid)
 	tim_user := dao.NewTim_user()
 	tim_user.Where(tim_user.Loginname.EQ(loginname))
 	user, err := tim_user.Select()
 	if err == nil && user != nil {
-		switch common.CF.GetKV("authProvider.passwordType", "") {
-		case "plain":
-			b = eqString(user.GetEncryptedpassword(), pwd)
-		case "md5":
-			b = eqString(user.GetEncryptedpassword(), utils.MD5(pwd))
-		case "sha1":
-			b = eqString(user.GetEncryptedpassword(), utils.Sha1(pwd))
+		password, ok = user.GetEncryptedpassword(), true
+	}
+	return
+}
+
+/*保存敏感词过滤记录 供审核*/
+func SaveFilterLog(domain, fromuser, touser string, msgmode int, words, action, body string) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("SaveFilterLog,", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || common.CF().DataBase == 1 {
+		logger.Warn("filter:", domain, " ", fromuser, "->", touser, " ", action, " [", words, "]")
+		return
+	}
+	filterlog := dao.NewTim_filterlog()
+	filterlog.SetDomain(domain)
+	filterlog.SetFromuser(fromuser)
+	filterlog.SetTouser(touser)
+	filterlog.SetMsgmode(int64(msgmode))
+	filterlog.SetWords(words)
+	filterlog.SetAction(action)
+	filterlog.SetBody(body)
+	filterlog.SetCreatetime(utils.NowTime())
+	filterlog.Insert()
+}
+
+/*注册推送设备 同一用户同一resource只保留一个设备，token注册到其他用户时从其他用户删除*/
+func SaveDevice(tid *protocol.Tid, provider, token string, mute int, quietstart, quietend string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || common.CF().DataBase == 1 {
+		return errors.New("device not supported")
+	}
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	tim_device := dao.NewTim_device()
+	tim_device.Where(tim_device.Token.EQ(token))
+	tim_device.Delete()
+	DelDevice(tid)
+	tim_device = dao.NewTim_device()
+	tim_device.SetLoginname(loginname)
+	tim_device.SetDomain(tid.GetDomain())
+	tim_device.SetUsername(tid.GetName())
+	tim_device.SetResource(tid.GetResource())
+	tim_device.SetProvider(provider)
+	tim_device.SetToken(token)
+	tim_device.SetMute(int64(mute))
+	tim_device.SetQuietstart(quietstart)
+	tim_device.SetQuietend(quietend)
+	tim_device.SetCreatetime(utils.NowTime())
+	tim_device.SetUpdatetime(utils.NowTime())
+	_, err = tim_device.Insert()
+	return
+}
+
+/*删除用户当前resource的推送设备*/
+func DelDevice(tid *protocol.Tid) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || common.CF().DataBase == 1 {
+		return
+	}
+	loginname, _ := connect.GetLoginName(tid)
+	tim_device := dao.NewTim_device()
+	tim_device.Where(tim_device.Loginname.EQ(loginname), tim_device.Resource.EQ(tid.GetResource()))
+	tim_device.Delete()
+}
+
+/*删除无效的设备token*/
+func DelDeviceToken(token string) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || common.CF().DataBase == 1 {
+		return
+	}
+	tim_device := dao.NewTim_device()
+	tim_device.Where(tim_device.Token.EQ(token))
+	tim_device.Delete()
+}
+
+/*用户所有resource的推送设备*/
+func LoadDevices(tid *protocol.Tid) (devices []*dao.Tim_device) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || common.CF().DataBase == 1 {
+		return
+	}
+	loginname, _ := connect.GetLoginName(tid)
+	tim_device := dao.NewTim_device()
+	tim_device.Where(tim_device.Loginname.EQ(loginname))
+	devices, _ = tim_device.Selects()
+	return
+}
+
+/*离线信息条数 用于推送角标*/
+func CountOfflineMBean(tid *protocol.Tid) (count int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if common.CF().Db_Exsit == 0 || common.CF().DataBase == 1 {
+		return
+	}
+	tim_offline := dao.NewTim_offline()
+	tim_offline.Where(tim_offline.Domain.EQ(tid.GetDomain()), tim_offline.Username.EQ(tid.GetName()))
+	gbbeans, err := tim_offline.QueryBeen(tim_offline.Id.Count())
+	if err == nil && gbbeans != nil && len(gbbeans) > 0 {
+		switch v := gbbeans[0].MapIndex(1).Value().(type) {
+		case []byte:
+			count = utils.Atoi(string(v))
 		default:
-			b = eqString(user.GetEncryptedpassword(), pwd)
+			count = utils.Atoi(fmt.Sprint(v))
+		}
+	}
+	return
+}
+
+/*更新tim_user密码 同时清除明文密码*/
+func UpdateUserPassword(tid *protocol.Tid, encryptedpassword string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
 		}
+	}()
+	if common.CF().Db_Exsit == 0 {
+		return errors.New("db not exist")
 	}
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	tim_user := dao.NewTim_user()
+	tim_user.SetEncryptedpassword(encryptedpassword)
+	tim_user.SetPlainpassword("")
+	tim_user.SetUpdatetime(utils.NowTime())
+	tim_user.Where(tim_user.Loginname.EQ(loginname))
+	_, err = tim_user.Update()
 	return
 }
 
-func eqString(s1, s2 string) bool {
-	return strings.ToUpper(s1) == strings.ToUpper(s2)
+/*业务库更新密码 tim.mysql.passwordUpdateSQL 如 update user set password=? where username=?*/
+func UpdatePassword4Sql(tid *protocol.Tid, encryptedpassword string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	passwordUpdateSQL := common.CF().GetKV("tim.mysql.passwordUpdateSQL", "")
+	if passwordUpdateSQL == "" {
+		return errors.New("passwordUpdateSQL is empty")
+	}
+	provider()
+	if authProviderDB == nil {
+		return errors.New("authProviderDB is nil")
+	}
+	_, err = authProviderDB.Exec(passwordUpdateSQL, encryptedpassword, tid.GetName())
+	return
 }
 
 func provider() {
-	if authProviderDB == nil && common.CF.GetKV("tim.mysql.connection", "") != "" {
+	if authProviderDB == nil && common.CF().GetKV("tim.mysql.connection", "") != "" {
 		once.Do(initAuthProviderDB)
 	}
 }
@@ -913,7 +1098,7 @@ func CheckDomain(domain string) bool {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return true
 	}
 	d := domainmap.Get(domain)
@@ -941,15 +1126,16 @@ func AddConf() {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_config := dao.NewIm_config()
+	kv := make(map[string]string, 0)
 	confs, err := tim_config.Selects()
 	if err == nil && confs != nil && len(confs) > 0 {
 		for _, conf := range confs {
 			if conf.GetKeyword() != "" && conf.GetValuestr() != "" {
-				common.CF.KV[conf.GetKeyword()] = conf.GetValuestr()
+				kv[conf.GetKeyword()] = conf.GetValuestr()
 			}
 		}
 	}
@@ -959,13 +1145,14 @@ func AddConf() {
 		for _, property := range propertys {
 			if property.GetKeyword() != "" && (property.GetValueint() > 0 || property.GetValuestr() != "") {
 				if property.GetValuestr() != "" {
-					common.CF.KV[property.GetKeyword()] = property.GetValuestr()
+					kv[property.GetKeyword()] = property.GetValuestr()
 				} else if property.GetValueint() > 0 {
-					common.CF.KV[property.GetKeyword()] = fmt.Sprint(property.GetValueint())
+					kv[property.GetKeyword()] = fmt.Sprint(property.GetValueint())
 				}
 			}
 		}
 	}
+	common.SetDbKV(kv)
 }
 
 //
@@ -975,13 +1162,13 @@ func GetOnlineRoser(fromtid *protocol.Tid) (tids []*protocol.Tid) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return nil
 	}
 	domain := fromtid.GetDomain()
 	fromname := fromtid.GetName()
 	//	logger.Debug(domain, " ", fromname)
-	authProvider_rosterSql := common.CF.GetKV("tim.mysql.rosterSQL", "")
+	authProvider_rosterSql := common.CF().GetKV("tim.mysql.rosterSQL", "")
 	loginname, _ := connect.GetLoginName(fromtid)
 	if authProvider_rosterSql == "" {
 		tim_roster := dao.NewTim_roster()
@@ -1024,7 +1211,7 @@ func updateVersion() {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	timDomain := dao.NewIm_config()
@@ -25,7 +25,7 @@ var once sync.Once
 
 func Init() {
 	once.Do(func() {
-		maxOpenConns, maxIdleConns, minOpenConns, timeoutConns, IdleTimeOut = common.CF.GetHbaseArgs(100, 50, 10, 5, 180)
+		maxOpenConns, maxIdleConns, minOpenConns, timeoutConns, IdleTimeOut = common.CF().GetHbaseArgs(100, 50, 10, 5, 180)
 		pool = make(chan *clientBean, maxOpenConns)
 		initmin := minOpenConns - len(pool)
 		if initmin > 0 {
@@ -37,6 +37,21 @@ func Init() {
 	})
 }
 
+/**关闭连接池中的连接*/
+func Close() {
+	if pool == nil {
+		return
+	}
+	for {
+		select {
+		case t := <-pool:
+			ClientPool.del(t)
+		default:
+			return
+		}
+	}
+}
+
 func putPool(t *clientBean) {
 	t.createtime = utils.TimeMillsInt64()
 	pool <- t
@@ -145,7 +160,7 @@ func _NewHbaseClient() (hbaseClient *THBaseServiceClient) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	addr := common.CF.HbaseAddr
+	addr := common.CF().HbaseAddr
 	if addr == "" {
 		addr = "127.0.0.1:9090"
 	}
@@ -32,7 +32,7 @@ func SaveOfflineMBean(mbean *protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	if mbean.GetType() == "groupchat" {
@@ -114,7 +114,7 @@ func LoadOfflineMBean(tid *protocol.Tid) (mbeans []*protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	/**
@@ -172,7 +172,7 @@ func LoadOfflineMucMBean(tid *protocol.Tid) (mbeans []*protocol.TimMBean) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	/***
@@ -239,11 +239,11 @@ func LoadMucmember(roomid *Tid) (tids []*Tid) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return nil
 	}
 
-	mucRoomSQL := common.CF.GetKV("tim.mysql.mucRoomSQL", "")
+	mucRoomSQL := common.CF().GetKV("tim.mysql.mucRoomSQL", "")
 	if mucRoomSQL == "" {
 		tim_mucmember := dao.NewTim_mucmember()
 		tim_mucmember.Where(tim_mucmember.Domain.EQ(roomid.GetDomain()), tim_mucmember.Roomtid.EQ(roomid.GetName()))
@@ -287,10 +287,10 @@ func AuthMucmember(roomid, tid *Tid) (b bool) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return true
 	}
-	mucAuthSQL := common.CF.GetKV("tim.mysql.mucAuthSQL", "")
+	mucAuthSQL := common.CF().GetKV("tim.mysql.mucAuthSQL", "")
 	if mucAuthSQL == "" {
 		tim_mucmember := dao.NewTim_mucmember()
 		tim_mucmember.Where(tim_mucmember.Domain.EQ(roomid.GetDomain()), tim_mucmember.Roomtid.EQ(roomid.GetName()), tim_mucmember.Tidname.EQ(tid.GetName()))
@@ -322,7 +322,7 @@ func DelOfflineMBean(mid *string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	/***
@@ -334,6 +334,22 @@ func DelOfflineMBean(mid *string) {
 	tim_offline.Delete(row)
 }
 
+/**删除刚保存的消息 POST_STORE 拦截器拒绝时调用*/
+func DelStoredMBean(mbean *protocol.TimMBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("DelStoredMBean,", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	row := utils.Atoi64(mbean.GetMid())
+	if mbean.GetType() == "groupchat" {
+		new(hbase.Tim_mucmessage).Delete(row)
+	} else {
+		hbase.DeleteRow(new(hbase.Tim_message).Tablename(), row)
+	}
+}
+
 func DelOfflineMucMBean(mid *string) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -341,7 +357,7 @@ func DelOfflineMucMBean(mid *string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	//	tim_mucoffline := dao.NewTim_mucoffline()
@@ -359,7 +375,7 @@ func DelOfflineMBeanList(mids ...interface{}) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	//	tim_offline := dao.NewTim_offline()
@@ -385,7 +401,7 @@ func DelOfflineMucMBeanList(mids ...interface{}) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	//	tim_mucoffline := dao.NewTim_mucoffline()
@@ -407,7 +423,7 @@ func SaveMBean(mbean *protocol.TimMBean) (mid string, timestamp string, err erro
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	return _saveMBean(mbean, 1, 1)
@@ -421,7 +437,7 @@ func SaveSingleMBean(mbean *protocol.TimMBean) (mid string, timestamp string, er
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		if mbean.GetMid() == "" {
 			mid = fmt.Sprint(utils.GetRand(100000000))
 			mbean.Mid = &mid
@@ -448,7 +464,7 @@ func _saveMBean(mbean *protocol.TimMBean, small, large int) (mid string, timesta
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		if mbean.GetMid() == "" {
 			mid := fmt.Sprint(utils.GetRand(100000000))
 			mbean.Mid = &mid
@@ -567,7 +583,7 @@ func UpdateOffMessage(mbean *protocol.TimMBean, status int) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	//	domain := mbean.GetFromTid().GetDomain()
@@ -603,7 +619,7 @@ func UpdateOffMessageList(mbeans []*protocol.TimMBean, status int) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	if len(mbeans) == 0 {
@@ -645,7 +661,7 @@ func LoadMBean(fidname, tidname, domain string, fromstamp, tostamp *string, limi
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return nil
 	}
 	chatid := utils.Chatid(fidname, tidname, domain)
@@ -739,7 +755,7 @@ func DelMBean(fidname, tidname, domain, mid string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	chatid := utils.Chatid(fidname, tidname, domain)
@@ -771,7 +787,7 @@ func DelAllMBean(fidname, tidname, domain string) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	chatid := utils.Chatid(fidname, tidname, domain)
@@ -800,9 +816,10 @@ func DelAllMBean(fidname, tidname, domain string) {
 //	return
 //}
 
-/**ip地址是否被限制*/
+/**ip地址是否被限制 HttpAllowIps(ip或CIDR)为空时不限制*/
 func AllowHttpIp(ip string) bool {
-	return true
+	ips := common.CF().HttpAllowIpList()
+	return len(ips) == 0 || utils.MatchIp(ip, ips)
 }
 
 func IsTidExist(tid *protocol.Tid) bool {
@@ -810,115 +827,13 @@ func IsTidExist(tid *protocol.Tid) bool {
 }
 
 /**
-func Auth(tid *Tid, pwd string) (b bool) {
-	if common.CF.MustAuth == 0 {
-		return true
-	}
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	authProvider_passwordSQL := common.CF.GetKV("tim.mysql.passwordSQL", "")
-	if authProvider_passwordSQL == "" {
-		b = _auth(tid, pwd)
-	} else {
-		provider()
-		if authProviderDB == nil {
-			logger.Error("authProviderDB is nil")
-			return false
-		}
-		for i := 0; i < 5; i++ {
-			index := ""
-			if i > 0 {
-				index = fmt.Sprint(i)
-			}
-			authProvider_passwordSQL := common.CF.GetKV(fmt.Sprint("tim.mysql.passwordSQL", index), "")
-			if authProvider_passwordSQL == "" {
-				continue
-			}
-			b = _auth4Sql(authProvider_passwordSQL, tid, pwd)
-			if b {
-				break
-			}
-		}
-	}
-	return
-}**/
-
-/**
-func _auth4Sql(authProvider_passwordSQL string, tid *Tid, pwd string) (b bool) {
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error(string(debug.Stack()))
-		}
-	}()
-	provider()
-	if authProviderDB == nil {
-		logger.Error("authProviderDB is nil")
-		return false
-	}
-	gbbean, err := gdao.Query(authProviderDB, authProvider_passwordSQL, tid.GetName())
-	if err == nil && gbbean != nil && len(gbbean) == 1 {
-		if bean, ok := gbbean[0].FieldMapName["password"]; ok {
-			switch common.CF.GetKV("authProvider.passwordType", "") {
-			case "plain":
-				b = eqString(bean.ValueString(), pwd)
-			case "md5":
-				b = eqString(bean.ValueString(), utils.MD5(pwd))
-			case "sha1":
-				b = eqString(bean.ValueString(), utils.Sha1(pwd))
-			default:
-				b = eqString(bean.ValueString(), pwd)
-			}
-		}
-	}
-	return
-}
-**/
-/**
-func _auth(tid *Tid, pwd string) (b bool) {
-		defer func() {
-			if err := recover(); err != nil {
-				logger.Error(string(debug.Stack()))
-			}
-		}()
-		loginname, _ := connect.GetLoginName(tid)
-		tim_user := dao.NewTim_user()
-		tim_user.Where(tim_user.Loginname.EQ(loginname))
-		user, err := tim_user.Select()
-		if err == nil && user != nil {
-			switch common.CF.GetKV("authProvider.passwordType", "") {
-			case "plain":
-				b = eqString(user.GetEncryptedpassword(), pwd)
-			case "md5":
-				b = eqString(user.GetEncryptedpassword(), utils.MD5(pwd))
-			case "sha1":
-				b = eqString(user.GetEncryptedpassword(), utils.Sha1(pwd))
-			default:
-				b = eqString(user.GetEncryptedpassword(), pwd)
-			}
-		}
-	return
-}*/
-
-/**
-func eqString(s1, s2 string) bool {
-	return strings.ToUpper(s1) == strings.ToUpper(s2)
-}
-
-func provider() {
-		if authProviderDB == nil && common.CF.GetKV("tim.mysql.connection", "") != "" {
-			once.Do(initAuthProviderDB)
-		}
-}
 func CheckDomain(domain string) bool {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return true
 	}
 	d := domainmap.Get(domain)
@@ -948,15 +863,16 @@ func AddConf() {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	tim_config := dao.NewTim_config()
+	kv := make(map[string]string, 0)
 	confs, err := tim_config.Selects()
 	if err == nil && confs != nil && len(confs) > 0 {
 		for _, conf := range confs {
 			if conf.GetKeyword() != "" && conf.GetValuestr() != "" {
-				common.CF.KV[conf.GetKeyword()] = conf.GetValuestr()
+				kv[conf.GetKeyword()] = conf.GetValuestr()
 			}
 		}
 	}
@@ -966,13 +882,14 @@ func AddConf() {
 		for _, property := range propertys {
 			if property.GetKeyword() != "" && (property.GetValueint() > 0 || property.GetValuestr() != "") {
 				if property.GetValuestr() != "" {
-					common.CF.KV[property.GetKeyword()] = property.GetValuestr()
+					kv[property.GetKeyword()] = property.GetValuestr()
 				} else if property.GetValueint() > 0 {
-					common.CF.KV[property.GetKeyword()] = fmt.Sprint(property.GetValueint())
+					kv[property.GetKeyword()] = fmt.Sprint(property.GetValueint())
 				}
 			}
 		}
 	}
+	common.SetDbKV(kv)
 }***/
 
 /***
@@ -982,13 +899,13 @@ func GetOnlineRoser(fromtid *Tid) (tids []*Tid) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return nil
 	}
 	domain := fromtid.GetDomain()
 	fromname := fromtid.GetName()
 	logger.Debug(domain, " ", fromname)
-	authProvider_rosterSql := common.CF.GetKV("tim.mysql.rosterSQL", "")
+	authProvider_rosterSql := common.CF().GetKV("tim.mysql.rosterSQL", "")
 	loginname, _ := connect.GetLoginName(fromtid)
 	if authProvider_rosterSql == "" {
 		tim_roster := dao.NewTim_roster()
@@ -1032,7 +949,7 @@ func updateVersion() {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	if common.CF.Db_Exsit == 0 {
+	if common.CF().Db_Exsit == 0 {
 		return
 	}
 	timDomain := dao.NewTim_config()
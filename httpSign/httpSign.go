@@ -0,0 +1,52 @@
+/**
+ * http请求的api key与HMAC签名
+ * X-Api-Key: api key
+ * X-Timestamp: unix秒
+ * X-Signature: hex(HMAC-SHA256(secret, 方法 + "\n" + uri + "\n" + X-Timestamp + "\n" + 请求体))
+ */
+package httpSign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HEADER_KEY       = "X-Api-Key"
+	HEADER_TIMESTAMP = "X-Timestamp"
+	HEADER_SIGNATURE = "X-Signature"
+)
+
+func Sign(secret, method, uri, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprint(method, "\n", uri, "\n", timestamp, "\n")))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**给请求加签名 body为请求体*/
+func SignRequest(r *http.Request, secret string, body []byte) {
+	timestamp := fmt.Sprint(time.Now().Unix())
+	r.Header.Set(HEADER_TIMESTAMP, timestamp)
+	r.Header.Set(HEADER_SIGNATURE, Sign(secret, r.Method, r.URL.RequestURI(), timestamp, body))
+}
+
+/**验证签名 时间戳与本机时间相差超过skew时失败*/
+func Verify(r *http.Request, secret string, body []byte, skew time.Duration) bool {
+	timestamp := r.Header.Get(HEADER_TIMESTAMP)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || secret == "" {
+		return false
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > skew || d < -skew {
+		return false
+	}
+	expected := Sign(secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(r.Header.Get(HEADER_SIGNATURE))))
+}
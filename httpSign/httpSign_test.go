@@ -0,0 +1,26 @@
+package httpSign
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sign(t *testing.T) {
+	body := []byte(`{"domain":"tim.com"}`)
+	r := httptest.NewRequest("POST", "/api/kick?x=1", strings.NewReader(string(body)))
+	SignRequest(r, "secret", body)
+	if !Verify(r, "secret", body, time.Minute) {
+		t.Fatal("verify fail")
+	}
+	if Verify(r, "other", body, time.Minute) || Verify(r, "secret", []byte("{}"), time.Minute) {
+		t.Fatal("wrong secret or body should fail")
+	}
+	r.Header.Set(HEADER_TIMESTAMP, fmt.Sprint(time.Now().Add(-2*time.Minute).Unix()))
+	r.Header.Set(HEADER_SIGNATURE, Sign("secret", r.Method, r.URL.RequestURI(), r.Header.Get(HEADER_TIMESTAMP), body))
+	if Verify(r, "secret", body, time.Minute) {
+		t.Fatal("expired timestamp should fail")
+	}
+}
@@ -0,0 +1,136 @@
+package impl
+
+import (
+	"errors"
+	"runtime/debug"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/clusterRoute"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/interceptor"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/route"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * 管理接口使用的投递与踢下线
+ * 消息先在本节点保存并经过 POST_STORE 拦截，接收者在其他节点时带 STORED 标记转发，其他节点不再重复保存与拦截
+ */
+
+func preRoute(mbean *protocol.TimMBean) (err error) {
+	if mbean.GetThreadId() == "" {
+		mbean.ThreadId = utils.TimeMills()
+	}
+	timestamp := utils.TimeMills()
+	mbean.Timestamp = &timestamp
+	if action, ctx := interceptor.MBean(interceptor.PRE_ROUTE, mbean); action != interceptor.CONTINUE {
+		err = errors.New("rejected by interceptor")
+		if ctx.Error != nil {
+			err = errors.New(ctx.Error.GetErrMsg())
+		}
+	}
+	return
+}
+
+/**投递已经保存的消息 接收者在其他节点时转发，失败时本节点投递或保存为离线*/
+func routeStored(mbean *protocol.TimMBean) (offline bool, err error) {
+	if cluster.IsCluster() {
+		if beans := clusterRoute.OtherClusterUserBean(mbean.GetToTid()); beans != nil {
+			if err = clusterRoute.ClusterRouteMBean(mbean, beans); err == nil {
+				return
+			}
+		}
+	}
+	_, err, offline, _ = route.RouteMBean(mbean, false, false)
+	return
+}
+
+/**向用户发送消息 offline为true时对方不在线(只对本节点有效)*/
+func SendMBean(mbean *protocol.TimMBean) (mid string, offline bool, err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			logger.Error(string(debug.Stack()))
+			err = errors.New("send message error")
+		}
+	}()
+	mbean.ToTid.Domain = mbean.FromTid.Domain //只能发送到相同domain的用户
+	if err = preRoute(mbean); err != nil {
+		return
+	}
+	if mid, err = route.StoreMBean(mbean); err != nil {
+		return
+	}
+	mbean.Mid = &mid
+	dropped, err := route.PostStore(mbean)
+	if dropped || err != nil {
+		return
+	}
+	offline, err = routeStored(connect.NewStored(mbean, mid))
+	return
+}
+
+/**
+ * 向群发送消息 fromTid为群，leaguerTid为发送者
+ * 消息只保存一次，逐个投递到群成员(不包括发送者)，发送者的在线连接收到抄送
+ */
+func SendRoomMBean(mbean *protocol.TimMBean) (mid string, err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			logger.Error(string(debug.Stack()))
+			err = errors.New("send room message error")
+		}
+	}()
+	room := mbean.GetFromTid()
+	groupchat := "groupchat"
+	mbean.Type = &groupchat
+	if err = preRoute(mbean); err != nil {
+		return
+	}
+	members := daoService.LoadMucmember(room)
+	if len(members) == 0 {
+		return "", errors.New("room has no member")
+	}
+	if mid, err = route.StoreMBean(mbean); err != nil {
+		return
+	}
+	//保存后拦截一次(webhook等)，之后逐个成员投递，不在线的成员保存到 tim_mucoffline
+	mbean.Mid = &mid
+	dropped, err := route.PostStore(mbean)
+	if dropped || err != nil {
+		return
+	}
+	go carbon(newCarbon(mbean), mid, nil)
+	for _, member := range members {
+		if member.GetName() == mbean.GetLeaguerTid().GetName() {
+			continue
+		}
+		m := connect.NewStored(mbean, mid)
+		m.ToTid = member
+		go routeStored(m)
+	}
+	return
+}
+
+/**踢下线 关闭用户在所有节点的连接 返回本节点关闭的连接数*/
+func Kick(tid *protocol.Tid) (n int) {
+	n = connect.TP.Kick(tid)
+	if cluster.IsCluster() {
+		loginname, _ := connect.GetLoginName(tid)
+		beans, err := cluster.GetUserBeans(loginname)
+		if err != nil {
+			logger.Error("kick:", err.Error())
+			return
+		}
+		pbean := protocol.NewTimPBean()
+		pbean.ThreadId = utils.TimeMills()
+		kick := connect.KICK
+		pbean.Type, pbean.ToTid = &kick, tid
+		for _, bean := range beans {
+			bean.SendPBean(pbean)
+		}
+	}
+	return
+}
@@ -8,15 +8,21 @@ import (
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/authGuard"
+	"github.com/zhangjunfang/im/authProvider"
+	"github.com/zhangjunfang/im/chatState"
 	"github.com/zhangjunfang/im/cluster"
 	"github.com/zhangjunfang/im/clusterRoute"
 	. "github.com/zhangjunfang/im/common"
 	. "github.com/zhangjunfang/im/connect"
 	"github.com/zhangjunfang/im/daoService"
 	"github.com/zhangjunfang/im/fw"
+	"github.com/zhangjunfang/im/interceptor"
+	"github.com/zhangjunfang/im/presence"
 	. "github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/pushService"
+	"github.com/zhangjunfang/im/rateLimit"
 	"github.com/zhangjunfang/im/route"
-	"github.com/zhangjunfang/im/tfClient"
 	"github.com/zhangjunfang/im/utils"
 )
 
@@ -65,22 +71,36 @@ func (this *TimImpl) TimLogin(tid *Tid, pwd string) (err error) {
 		this.Tu.SendAckBean(ack)
 		return
 	}
-	if CF.MustAuth == 0 {
-		isAuth = true
+	if CF().MustAuth == 0 {
+		//不核对密码，但被禁用的用户不能登陆
+		isAuth = authProvider.Auth(tid, pwd, "login")
 	} else {
-		user_auth_url := CF.GetKV("user_auth_url", "")
-		if len(user_auth_url) > 9 {
-			isAuth = httpAuth(tid, pwd, user_auth_url)
+		if locked, retryAfter := authGuard.Check(tid, this.Ip); locked {
+			ack := NewTimAckBean()
+			status400, typelogin := "400", "login"
+			ack.AckStatus, ack.AckType = &status400, &typelogin
+			ack.Err = timError(4029, "login locked")
+			ack.ExtraMap = map[string]string{"retryAfter": fmt.Sprint(retryAfter)}
+			this.Tu.SendAckBean(ack)
+			panic(fmt.Sprint("login locked:", tid.GetName(), " ip:", this.Ip))
+		}
+		isAuth = authProvider.Auth(tid, pwd, "login")
+		if isAuth {
+			authGuard.Success(tid)
 		} else {
-			b := daoService.Auth(tid, pwd)
-			if b {
-				isAuth = true
-				logger.Debug("login is success:", tid.GetName())
-			}
+			authGuard.Fail(tid, this.Ip)
 		}
 	}
 
 	if isAuth {
+		if action, ctx := interceptor.Login(tid); action != interceptor.CONTINUE {
+			ack := NewTimAckBean()
+			status400, typelogin := "400", "login"
+			ack.AckStatus, ack.AckType = &status400, &typelogin
+			ack.Err = ctx.Error
+			this.Tu.SendAckBean(ack)
+			panic(fmt.Sprint("login intercepted:", tid.GetName()))
+		}
 		ack := NewTimAckBean()
 		this.Tu.UserTid = tid
 		this.Tu.Fw = fw.AUTH
@@ -88,18 +108,31 @@ func (this *TimImpl) TimLogin(tid *Tid, pwd string) (err error) {
 		if cluster.IsCluster() {
 			loginname, _ := GetLoginName(tid)
 			cluster.SetLoginnameToCluster(loginname)
+			if resource := tid.GetResource(); resource != "" {
+				cluster.SetResourceToCluster(loginname, resource)
+			}
 		}
 		status200, typelogin := "200", "login"
 		ack.AckStatus, ack.AckType = &status200, &typelogin
 		this.Tu.SendAckBean(ack)
-		_TimPresence(this, OnlinePBean(this.Tu.UserTid), false)
+		go interceptor.PostLogin(tid)
+		if pbean := OnlinePBean(this.Tu.UserTid); pbean != nil {
+			_TimPresence(this, pbean, false)
+		}
+		if CF().Presence == 1 && CF().GetKV("presence.snapshot", "1") == "1" {
+			go func(tu *TimUser) {
+				if pbeans := presence.Snapshot(tu.UserTid); len(pbeans) > 0 {
+					tu.SendPBeanList(pbeans)
+				}
+			}(this.Tu)
+		}
 		go route.RouteOffLineMBean(this.Tu)
 	} else {
 		ack := NewTimAckBean()
 		status400, typeType := "400", "login"
 		ack.AckStatus, ack.AckType = &status400, &typeType
 		this.Tu.SendAckBean(ack)
-		panic(fmt.Sprint("loginname or pwd is error:", tid.GetName(), " | ", pwd))
+		panic(fmt.Sprint("loginname or pwd is error:", tid.GetName()))
 	}
 	return
 }
@@ -111,12 +144,15 @@ func (this *TimImpl) TimAck(ab *TimAckBean) (err error) {
 		panic(fmt.Sprint("not auth:", this.Tu.Fw))
 	}
 	this.Tu.OverLimit = 3
+	if ab != nil && this.Tu.Ack(ab.GetID()) {
+		return
+	}
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
 			}
 		}()
-		if CF.ConfirmAck == 1 && ab != nil && ab.GetID() == this.Tu.LastSyncThreadId {
+		if CF().ConfirmAck == 1 && ab != nil && ab.GetID() == this.Tu.LastSyncThreadId {
 			timer := time.NewTicker(5 * time.Second)
 			select {
 			case <-timer.C:
@@ -131,24 +167,89 @@ func (this *TimImpl) TimAck(ab *TimAckBean) (err error) {
 // Parameters:
 //  - Pbean
 func (this *TimImpl) TimPresence(pbean *TimPBean) (err error) {
-	if CF.Presence != 1 {
+	//输入状态不受presence开关影响
+	if CF().Presence != 1 && !chatState.IsChatState(pbean.GetType()) {
 		return
 	}
 	if this.Tu.Fw != fw.AUTH {
 		panic("not auth")
 	}
 	//	logger.Debug("pbean", pbean)
+	if this.rateLimited(rateLimit.PRESENCE, pbean.GetThreadId()) {
+		return
+	}
+	if this.Tu.UserType == 1 && chatState.IsChatState(pbean.GetType()) {
+		//其他节点转发的输入状态 只投递到本节点的连接
+		route.RouteSinglePBean(pbean)
+		return
+	}
 	if this.Tu.UserType == 0 {
 		pbean.FromTid = this.Tu.UserTid
+		if pbean.Priority != nil {
+			this.Tu.Priority = pbean.GetPriority()
+		}
+		active(this.Tu)
 		_type := pbean.GetType()
 		switch _type {
 		case "groupchat":
 			pbean.LeaguerTid = this.Tu.UserTid
+		case chatState.CHAT, chatState.GROUPCHAT:
+			go routeChatState(this.Tu, pbean)
+			ack := NewTimAckBean()
+			id := pbean.GetThreadId()
+			ack.ID = &id
+			status200, typemessage := "200", "presence"
+			ack.AckStatus, ack.AckType = &status200, &typemessage
+			this.Tu.SendAckBean(ack)
+			return
+		case "subscribed":
+			//新订阅的联系人收到当前状态
+			if to := pbean.GetToTid(); to != nil {
+				err = _TimPresence(this, pbean, true)
+				go routePBean(presence.Probe(this.Tu.UserTid, to))
+				return
+			}
+		default:
+			if pbean.GetToTid() == nil {
+				broadcast := true
+				if presence.IsShow(pbean.GetShow()) {
+					broadcast = this.saveState(pbean)
+				} else if state := presence.GetState(this.Tu.UserTid); state != nil && state.Show == presence.INVISIBLE {
+					//隐身期间不广播任何状态
+					broadcast = false
+				}
+				if !broadcast {
+					ack := NewTimAckBean()
+					id := pbean.GetThreadId()
+					ack.ID = &id
+					status200, typemessage := "200", "presence"
+					ack.AckStatus, ack.AckType = &status200, &typemessage
+					this.Tu.SendAckBean(ack)
+					return
+				}
+			}
 		}
 	}
 	return _TimPresence(this, pbean, true)
 }
 
+/**
+ * 保存客户端设置的状态
+ * 设置隐身时向联系人发送离线状态，已经隐身时不再广播 返回false
+ */
+func (this *TimImpl) saveState(pbean *TimPBean) bool {
+	prev := presence.GetState(this.Tu.UserTid)
+	presence.SetState(this.Tu.UserTid, &presence.State{Show: pbean.GetShow(), Status: pbean.GetStatus()})
+	if pbean.GetShow() == presence.INVISIBLE {
+		if prev != nil && prev.Show == presence.INVISIBLE {
+			return false
+		}
+		show, status := presence.OFFLINE, "unavailable"
+		pbean.Show, pbean.Status = &show, &status
+	}
+	return true
+}
+
 func _TimPresence(this *TimImpl, pbean *TimPBean, isAck bool) (err error) {
 	defer func() {
 		if er := recover(); er != nil {
@@ -156,12 +257,27 @@ func _TimPresence(this *TimImpl, pbean *TimPBean, isAck bool) (err error) {
 			err = errors.New(fmt.Sprint(er))
 		}
 	}()
-	if CF.Presence != 1 {
+	if CF().Presence != 1 {
 		return
 	}
 	if pbean.GetThreadId() == "" {
 		pbean.ThreadId = utils.TimeMills()
 	}
+	if action, ctx := interceptor.PBean(pbean); action != interceptor.CONTINUE {
+		if isAck {
+			ack := NewTimAckBean()
+			id := pbean.ThreadId
+			ack.ID = &id
+			status, typemessage := TIM_SC_SUCCESS, "presence"
+			if action == interceptor.REJECT {
+				status = TIM_SC_FAILED
+				ack.Err = ctx.Error
+			}
+			ack.AckStatus, ack.AckType = &status, &typemessage
+			this.Tu.SendAckBean(ack)
+		}
+		return
+	}
 	//isTotidExist := daoService.IsTidExist(pbean.GetToTid())
 	_type := pbean.GetType()
 	switch _type {
@@ -205,8 +321,14 @@ func (this *TimImpl) TimMessage(mbean *TimMBean) (err error) {
 		panic("not auth")
 	}
 	//	logger.Debug("TimMessage=====>", mbean)
+	if this.rateLimited(rateLimit.MESSAGE, mbean.GetThreadId()) {
+		return
+	}
 	if this.Tu.UserType == 0 {
 		mbean.FromTid = this.Tu.UserTid
+		delete(mbean.ExtraMap, CARBON)
+		delete(mbean.ExtraMap, STORED)
+		active(this.Tu)
 		//		isTotidExist := daoService.IsTidExist(mbean.GetToTid())
 		_type := mbean.GetType()
 		switch _type {
@@ -237,6 +359,14 @@ func _TimMessage(this *TimImpl, mbean *TimMBean) (err error) {
 	_type := mbean.GetType()
 	switch _type {
 	case "groupchat":
+		if this.Tu.UserType == 1 {
+			//其他节点转发的群消息已经转换过；抄送的toTid为空，只投递到发送者在本节点的连接
+			if IsCarbon(mbean) {
+				route.RouteCarbon(mbean, nil)
+				return
+			}
+			break
+		}
 		mbean.FromTid = mbean.ToTid
 		mbean.LeaguerTid = this.Tu.UserTid
 		mbean.FromTid.Domain = this.Tu.UserTid.Domain
@@ -248,6 +378,23 @@ func _TimMessage(this *TimImpl, mbean *TimMBean) (err error) {
 		timestamp := utils.TimeMills()
 		mbean.Timestamp = &timestamp
 	}
+	if action, ctx := interceptor.MBean(interceptor.PRE_ROUTE, mbean); action != interceptor.CONTINUE {
+		ack := NewTimAckBean()
+		thid := mbean.ThreadId
+		ack.ID = &thid
+		status, typemessage := TIM_SC_SUCCESS, "message"
+		if action == interceptor.REJECT {
+			status = TIM_SC_FAILED
+			ack.Err = ctx.Error
+		}
+		ack.AckStatus, ack.AckType = &status, &typemessage
+		this.Tu.SendAckBean(ack)
+		return
+	}
+	var c *TimMBean
+	if this.Tu.UserType == 0 && isTotidExist {
+		c = newCarbon(mbean)
+	}
 	if isTotidExist && mbean.GetToTid() != nil {
 		mustRoute := true
 		if cluster.IsCluster() {
@@ -258,6 +405,7 @@ func _TimMessage(this *TimImpl, mbean *TimMBean) (err error) {
 					mustRoute = true
 				} else {
 					mustRoute = false
+					go carbon(c, "", this.Tu)
 				}
 			} else {
 				mustRoute = true
@@ -265,7 +413,10 @@ func _TimMessage(this *TimImpl, mbean *TimMBean) (err error) {
 		}
 
 		if mustRoute {
-			id, er, _ := route.RouteMBean(mbean, false, true)
+			id, er, _, dropped := route.RouteMBean(mbean, false, true)
+			if er == nil && !dropped {
+				go carbon(c, id, this.Tu)
+			}
 			ack := NewTimAckBean()
 			thid := mbean.ThreadId
 			ack.ID = &thid
@@ -337,10 +488,74 @@ func (this *TimImpl) TimRemoteUserGet(tid *Tid, auth *TimAuth) (r *TimRemoteUser
 //  - Tid
 //  - Ub
 func (this *TimImpl) TimRemoteUserEdit(tid *Tid, ub *TimUserBean, auth *TimAuth) (r *TimRemoteUserBean, err error) {
-	panic("error TimRemoteUserEdit")
+	defer func() {
+		if er := recover(); er != nil {
+			logger.Error(string(debug.Stack()))
+			err = errors.New(fmt.Sprint(er))
+		}
+	}()
+	r = NewTimRemoteUserBean()
+	//目前只支持修改密码 ub.ExtraMap["password"]为新密码，auth.Pwd为旧密码
+	if tid == nil || auth == nil || ub == nil || ub.ExtraMap == nil || ub.ExtraMap["password"] == "" {
+		r.Error = timError(4001, "parameter error")
+		return
+	}
+	if tid.GetName() != auth.GetUsername() || tid.GetDomain() != auth.GetDomain() {
+		r.Error = timError(4003, "permission denied")
+		return
+	}
+	//长连接上只能修改已登陆用户自己的密码
+	if this.Tu != nil && (this.Tu.Fw != fw.AUTH || this.Tu.UserTid == nil ||
+		tid.GetName() != this.Tu.UserTid.GetName() || tid.GetDomain() != this.Tu.UserTid.GetDomain()) {
+		r.Error = timError(4003, "permission denied")
+		return
+	}
+	//旧密码与登陆一样计入防暴力破解
+	if locked, retryAfter := authGuard.Check(tid, this.Ip); locked {
+		r.Error = timError(4029, "password change locked")
+		r.ExtraMap = map[string]string{"retryAfter": fmt.Sprint(retryAfter)}
+		return
+	}
+	if er := authProvider.ChangePassword(tid, auth.GetPwd(), ub.ExtraMap["password"]); er != nil {
+		logger.Warn("TimRemoteUserEdit:", tid.GetName(), " ", er.Error())
+		if er == authProvider.ErrOldPassword {
+			authGuard.Fail(tid, this.Ip)
+		}
+		r.Error = timError(4002, er.Error())
+		return
+	}
+	authGuard.Success(tid)
+	return
+}
+
+func timError(code int32, msg string) (e *TimError) {
+	e = NewTimError()
+	e.ErrCode, e.ErrMsg = &code, &msg
 	return
 }
 
+/**
+ * 频率限制 先检查连接级，再检查用户级
+ * 超出限制时发送 errCode 4290 的ack，不断开连接
+ */
+func (this *TimImpl) rateLimited(kind, threadId string) bool {
+	ok, wait := rateLimit.AllowConn(this.Tu.Bucket, this.Tu.UserTid.GetDomain(), this.Tu.UserType)
+	if ok {
+		ok, wait = rateLimit.Allow(kind, this.Tu.UserTid, this.Tu.UserType)
+	}
+	if ok {
+		return false
+	}
+	logger.Warn("rate limited:", kind, " ", this.Tu.UserTid.GetName())
+	ack := NewTimAckBean()
+	status, acktype := TIM_SC_FAILED, kind
+	ack.ID, ack.AckStatus, ack.AckType = &threadId, &status, &acktype
+	ack.Err = timError(4290, "rate limited")
+	ack.ExtraMap = map[string]string{"retryAfter": fmt.Sprint(int64(wait / time.Millisecond))}
+	this.Tu.SendAckBean(ack)
+	return true
+}
+
 // Parameters:
 //  - Pbean
 func (this *TimImpl) TimResponsePresence(pbean *TimPBean, auth *TimAuth) (r *TimResponseBean, err error) {
@@ -352,6 +567,13 @@ func (this *TimImpl) TimResponsePresence(pbean *TimPBean, auth *TimAuth) (r *Tim
 //  - Mbean
 func (this *TimImpl) TimResponseMessage(mbean *TimMBean, auth *TimAuth) (r *TimResponseBean, err error) {
 	r = NewTimResponseBean()
+	if ok, wait := rateLimit.Allow(rateLimit.MESSAGE, mbean.GetFromTid(), 0); !ok {
+		threadId := mbean.GetThreadId()
+		r.ThreadId = &threadId
+		r.Error = timError(4290, "rate limited")
+		r.ExtraMap = map[string]string{"retryAfter": fmt.Sprint(int64(wait / time.Millisecond))}
+		return
+	}
 	fromDomain := mbean.GetFromTid().GetDomain()
 	toDomain := mbean.GetToTid().GetDomain()
 	if fromDomain == toDomain {
@@ -367,6 +589,10 @@ func (this *TimImpl) TimResponseMessage(mbean *TimMBean, auth *TimAuth) (r *TimR
 	mbean.ToTid.Domain = mbean.FromTid.Domain //只能发送到相同domain的用户
 	timestamp := utils.TimeMills()
 	mbean.Timestamp = &timestamp
+	if action, ctx := interceptor.MBean(interceptor.PRE_ROUTE, mbean); action != interceptor.CONTINUE {
+		r.Error = ctx.Error
+		return
+	}
 	if isTotidExist {
 		isSinglePush := false
 		if mbean.ExtraMap != nil {
@@ -378,8 +604,14 @@ func (this *TimImpl) TimResponseMessage(mbean *TimMBean, auth *TimAuth) (r *TimR
 			}
 		}
 
-		id, er, offline := route.RouteMBean(mbean, isSinglePush, false)
+		delete(mbean.ExtraMap, CARBON)
+		delete(mbean.ExtraMap, STORED)
+		c := newCarbon(mbean)
+		id, er, offline, dropped := route.RouteMBean(mbean, isSinglePush, false)
 		if er == nil {
+			if !dropped {
+				go carbon(c, id, nil)
+			}
 			r.ExtraMap = make(map[string]string, 0)
 			r.ExtraMap["mid"] = fmt.Sprint(id)
 			r.ExtraMap["timestamp"] = timestamp
@@ -438,6 +670,22 @@ func (this *TimImpl) TimMessageIq(timMsgIq *TimMessageIq, iqType string) (err er
 		if len(tidnames) == 1 {
 			daoService.DelAllMBean(fidname, tidnames[0], *domain)
 		}
+	case "push":
+		//推送设备注册 extraMap: action(register/unregister) provider token mute quietStart quietEnd
+		if this.Tu.Fw != fw.AUTH {
+			panic("not auth")
+		}
+		extra := timMsgIq.GetExtraMap()
+		if extra["action"] == "unregister" {
+			pushService.UnregisterDevice(this.Tu.UserTid)
+		} else if er := pushService.RegisterDevice(this.Tu.UserTid, extra["provider"], extra["token"], utils.Atoi(extra["mute"]), extra["quietStart"], extra["quietEnd"]); er != nil {
+			logger.Error("register device:", this.Tu.UserTid.GetName(), " ", er.Error())
+		}
+	case "presence":
+		//查询花名册中用户的状态 Tidlist为用户名
+		if CF().Presence == 1 && len(timMsgIq.Tidlist) > 0 {
+			this.Tu.SendPBeanList(presence.Query(this.Tu.UserTid, timMsgIq.Tidlist))
+		}
 	default:
 		panic("error iqType")
 	}
@@ -460,22 +708,18 @@ func (this *TimImpl) TimRoser(roster *TimRoster) (err error) {
 
 func (this *TimImpl) TimResponseMessageIq(timMsgIq *TimMessageIq, iqType string, auth *TimAuth) (r *TimMBeanList, err error) {
 	//	logger.Debug("TimResponseMessageIq:", timMsgIq, iqType, auth)
-	user_auth_url := CF.GetKV("user_auth_url", "")
-	isAuth := false
 	tid := NewTid()
 	tid.Domain, tid.Name = auth.Domain, auth.GetUsername()
-	pwd := auth.GetPwd()
-	if user_auth_url != "" {
-		isAuth = httpAuth(tid, pwd, user_auth_url)
-	} else {
-		b := daoService.Auth(tid, pwd)
-		if b {
-			isAuth = true
-		}
+	if locked, _ := authGuard.Check(tid, this.Ip); locked {
+		return
 	}
+	//拉取离线信息后会删除，总是核对密码(与 MustAuth 无关)
+	isAuth := authProvider.Verify(tid, auth.GetPwd(), "messageIq")
 	if !isAuth {
+		authGuard.Fail(tid, this.Ip)
 		return
 	}
+	authGuard.Success(tid)
 	switch iqType {
 	case "offline":
 		r = NewTimMBeanList()
@@ -493,36 +737,6 @@ func (this *TimImpl) TimResponseMessageIq(timMsgIq *TimMessageIq, iqType string,
 	return
 }
 
-func httpAuth(tid *Tid, pwd, user_auth_url string) (isAuth bool) {
-	var r *TimRemoteUserBean
-	tfClient.HttpClient(func(client *ImClient) (er error) {
-		defer func() {
-			if err := recover(); err != nil {
-				er = errors.New(fmt.Sprint(err))
-				logger.Error(string(debug.Stack()))
-			}
-		}()
-		r, er = client.TimRemoteUserAuth(tid, pwd, nil)
-		if er == nil && r != nil {
-			logger.Debug(r)
-			if r.ExtraMap != nil {
-				if password, ok := r.ExtraMap["password"]; ok {
-					if pwd == password {
-						isAuth = true
-					}
-				}
-				if extraAuth, ok := r.ExtraMap["extraAuth"]; ok {
-					if pwd == extraAuth {
-						isAuth = true
-					}
-				}
-			}
-		}
-		return er
-	}, user_auth_url)
-	return
-}
-
 func (this *TimImpl) TimMessageList(mbeanList *TimMBeanList) (err error) {
 	logger.Debug("TimMessageList:", mbeanList)
 	panic("error TimMessageList")
@@ -4,7 +4,19 @@
 package impl
 
 import (
+	"runtime/debug"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/chatState"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/clusterRoute"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/presence"
 	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/route"
 	"github.com/zhangjunfang/im/utils"
 )
 
@@ -16,11 +28,18 @@ func newTid(name string, domain, resource *string) *protocol.Tid {
 	return tid
 }
 
+/**上线状态 使用用户已经设置的状态，隐身时为nil*/
 func OnlinePBean(tid *protocol.Tid) (pbean *protocol.TimPBean) {
+	show, status, visible := presence.Visible(tid)
+	if !visible {
+		return nil
+	}
+	if status == "" {
+		status = "probe"
+	}
 	pbean = protocol.NewTimPBean()
 	pbean.ThreadId = utils.TimeMills()
 	pbean.FromTid = tid
-	show, status := "online", "probe"
 	pbean.Show, pbean.Status = &show, &status
 	return
 }
@@ -33,3 +52,135 @@ func OfflinePBean(tid *protocol.Tid) (pbean *protocol.TimPBean) {
 	pbean.Show, pbean.Status = &show, &status
 	return
 }
+
+/**
+ * 抄送已发送的消息到发送者的其他在线设备(包括其他节点)
+ * tim_property: carbon.enable 1开启，缺省0
+ * 一对一的消息在路由前复制，路由过程中对原消息的修改不影响副本
+ * 群消息抄送到 leaguerTid(发送者)，群成员投递时跳过发送者，不会重复
+ */
+func newCarbon(mbean *protocol.TimMBean) *protocol.TimMBean {
+	if common.CF().GetKV("carbon.enable", "0") != "1" || (mbean.GetToTid() == nil && mbean.GetType() != "groupchat") {
+		return nil
+	}
+	return connect.NewCarbon(mbean)
+}
+
+/**路由成功后发送副本 mid为空时(转发到其他节点)不设置*/
+func carbon(c *protocol.TimMBean, mid string, from *connect.TimUser) {
+	if c == nil {
+		return
+	}
+	if mid != "" {
+		c.Mid = &mid
+	}
+	route.RouteCarbon(c, from)
+	if cluster.IsCluster() {
+		clusterRoute.ClusterRouteCarbon(c)
+	}
+}
+
+/**发送presence 集群时先经过集群路由*/
+func routePBean(pbean *protocol.TimPBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if cluster.IsCluster() && clusterRoute.ClusterRoutePBean(pbean) == nil {
+		return
+	}
+	if pbean.GetToTid() == nil {
+		route.RoutePBean(pbean)
+	} else {
+		route.RouteSinglePBean(pbean)
+	}
+}
+
+/**
+ * 输入状态 只投递到在线连接，不保存，不做离线
+ * 单聊 toTid为对方  群聊 toTid为群，投递到其他群成员，fromTid为群 leaguerTid为发送者
+ */
+func routeChatState(tu *connect.TimUser, pbean *protocol.TimPBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	to, state := pbean.GetToTid(), pbean.GetStatus()
+	if to == nil || !chatState.Valid(state) {
+		return
+	}
+	to.Domain = tu.UserTid.Domain //只能发送到相同domain
+	if pbean.GetType() == chatState.GROUPCHAT {
+		if !daoService.AuthMucmember(to, tu.UserTid) {
+			return
+		}
+		members := daoService.LoadMucmember(to)
+		if !chatState.RoomAllow(len(members)) || !chatState.Allow(tu.UserTid, to, state, true) {
+			return
+		}
+		for _, member := range members {
+			if member.GetName() == tu.UserTid.GetName() {
+				continue
+			}
+			p := *pbean
+			p.FromTid, p.LeaguerTid, p.ToTid = to, tu.UserTid, member
+			routePBean(&p)
+		}
+	} else if chatState.Allow(tu.UserTid, to, state, false) {
+		pbean.FromTid = tu.UserTid
+		routePBean(pbean)
+	}
+}
+
+/**用户活动 自动away的用户恢复为online*/
+func active(tu *connect.TimUser) {
+	tu.Touch()
+	if tu.SetAutoAway(false) {
+		for _, t := range connect.TP.GetLoginUser(tidLoginname(tu.UserTid)) {
+			t.SetAutoAway(false)
+		}
+		if state := presence.GetState(tu.UserTid); state != nil && state.Auto {
+			state = &presence.State{Show: presence.ONLINE}
+			presence.SetState(tu.UserTid, state)
+			go routePBean(presence.StatePBean(tu.UserTid, state))
+		}
+	}
+}
+
+/**
+ * 用户在本节点的所有连接空闲超过 presence.away.idle 秒时自动设置为away
+ * 只处理online状态，由心跳循环调用
+ */
+func IdleAway(tu *connect.TimUser) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	idle := int64(utils.Atoi(common.CF().GetKV("presence.away.idle", "0")))
+	if idle <= 0 || tu.UserTid == nil || tu.UserType != 0 || tu.IsAutoAway() || common.CF().Presence != 1 {
+		return
+	}
+	now := time.Now().Unix()
+	tus := connect.TP.GetLoginUser(tidLoginname(tu.UserTid))
+	for _, t := range tus {
+		if now-t.Active() < idle {
+			return
+		}
+	}
+	for _, t := range tus {
+		t.SetAutoAway(true)
+	}
+	if state := presence.GetState(tu.UserTid); state == nil || state.Show == presence.ONLINE {
+		state = &presence.State{Show: presence.AWAY, Status: "idle", Auto: true}
+		presence.SetState(tu.UserTid, state)
+		routePBean(presence.StatePBean(tu.UserTid, state))
+	}
+}
+
+func tidLoginname(tid *protocol.Tid) string {
+	name, _ := connect.GetLoginName(tid)
+	return name
+}
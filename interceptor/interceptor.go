@@ -0,0 +1,206 @@
+/**
+ * 消息拦截器
+ * 在路由的各个阶段依次调用已注册的拦截器，拦截器可以修改、拒绝或丢弃 TimMBean/TimPBean
+ * 阶段:
+ *    preRoute    消息路由前(存储前)
+ *    postStore   消息存储后，投递前
+ *    preDeliver  投递到每个连接前
+ *    onPresence  presence路由前
+ *    onLogin     登陆验证通过后
+ *    postLogin   onLogin 所有拦截器通过、登陆成功后，只做通知，返回值无效
+ *    onLogout    连接断开后(已登陆用户)，只做通知，返回值无效
+ *    onOffline   消息保存为离线消息后，只做通知，返回值无效
+ * tim_property:
+ *    interceptor.stage          指定阶段的拦截器顺序，逗号分隔，如 interceptor.preRoute=filter,audit
+ *    interceptor.stage.domain   指定domain，优先于 interceptor.stage
+ *    interceptor.failOpen       拦截器panic时 1继续下一个 0拒绝(errCode 5000)，缺省0
+ *    未配置时按注册顺序调用该阶段所有拦截器
+ */
+package interceptor
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/protocol"
+)
+
+type Stage string
+
+const (
+	PRE_ROUTE   Stage = "preRoute"
+	POST_STORE  Stage = "postStore"
+	PRE_DELIVER Stage = "preDeliver"
+	ON_PRESENCE Stage = "onPresence"
+	ON_LOGIN    Stage = "onLogin"
+	POST_LOGIN  Stage = "postLogin"
+	ON_LOGOUT   Stage = "onLogout"
+	ON_OFFLINE  Stage = "onOffline"
+)
+
+type Action int
+
+const (
+	/**继续*/
+	CONTINUE Action = 0
+	/**丢弃，不返回错误*/
+	DROP Action = 1
+	/**拒绝，返回 Context.Error*/
+	REJECT Action = 2
+)
+
+/**拦截器上下文 拦截器可以直接修改 Mbean Pbean*/
+type Context struct {
+	Stage Stage
+	//发送者或登陆用户
+	Tid   *protocol.Tid
+	Mbean *protocol.TimMBean
+	Pbean *protocol.TimPBean
+	//已存储的消息id postStore preDeliver 有效
+	Mid string
+	//投递的目标连接用户 preDeliver 有效
+	ToTid *protocol.Tid
+	//REJECT时返回给客户端的错误
+	Error *protocol.TimError
+}
+
+/**设置错误并返回REJECT*/
+func (this *Context) Reject(code int32, msg string) Action {
+	this.Error = protocol.NewTimError()
+	this.Error.ErrCode, this.Error.ErrMsg = &code, &msg
+	return REJECT
+}
+
+func (this *Context) Domain() string {
+	if this.Tid != nil {
+		return this.Tid.GetDomain()
+	}
+	return ""
+}
+
+type Interceptor interface {
+	//拦截器名称，对应 interceptor.stage 中的配置
+	Name() string
+	Intercept(ctx *Context) Action
+}
+
+var interceptors = make(map[Stage][]Interceptor, 0)
+var lock = new(sync.RWMutex)
+
+/**注册拦截器到指定阶段，同一阶段同名覆盖*/
+func Register(i Interceptor, stages ...Stage) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, stage := range stages {
+		list := interceptors[stage]
+		replaced := false
+		for k, v := range list {
+			if v.Name() == i.Name() {
+				list[k], replaced = i, true
+			}
+		}
+		if !replaced {
+			list = append(list, i)
+		}
+		interceptors[stage] = list
+	}
+}
+
+func chain(stage Stage, domain string) (list []Interceptor) {
+	lock.RLock()
+	registered := interceptors[stage]
+	lock.RUnlock()
+	if len(registered) == 0 {
+		return
+	}
+	names := common.CF().GetKV(fmt.Sprint("interceptor.", stage, ".", domain), "")
+	if names == "" {
+		names = common.CF().GetKV(fmt.Sprint("interceptor.", stage), "")
+	}
+	if names == "" {
+		return registered
+	}
+	list = make([]Interceptor, 0)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		for _, i := range registered {
+			if i.Name() == name {
+				list = append(list, i)
+			}
+		}
+	}
+	return
+}
+
+/**
+ * 依次调用拦截器，非CONTINUE时停止
+ * 拦截器panic时记录日志，缺省拒绝，interceptor.failOpen=1 时继续下一个
+ */
+func Do(ctx *Context) (action Action) {
+	for _, i := range chain(ctx.Stage, ctx.Domain()) {
+		if action = _do(i, ctx); action != CONTINUE {
+			logger.Debug("interceptor ", i.Name(), " ", ctx.Stage, " action:", action)
+			if action == REJECT && ctx.Error == nil {
+				ctx.Reject(4000, fmt.Sprint("rejected by ", i.Name()))
+			}
+			return
+		}
+	}
+	return
+}
+
+func _do(i Interceptor, ctx *Context) (action Action) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("interceptor ", i.Name(), " error:", err)
+			logger.Error(string(debug.Stack()))
+			if common.CF().GetKV("interceptor.failOpen", "0") == "1" {
+				action = CONTINUE
+			} else {
+				action = ctx.Reject(5000, fmt.Sprint("interceptor ", i.Name(), " error"))
+			}
+		}
+	}()
+	return i.Intercept(ctx)
+}
+
+func MBean(stage Stage, mbean *protocol.TimMBean) (action Action, ctx *Context) {
+	ctx = &Context{Stage: stage, Tid: mbean.GetFromTid(), Mbean: mbean, Mid: mbean.GetMid()}
+	action = Do(ctx)
+	return
+}
+
+func PBean(pbean *protocol.TimPBean) (action Action, ctx *Context) {
+	ctx = &Context{Stage: ON_PRESENCE, Tid: pbean.GetFromTid(), Pbean: pbean}
+	action = Do(ctx)
+	return
+}
+
+func Login(tid *protocol.Tid) (action Action, ctx *Context) {
+	ctx = &Context{Stage: ON_LOGIN, Tid: tid}
+	action = Do(ctx)
+	return
+}
+
+/**登陆成功 只做通知*/
+func PostLogin(tid *protocol.Tid) {
+	Do(&Context{Stage: POST_LOGIN, Tid: tid})
+}
+
+func Logout(tid *protocol.Tid) {
+	Do(&Context{Stage: ON_LOGOUT, Tid: tid})
+}
+
+/**离线消息 Tid为接收者*/
+func Offline(mbean *protocol.TimMBean) {
+	Do(&Context{Stage: ON_OFFLINE, Tid: mbean.GetToTid(), Mbean: mbean, Mid: mbean.GetMid()})
+}
+
+/**投递前 toTid为目标连接的用户*/
+func Deliver(mbean *protocol.TimMBean, toTid *protocol.Tid) (action Action) {
+	return Do(&Context{Stage: PRE_DELIVER, Tid: mbean.GetFromTid(), Mbean: mbean, Mid: mbean.GetMid(), ToTid: toTid})
+}
@@ -0,0 +1,27 @@
+package interceptor
+
+import (
+	"testing"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/protocol"
+)
+
+type panicInterceptor struct{}
+
+func (this *panicInterceptor) Name() string { return "panic" }
+
+func (this *panicInterceptor) Intercept(ctx *Context) Action { panic("test") }
+
+func Test_panic(t *testing.T) {
+	defer common.SetCF(common.CF())
+	Register(new(panicInterceptor), PRE_ROUTE)
+	mbean := protocol.NewTimMBean()
+	if action, ctx := MBean(PRE_ROUTE, mbean); action != REJECT || ctx.Error.GetErrCode() != 5000 {
+		t.Fatal("must reject:", action)
+	}
+	common.SetKV(map[string]string{"interceptor.failOpen": "1"})
+	if action, _ := MBean(PRE_ROUTE, mbean); action != CONTINUE {
+		t.Fatal("must continue:", action)
+	}
+}
@@ -10,6 +10,28 @@ CREATE TABLE `tim_config` (
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB AUTO_INCREMENT=4 DEFAULT CHARSET=utf8 COMMENT='系统配置表';
 
+/*Table structure for table `tim_device` */
+
+DROP TABLE IF EXISTS `tim_device`;
+
+CREATE TABLE `tim_device` (
+  `id` int(10) NOT NULL AUTO_INCREMENT,
+  `loginname` varchar(64) NOT NULL COMMENT '用户标识',
+  `domain` varchar(64) NOT NULL DEFAULT '' COMMENT '域名',
+  `username` varchar(64) NOT NULL COMMENT '用户名',
+  `resource` varchar(64) NOT NULL DEFAULT '' COMMENT '设备resource',
+  `provider` varchar(16) NOT NULL COMMENT '推送通道 apns fcm',
+  `token` varchar(255) NOT NULL COMMENT '设备token',
+  `mute` int(1) NOT NULL DEFAULT '0' COMMENT '1免打扰',
+  `quietstart` varchar(5) NOT NULL DEFAULT '' COMMENT '静默开始时间 HH:MM',
+  `quietend` varchar(5) NOT NULL DEFAULT '' COMMENT '静默结束时间 HH:MM',
+  `createtime` datetime NOT NULL DEFAULT '1900-01-01 00:00:00' COMMENT '创建时间',
+  `updatetime` datetime NOT NULL DEFAULT '1900-01-01 00:00:00' COMMENT '更新时间',
+  PRIMARY KEY (`id`),
+  KEY `td_loginname` (`loginname`),
+  KEY `td_token` (`token`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='推送设备表';
+
 /*Table structure for table `tim_domain` */
 
 DROP TABLE IF EXISTS `tim_domain`;
@@ -22,6 +44,25 @@ CREATE TABLE `tim_domain` (
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB AUTO_INCREMENT=4 DEFAULT CHARSET=utf8 COMMENT='域名表';
 
+/*Table structure for table `tim_filterlog` */
+
+DROP TABLE IF EXISTS `tim_filterlog`;
+
+CREATE TABLE `tim_filterlog` (
+  `id` int(10) NOT NULL AUTO_INCREMENT,
+  `domain` varchar(64) NOT NULL DEFAULT '' COMMENT '域名',
+  `fromuser` varchar(64) NOT NULL COMMENT '发信者Id',
+  `touser` varchar(64) NOT NULL DEFAULT '' COMMENT '接收者Id或群Id',
+  `msgmode` int(2) NOT NULL DEFAULT '1' COMMENT '类型 1chat 2group',
+  `words` varchar(255) NOT NULL DEFAULT '' COMMENT '匹配的敏感词',
+  `action` varchar(16) NOT NULL DEFAULT '' COMMENT '处理方式 mask reject flag',
+  `body` varchar(2000) CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '' COMMENT '原始信息内容',
+  `createtime` datetime NOT NULL DEFAULT '1900-01-01 00:00:00' COMMENT '创建时间',
+  PRIMARY KEY (`id`),
+  KEY `tf_fromuser` (`fromuser`),
+  KEY `tf_createtime` (`createtime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='敏感词过滤记录表';
+
 /*Table structure for table `tim_message` */
 
 DROP TABLE IF EXISTS `tim_message`;
@@ -176,8 +217,8 @@ CREATE TABLE `tim_user` (
   `loginname` varchar(64) NOT NULL COMMENT '用户标识',
   `username` varchar(64) NOT NULL COMMENT '用户名',
   `usernick` varchar(64) NOT NULL DEFAULT '' COMMENT '用户昵称',
-  `plainpassword` varchar(64) NOT NULL DEFAULT '' COMMENT '密码明文',
-  `encryptedpassword` varchar(64) NOT NULL DEFAULT '' COMMENT '加密密码',
+  `plainpassword` varchar(64) NOT NULL DEFAULT '' COMMENT '密码明文(已废弃，设置新密码时清空)',
+  `encryptedpassword` varchar(128) NOT NULL DEFAULT '' COMMENT '加密密码',
   `createtime` datetime NOT NULL DEFAULT '1900-01-01 00:00:00' COMMENT '创建时间',
   `updatetime` datetime NOT NULL DEFAULT '1900-01-01 00:00:00' COMMENT '更新时间',
   PRIMARY KEY (`id`),
@@ -19,7 +19,7 @@ func Init() {
 func initmaster() {
 	if Master == nil {
 		//获取数据库初始化参数
-		dataSourceName, maxOpenConns, maxIdleConns := common.CF.GetDB()
+		dataSourceName, maxOpenConns, maxIdleConns := common.CF().GetDB()
 		var err error
 		//获取数据库连接
 		Master, err = GetDB(dataSourceName, maxOpenConns, maxIdleConns)
@@ -30,6 +30,12 @@ func initmaster() {
 	}
 }
 
+func Close() {
+	if Master != nil {
+		Master.Close()
+	}
+}
+
 func GetDB(dataSourceName string, maxOpenConns, maxIdleConns int) (db *sql.DB, err error) {
 	db, err = sql.Open("mysql", dataSourceName)
 	if err == nil {
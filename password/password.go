@@ -0,0 +1,225 @@
+/**
+ * 密码加密与验证
+ * 支持 bcrypt scrypt argon2id 加盐哈希，兼容旧版本 plain md5 sha1 密码
+ * 所有比较都是常量时间比较
+ *
+ * 存储格式:
+ *    bcrypt    $2a$10$...
+ *    scrypt    $scrypt$N=32768,r=8,p=1$salt$hash
+ *    argon2id  $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+ */
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/utils"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	BCRYPT   = "bcrypt"
+	SCRYPT   = "scrypt"
+	ARGON2ID = "argon2id"
+)
+
+var ErrFormat = errors.New("password hash format error")
+
+/**
+ * 新密码使用的哈希算法 tim_property: authProvider.passwordHash
+ * 为空时不加密，保持旧版本 authProvider.passwordType 的方式
+ */
+func HashType() string {
+	return common.CF().GetKV("authProvider.passwordHash", "")
+}
+
+/**按 authProvider.passwordHash 加密，未配置时按 authProvider.passwordType 处理*/
+func Hash(pwd string) (string, error) {
+	switch HashType() {
+	case BCRYPT:
+		return hashBcrypt(pwd)
+	case SCRYPT:
+		return hashScrypt(pwd)
+	case ARGON2ID:
+		return hashArgon2(pwd)
+	case "":
+		return legacyHash(pwd), nil
+	}
+	return "", errors.New(fmt.Sprint("unknown passwordHash:", HashType()))
+}
+
+/**
+ * 验证密码
+ * rehash 为true时，说明存储的是旧格式或参数已变化，验证通过后应重新加密保存
+ */
+func Verify(stored, pwd string) (ok bool, rehash bool) {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		ok = bcrypt.CompareHashAndPassword([]byte(stored), []byte(pwd)) == nil
+		if ok {
+			cost, _ := bcrypt.Cost([]byte(stored))
+			rehash = HashType() != BCRYPT || cost < bcryptCost()
+		}
+	case strings.HasPrefix(stored, "$scrypt$"):
+		var params string
+		ok, params = verifyScrypt(stored, pwd)
+		rehash = ok && (HashType() != SCRYPT || params != scryptParams())
+	case strings.HasPrefix(stored, "$argon2id$"):
+		var params string
+		ok, params = verifyArgon2(stored, pwd)
+		rehash = ok && (HashType() != ARGON2ID || params != argon2Params())
+	default:
+		ok = verifyLegacy(stored, pwd)
+		rehash = ok && HashType() != ""
+	}
+	return
+}
+
+/**旧版本密码 authProvider.passwordType: plain md5 sha1*/
+func legacyHash(pwd string) string {
+	switch common.CF().GetKV("authProvider.passwordType", "") {
+	case "md5":
+		return utils.MD5(pwd)
+	case "sha1":
+		return utils.Sha1(pwd)
+	}
+	return pwd
+}
+
+func verifyLegacy(stored, pwd string) bool {
+	switch common.CF().GetKV("authProvider.passwordType", "") {
+	case "md5", "sha1":
+		//十六进制摘要 大小写不敏感
+		return Equal(strings.ToUpper(stored), legacyHash(pwd))
+	}
+	return Equal(stored, pwd)
+}
+
+/**常量时间比较*/
+func Equal(s1, s2 string) bool {
+	return subtle.ConstantTimeCompare([]byte(s1), []byte(s2)) == 1
+}
+
+func bcryptCost() int {
+	cost := utils.Atoi(common.CF().GetKV("password.bcrypt.cost", "10"))
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return cost
+}
+
+func hashBcrypt(pwd string) (string, error) {
+	bs, err := bcrypt.GenerateFromPassword([]byte(pwd), bcryptCost())
+	return string(bs), err
+}
+
+func scryptParams() string {
+	return common.CF().GetKV("password.scrypt.params", "N=32768,r=8,p=1")
+}
+
+func hashScrypt(pwd string) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	params := scryptParams()
+	key, err := _scrypt(pwd, salt, params)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint("$scrypt$", params, "$", encode(salt), "$", encode(key)), nil
+}
+
+func verifyScrypt(stored, pwd string) (ok bool, params string) {
+	ss := strings.Split(stored, "$")
+	if len(ss) != 5 {
+		return
+	}
+	params = ss[2]
+	salt, er1 := decode(ss[3])
+	hash, er2 := decode(ss[4])
+	if er1 != nil || er2 != nil {
+		return
+	}
+	key, err := _scrypt(pwd, salt, params)
+	if err != nil {
+		return
+	}
+	ok = subtle.ConstantTimeCompare(key, hash) == 1
+	return
+}
+
+func _scrypt(pwd string, salt []byte, params string) ([]byte, error) {
+	var n, r, p int
+	if _, err := fmt.Sscanf(params, "N=%d,r=%d,p=%d", &n, &r, &p); err != nil {
+		return nil, ErrFormat
+	}
+	return scrypt.Key([]byte(pwd), salt, n, r, p, 32)
+}
+
+func argon2Params() string {
+	return common.CF().GetKV("password.argon2.params", "m=65536,t=3,p=2")
+}
+
+func hashArgon2(pwd string) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	params := argon2Params()
+	key, err := _argon2(pwd, salt, params)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint("$argon2id$v=", argon2.Version, "$", params, "$", encode(salt), "$", encode(key)), nil
+}
+
+func verifyArgon2(stored, pwd string) (ok bool, params string) {
+	ss := strings.Split(stored, "$")
+	if len(ss) != 6 || ss[2] != fmt.Sprint("v=", argon2.Version) {
+		return
+	}
+	params = ss[3]
+	salt, er1 := decode(ss[4])
+	hash, er2 := decode(ss[5])
+	if er1 != nil || er2 != nil {
+		return
+	}
+	key, err := _argon2(pwd, salt, params)
+	if err != nil {
+		return
+	}
+	ok = subtle.ConstantTimeCompare(key, hash) == 1
+	return
+}
+
+func _argon2(pwd string, salt []byte, params string) ([]byte, error) {
+	var m, t uint32
+	var p uint8
+	if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &m, &t, &p); err != nil {
+		return nil, ErrFormat
+	}
+	return argon2.IDKey([]byte(pwd), salt, t, m, p, 32), nil
+}
+
+func newSalt() (salt []byte, err error) {
+	salt = make([]byte, 16)
+	_, err = rand.Read(salt)
+	return
+}
+
+func encode(bs []byte) string {
+	return base64.RawStdEncoding.EncodeToString(bs)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,41 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/utils"
+)
+
+func Test_hash(t *testing.T) {
+	common.SetKV(map[string]string{"password.bcrypt.cost": "4", "password.scrypt.params": "N=1024,r=8,p=1", "password.argon2.params": "m=1024,t=1,p=1"})
+	for _, hashType := range []string{BCRYPT, SCRYPT, ARGON2ID} {
+		common.SetKV(map[string]string{"authProvider.passwordHash": hashType})
+		stored, err := Hash("123456")
+		if err != nil {
+			t.Fatal(hashType, err)
+		}
+		if ok, rehash := Verify(stored, "123456"); !ok || rehash {
+			t.Fatal(hashType, " verify:", ok, rehash)
+		}
+		if ok, _ := Verify(stored, "1234567"); ok {
+			t.Fatal(hashType, " wrong password")
+		}
+	}
+}
+
+func Test_legacy(t *testing.T) {
+	common.SetKV(map[string]string{"authProvider.passwordType": "md5", "authProvider.passwordHash": ""})
+	stored := utils.MD5("123456")
+	if ok, rehash := Verify(stored, "123456"); !ok || rehash {
+		t.Fatal("md5 verify:", ok, rehash)
+	}
+	common.SetKV(map[string]string{"authProvider.passwordHash": BCRYPT})
+	if ok, rehash := Verify(stored, "123456"); !ok || !rehash {
+		t.Fatal("md5 rehash:", ok, rehash)
+	}
+	common.SetKV(map[string]string{"authProvider.passwordType": "plain"})
+	if ok, _ := Verify("Abc", "abc"); ok {
+		t.Fatal("plain must be case sensitive")
+	}
+}
@@ -0,0 +1,156 @@
+/**
+ * 在线状态快照与查询
+ * 单机 最后在线时间记录在内存  集群 记录在redis: tim_lastseen_登录名
+ */
+package presence
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+var lastSeen = make(map[string]int64, 0)
+var lock = new(sync.RWMutex)
+
+/**用户下线 记录最后在线时间(毫秒)*/
+func Logout(tid *protocol.Tid) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	if !otherOnline(loginname) {
+		delState(loginname)
+	}
+	now := utils.TimeMillsInt64()
+	if cluster.IsCluster() {
+		cluster.Redis.Setex(fmt.Sprint("tim_lastseen_", loginname), utils.Atoi(common.CF().GetKV("presence.lastseen.expire", "2592000")), now)
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	lastSeen[loginname] = now
+}
+
+/**最后在线时间 没有记录时为0*/
+func LastSeen(tid *protocol.Tid) (t int64) {
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	if cluster.IsCluster() {
+		return utils.Atoi64(cluster.Redis.GetString(fmt.Sprint("tim_lastseen_", loginname)))
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+	return lastSeen[loginname]
+}
+
+/**下线的连接之外还有其他连接*/
+func otherOnline(loginname string) bool {
+	if len(connect.TP.GetLoginUser(loginname)) > 1 {
+		return true
+	}
+	if cluster.IsCluster() {
+		addrs, err := cluster.GetLoginnameFromCluter(loginname)
+		return err == nil && len(addrs) > 0
+	}
+	return false
+}
+
+/**本节点或集群其他节点有连接时在线*/
+func IsOnline(tid *protocol.Tid) bool {
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return false
+	}
+	if len(connect.TP.GetLoginUser(loginname)) > 0 {
+		return true
+	}
+	if cluster.IsCluster() {
+		addrs, err := cluster.GetLoginnameFromCluter(loginname)
+		return err == nil && len(addrs) > 0
+	}
+	return false
+}
+
+/**
+ * tid当前状态，发送给to 隐身时为离线
+ * 离线时 extraMap["lastSeen"] 为最后在线时间(毫秒)
+ */
+func Probe(tid, to *protocol.Tid) (pbean *protocol.TimPBean) {
+	pbean = protocol.NewTimPBean()
+	pbean.ThreadId = utils.TimeMills()
+	pbean.FromTid, pbean.ToTid = tid, to
+	show, status, visible := Visible(tid)
+	if visible && IsOnline(tid) {
+		if status == "" {
+			status = "probe"
+		}
+	} else {
+		show, status = OFFLINE, "unavailable"
+		if t := LastSeen(tid); t > 0 {
+			pbean.ExtraMap = map[string]string{"lastSeen": fmt.Sprint(t)}
+		}
+	}
+	pbean.Show, pbean.Status = &show, &status
+	return
+}
+
+/**登录后发送的花名册状态快照*/
+func Snapshot(tid *protocol.Tid) (pbeans []*protocol.TimPBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	pbeans = make([]*protocol.TimPBean, 0)
+	for _, r := range daoService.GetOnlineRoser(tid) {
+		pbeans = append(pbeans, Probe(r, tid))
+	}
+	return
+}
+
+/**
+ * 查询指定用户的状态，只能查询花名册中的用户
+ * 不在花名册中的返回 error 4003
+ */
+func Query(tid *protocol.Tid, names []string) (pbeans []*protocol.TimPBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	roster := make(map[string]*protocol.Tid, 0)
+	for _, r := range daoService.GetOnlineRoser(tid) {
+		roster[r.GetName()] = r
+	}
+	pbeans = make([]*protocol.TimPBean, 0, len(names))
+	for _, name := range names {
+		if r, ok := roster[name]; ok {
+			pbeans = append(pbeans, Probe(r, tid))
+		} else {
+			domain := tid.GetDomain()
+			pbean := protocol.NewTimPBean()
+			pbean.ThreadId = utils.TimeMills()
+			pbean.FromTid, pbean.ToTid = &protocol.Tid{Name: name, Domain: &domain}, tid
+			code, msg := int32(4003), "not in roster"
+			pbean.Error = &protocol.TimError{ErrCode: &code, ErrMsg: &msg}
+			pbeans = append(pbeans, pbean)
+		}
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package presence
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+const (
+	ONLINE    = "online"
+	AWAY      = "away"
+	DND       = "dnd"
+	INVISIBLE = "invisible"
+	OFFLINE   = "offline"
+)
+
+/**
+ * 用户当前状态
+ * Auto 空闲自动设置的away，再次活动时恢复online
+ */
+type State struct {
+	Show   string `json:"show"`
+	Status string `json:"status,omitempty"`
+	Auto   bool   `json:"auto,omitempty"`
+}
+
+var states = make(map[string]*State, 0)
+
+/**客户端可以设置的状态*/
+func IsShow(show string) bool {
+	switch show {
+	case ONLINE, AWAY, DND, INVISIBLE:
+		return true
+	}
+	return false
+}
+
+/**
+ * 保存用户状态
+ * 单机保存在内存  集群保存在redis: tim_pstate_登录名
+ */
+func SetState(tid *protocol.Tid, state *State) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	if cluster.IsCluster() {
+		bs, _ := json.Marshal(state)
+		cluster.Redis.Set(fmt.Sprint("tim_pstate_", loginname), string(bs))
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	states[loginname] = state
+}
+
+/**用户状态 没有设置时为nil*/
+func GetState(tid *protocol.Tid) (state *State) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+			state = nil
+		}
+	}()
+	loginname, err := connect.GetLoginName(tid)
+	if err != nil {
+		return
+	}
+	if cluster.IsCluster() {
+		if s := cluster.Redis.GetString(fmt.Sprint("tim_pstate_", loginname)); s != "" {
+			state = new(State)
+			if json.Unmarshal([]byte(s), state) != nil {
+				state = nil
+			}
+		}
+		return
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+	return states[loginname]
+}
+
+func delState(loginname string) {
+	if cluster.IsCluster() {
+		cluster.Redis.Del(fmt.Sprint("tim_pstate_", loginname))
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	delete(states, loginname)
+}
+
+/**对外显示的状态 隐身时ok为false*/
+func Visible(tid *protocol.Tid) (show, status string, ok bool) {
+	show, ok = ONLINE, true
+	if state := GetState(tid); state != nil {
+		if state.Show == INVISIBLE {
+			return "", "", false
+		}
+		show, status = state.Show, state.Status
+	}
+	return
+}
+
+/**状态对应的presence*/
+func StatePBean(tid *protocol.Tid, state *State) (pbean *protocol.TimPBean) {
+	pbean = protocol.NewTimPBean()
+	pbean.ThreadId = utils.TimeMills()
+	pbean.FromTid = tid
+	show, status := state.Show, state.Status
+	if show == INVISIBLE {
+		show, status = OFFLINE, "unavailable"
+	}
+	pbean.Show, pbean.Status = &show, &status
+	return
+}
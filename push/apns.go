@@ -0,0 +1,153 @@
+package push
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangjunfang/im/common"
+)
+
+/**
+ * apns HTTP/2接口 使用p8密钥签名的令牌验证
+ * tim_property:
+ *    push.apns.url     缺省 https://api.push.apple.com ，测试环境 https://api.sandbox.push.apple.com
+ *    push.apns.topic   应用的bundle id
+ *    push.apns.keyid   密钥id
+ *    push.apns.teamid  开发者团队id
+ *    push.apns.key     p8密钥，可以是pem内容，也可以是文件路径
+ */
+type Apns struct {
+	lock     sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+func (this *Apns) Name() string {
+	return "apns"
+}
+
+func (this *Apns) Push(n *Notification) (err error) {
+	alert := map[string]string{"title": n.Title, "body": n.Body}
+	aps := map[string]interface{}{"alert": alert}
+	if n.Badge > 0 {
+		aps["badge"] = n.Badge
+	}
+	if n.Sound != "" {
+		aps["sound"] = n.Sound
+	}
+	payload := map[string]interface{}{"aps": aps}
+	for k, v := range n.Data {
+		if k != "aps" {
+			payload[k] = v
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	url := fmt.Sprint(strings.TrimRight(common.CF().GetKV("push.apns.url", "https://api.push.apple.com"), "/"), "/3/device/", n.Token)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	token, err := this.bearer()
+	if err != nil {
+		return
+	}
+	req.Header.Set("authorization", "bearer "+token)
+	req.Header.Set("apns-topic", common.CF().GetKV("push.apns.topic", ""))
+	req.Header.Set("apns-push-type", "alert")
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return
+	}
+	reason := struct {
+		Reason string `json:"reason"`
+	}{}
+	json.NewDecoder(resp.Body).Decode(&reason)
+	if resp.StatusCode == http.StatusGone || reason.Reason == "BadDeviceToken" || reason.Reason == "Unregistered" {
+		return ErrInvalidToken
+	}
+	return errors.New(fmt.Sprint("apns status:", resp.StatusCode, " ", reason.Reason))
+}
+
+/**ES256签名令牌 有效期1小时，50分钟后重新生成*/
+func (this *Apns) bearer() (token string, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.token != "" && time.Since(this.issuedAt) < 50*time.Minute {
+		return this.token, nil
+	}
+	key, err := apnsKey()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": common.CF().GetKV("push.apns.keyid", "")})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": common.CF().GetKV("push.apns.teamid", ""), "iat": now.Unix()})
+	signing := fmt.Sprint(encode(header), ".", encode(claims))
+	hash := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return
+	}
+	sig := make([]byte, 64)
+	fillBytes(r, sig[:32])
+	fillBytes(s, sig[32:])
+	this.token, this.issuedAt = fmt.Sprint(signing, ".", encode(sig)), now
+	return this.token, nil
+}
+
+func fillBytes(i *big.Int, buf []byte) {
+	bs := i.Bytes()
+	copy(buf[len(buf)-len(bs):], bs)
+}
+
+func encode(bs []byte) string {
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func apnsKey() (key *ecdsa.PrivateKey, err error) {
+	content := common.CF().GetKV("push.apns.key", "")
+	if content == "" {
+		return nil, errors.New("push.apns.key is empty")
+	}
+	if !strings.Contains(content, "-----BEGIN") {
+		bs, er := ioutil.ReadFile(content)
+		if er != nil {
+			return nil, er
+		}
+		content = string(bs)
+	}
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return nil, errors.New("push.apns.key pem error")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return
+	}
+	key, ok := k.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("push.apns.key is not ecdsa key")
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/zhangjunfang/im/common"
+)
+
+/**
+ * fcm HTTP接口
+ * tim_property:
+ *    push.fcm.url   缺省 https://fcm.googleapis.com/fcm/send
+ *    push.fcm.key   服务器密钥
+ */
+type Fcm struct{}
+
+func (this *Fcm) Name() string {
+	return "fcm"
+}
+
+type fcmResult struct {
+	Success int `json:"success"`
+	Failure int `json:"failure"`
+	Results []struct {
+		Error string `json:"error"`
+	} `json:"results"`
+}
+
+func (this *Fcm) Push(n *Notification) (err error) {
+	notification := map[string]interface{}{"title": n.Title, "body": n.Body}
+	if n.Badge > 0 {
+		notification["badge"] = fmt.Sprint(n.Badge)
+	}
+	if n.Sound != "" {
+		notification["sound"] = n.Sound
+	}
+	payload := map[string]interface{}{"to": n.Token, "notification": notification}
+	if len(n.Data) > 0 {
+		payload["data"] = n.Data
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", common.CF().GetKV("push.fcm.url", "https://fcm.googleapis.com/fcm/send"), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "key="+common.CF().GetKV("push.fcm.key", ""))
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprint("fcm status:", resp.StatusCode))
+	}
+	result := new(fcmResult)
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return
+	}
+	if result.Failure > 0 && len(result.Results) > 0 {
+		switch e := result.Results[0].Error; e {
+		case "NotRegistered", "InvalidRegistration":
+			return ErrInvalidToken
+		default:
+			return errors.New(fmt.Sprint("fcm error:", e))
+		}
+	}
+	return
+}
@@ -0,0 +1,69 @@
+/**
+ * 推送通道
+ * 内置 apns(HTTP/2 接口) fcm(HTTP 接口)，业务系统可以实现 PushProvider 通过 Register 注册
+ */
+package push
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**推送内容*/
+type Notification struct {
+	Token string
+	Title string
+	Body  string
+	Badge int
+	Sound string
+	Data  map[string]string
+}
+
+type PushProvider interface {
+	//通道名称，对应设备注册时的provider
+	Name() string
+	Push(n *Notification) error
+}
+
+/**设备token无效，需要删除*/
+var ErrInvalidToken = errors.New("invalid device token")
+
+var providers = make(map[string]PushProvider, 0)
+var lock = new(sync.RWMutex)
+
+func init() {
+	Register(new(Apns))
+	Register(new(Fcm))
+}
+
+/**注册推送通道，同名覆盖*/
+func Register(p PushProvider) {
+	lock.Lock()
+	defer lock.Unlock()
+	providers[p.Name()] = p
+}
+
+func GetProvider(name string) PushProvider {
+	lock.RLock()
+	defer lock.RUnlock()
+	return providers[name]
+}
+
+func Push(provider string, n *Notification) error {
+	p := GetProvider(provider)
+	if p == nil {
+		return errors.New(fmt.Sprint("push provider not exist:", provider))
+	}
+	return p.Push(n)
+}
+
+func httpClient() *http.Client {
+	timeout := utils.Atoi(common.CF().GetKV("push.timeout", "10"))
+	return &http.Client{Timeout: time.Duration(timeout) * time.Second}
+}
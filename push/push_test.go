@@ -0,0 +1,85 @@
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangjunfang/im/common"
+)
+
+func Test_fcm(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "key=123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		payload := make(map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["to"] == "bad" {
+			w.Write([]byte(`{"success":0,"failure":1,"results":[{"error":"NotRegistered"}]}`))
+			return
+		}
+		w.Write([]byte(`{"success":1,"failure":0,"results":[{}]}`))
+	}))
+	defer server.Close()
+	common.SetKV(map[string]string{"push.fcm.url": server.URL, "push.fcm.key": "123"})
+	if err := Push("fcm", &Notification{Token: "ok", Title: "tim", Body: "hello", Badge: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Push("fcm", &Notification{Token: "bad"}); err != ErrInvalidToken {
+		t.Fatal("must be invalid token:", err)
+	}
+}
+
+func Test_apns(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bs, _ := x509.MarshalPKCS8PrivateKey(key)
+	common.SetKV(map[string]string{"push.apns.key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: bs})), "push.apns.topic": "com.tim"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("authorization"), "bearer ") || r.Header.Get("apns-topic") != "com.tim" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/bad") {
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason":"Unregistered"}`))
+			return
+		}
+		payload := make(map[string]map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["aps"]["badge"] != float64(3) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	common.SetKV(map[string]string{"push.apns.url": server.URL})
+	if err := Push("apns", &Notification{Token: "ok", Title: "tim", Body: "hello", Badge: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Push("apns", &Notification{Token: "bad"}); err != ErrInvalidToken {
+		t.Fatal("must be invalid token:", err)
+	}
+}
+
+func Test_template(t *testing.T) {
+	if s := Render("{from}: {body}", map[string]string{"from": "donnie", "body": "hi"}); s != "donnie: hi" {
+		t.Fatal(s)
+	}
+	night := time.Date(2016, 1, 1, 23, 30, 0, 0, time.Local)
+	noon := time.Date(2016, 1, 1, 12, 0, 0, 0, time.Local)
+	if !InQuiet("22:00", "08:00", night) || InQuiet("22:00", "08:00", noon) {
+		t.Fatal("overnight quiet")
+	}
+	if !InQuiet("11:00", "13:00", noon) || InQuiet("", "", noon) {
+		t.Fatal("quiet")
+	}
+}
@@ -0,0 +1,44 @@
+package push
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/**替换模板中的 {key}*/
+func Render(template string, vars map[string]string) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	pairs := make([]string, 0, len(vars)*2)
+	for k, v := range vars {
+		pairs = append(pairs, fmt.Sprint("{", k, "}"), v)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+/**
+ * 是否在静默时间内 start end 格式 HH:MM
+ * start大于end时跨天，如 22:00 到 08:00
+ */
+func InQuiet(start, end string, t time.Time) bool {
+	s, ok1 := minutes(start)
+	e, ok2 := minutes(end)
+	if !ok1 || !ok2 || s == e {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if s < e {
+		return now >= s && now < e
+	}
+	return now >= s || now < e
+}
+
+func minutes(hhmm string) (m int, ok bool) {
+	var h, mm int
+	if _, err := fmt.Sscanf(hhmm, "%d:%d", &h, &mm); err != nil || h < 0 || h > 23 || mm < 0 || mm > 59 {
+		return
+	}
+	return h*60 + mm, true
+}
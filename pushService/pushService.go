@@ -0,0 +1,126 @@
+/**
+ * 离线消息推送
+ * 作为 onOffline 拦截器，消息保存为离线消息时推送到接收者已注册的设备
+ * tim_property:
+ *    push.enable              1开启 0关闭，缺省0
+ *    push.title               标题模板，缺省 {from}
+ *    push.template            内容模板，缺省 {from}: {body}
+ *    push.template.msgType    指定msgType的内容模板，如 push.template.2={from}: [图片]
+ *    push.body.length         内容最大长度，缺省100
+ *    push.sound               提示音，缺省default
+ *    模板变量: {from}发送者 {room}群 {body}内容 {type}消息类型 {domain}
+ */
+package pushService
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/interceptor"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/push"
+	"github.com/zhangjunfang/im/utils"
+)
+
+func init() {
+	interceptor.Register(new(Pusher), interceptor.ON_OFFLINE)
+}
+
+func IsEnable() bool {
+	return common.CF().GetKV("push.enable", "0") == "1"
+}
+
+/**注册设备 tid.Resource区分同一用户的多个设备*/
+func RegisterDevice(tid *protocol.Tid, provider, token string, mute int, quietstart, quietend string) error {
+	if token == "" {
+		return errors.New("device token is empty")
+	}
+	if push.GetProvider(provider) == nil {
+		return errors.New(fmt.Sprint("push provider not exist:", provider))
+	}
+	return daoService.SaveDevice(tid, provider, token, mute, quietstart, quietend)
+}
+
+func UnregisterDevice(tid *protocol.Tid) {
+	daoService.DelDevice(tid)
+}
+
+/**推送离线消息到接收者的所有设备，免打扰与静默时间内不推送*/
+func Notify(mbean *protocol.TimMBean) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	toTid := mbean.GetToTid()
+	if toTid == nil {
+		return
+	}
+	devices := daoService.LoadDevices(toTid)
+	if len(devices) == 0 {
+		return
+	}
+	now := time.Now()
+	var n *push.Notification
+	for _, device := range devices {
+		if device.GetMute() == 1 || push.InQuiet(device.GetQuietstart(), device.GetQuietend(), now) {
+			continue
+		}
+		if n == nil {
+			n = notification(mbean)
+		}
+		dn := *n
+		dn.Token = device.GetToken()
+		err := push.Push(device.GetProvider(), &dn)
+		if err == push.ErrInvalidToken {
+			logger.Warn("push invalid token:", toTid.GetName(), " ", device.GetResource())
+			daoService.DelDeviceToken(device.GetToken())
+		} else if err != nil {
+			logger.Error("push error:", toTid.GetName(), " ", err.Error())
+		}
+	}
+}
+
+func notification(mbean *protocol.TimMBean) *push.Notification {
+	vars := map[string]string{"body": mbean.GetBody(), "type": fmt.Sprint(mbean.GetMsgType()), "domain": mbean.GetFromTid().GetDomain(), "room": ""}
+	if mbean.GetType() == "groupchat" && mbean.GetLeaguerTid() != nil {
+		vars["from"], vars["room"] = mbean.GetLeaguerTid().GetName(), mbean.GetFromTid().GetName()
+	} else {
+		vars["from"] = mbean.GetFromTid().GetName()
+	}
+	if length := utils.Atoi(common.CF().GetKV("push.body.length", "100")); length > 0 {
+		if body := []rune(vars["body"]); len(body) > length {
+			vars["body"] = string(body[:length]) + "..."
+		}
+	}
+	template := common.CF().GetKV(fmt.Sprint("push.template.", mbean.GetMsgType()), "")
+	if template == "" {
+		template = common.CF().GetKV("push.template", "{from}: {body}")
+	}
+	return &push.Notification{
+		Title: push.Render(common.CF().GetKV("push.title", "{from}"), vars),
+		Body:  push.Render(template, vars),
+		Badge: daoService.CountOfflineMBean(mbean.GetToTid()),
+		Sound: common.CF().GetKV("push.sound", "default"),
+		Data:  map[string]string{"mid": mbean.GetMid(), "from": vars["from"], "room": vars["room"]},
+	}
+}
+
+/**离线推送拦截器*/
+type Pusher struct{}
+
+func (this *Pusher) Name() string {
+	return "push"
+}
+
+func (this *Pusher) Intercept(ctx *interceptor.Context) interceptor.Action {
+	if IsEnable() && ctx.Mbean != nil {
+		go Notify(ctx.Mbean)
+	}
+	return interceptor.CONTINUE
+}
@@ -0,0 +1,164 @@
+/**
+ * 消息频率限制 令牌桶
+ * 超出限制的请求不断开连接，返回 errCode 4290 的ack
+ * tim_property:
+ *    rate.enable                   1开启 0关闭，缺省0
+ *    rate.message                  每个用户每秒消息数与突发数 如 10,20 ，为空或0不限制
+ *    rate.message.domain.xxx       指定domain，优先于usertype与全局
+ *    rate.message.usertype.x       指定用户类型 0客户端 1集群节点
+ *                                  集群节点合流转发多个用户的信息，只使用 usertype.1 的配置，缺省不限制
+ *    rate.presence ...             presence 同上
+ *    rate.conn ...                 每个连接所有请求(message,presence)的限制，同上
+ */
+package rateLimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/protocol"
+)
+
+const (
+	MESSAGE  = "message"
+	PRESENCE = "presence"
+	CONN     = "conn"
+)
+
+/**令牌桶*/
+type Bucket struct {
+	tokens float64
+	last   time.Time
+	lock   *sync.Mutex
+}
+
+func NewBucket() *Bucket {
+	return &Bucket{tokens: -1, lock: new(sync.Mutex)}
+}
+
+/**
+ * 取一个令牌 rate每秒补充数 burst桶容量
+ * 不足时返回需要等待的时间
+ */
+func (this *Bucket) Take(rate, burst float64) (ok bool, wait time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := time.Now()
+	if this.tokens < 0 {
+		this.tokens = burst
+	} else {
+		this.tokens += now.Sub(this.last).Seconds() * rate
+		if this.tokens > burst {
+			this.tokens = burst
+		}
+	}
+	this.last = now
+	if this.tokens >= 1 {
+		this.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - this.tokens) / rate * float64(time.Second))
+}
+
+func (this *Bucket) idle(d time.Duration) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return time.Since(this.last) > d
+}
+
+var buckets = make(map[string]*Bucket, 0)
+var lock = new(sync.RWMutex)
+
+func init() {
+	go clean()
+}
+
+func IsEnable() bool {
+	return common.CF().GetKV("rate.enable", "0") == "1"
+}
+
+/**
+ * 配置的速率 domain > usertype > 全局
+ * 值格式 rate,burst  burst缺省与rate相同
+ * 集群节点(usertype 1)只使用 usertype 配置，没有配置时不限制
+ */
+func Limit(kind, domain string, usertype int) (rate, burst float64) {
+	if usertype == 1 {
+		return parse(common.CF().GetKV(fmt.Sprint("rate.", kind, ".usertype.", usertype), ""))
+	}
+	value := common.CF().GetKV(fmt.Sprint("rate.", kind, ".domain.", domain), "")
+	if value == "" {
+		value = common.CF().GetKV(fmt.Sprint("rate.", kind, ".usertype.", usertype), "")
+	}
+	if value == "" {
+		value = common.CF().GetKV(fmt.Sprint("rate.", kind), "")
+	}
+	return parse(value)
+}
+
+func parse(value string) (rate, burst float64) {
+	ss := strings.Split(value, ",")
+	rate, _ = strconv.ParseFloat(strings.TrimSpace(ss[0]), 64)
+	burst = rate
+	if len(ss) > 1 {
+		burst, _ = strconv.ParseFloat(strings.TrimSpace(ss[1]), 64)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return
+}
+
+/**用户级限制 同一用户的所有连接与http请求共享*/
+func Allow(kind string, tid *protocol.Tid, usertype int) (ok bool, wait time.Duration) {
+	if !IsEnable() || tid == nil {
+		return true, 0
+	}
+	rate, burst := Limit(kind, tid.GetDomain(), usertype)
+	if rate <= 0 {
+		return true, 0
+	}
+	key := fmt.Sprint(kind, "_", tid.GetDomain(), "_", tid.GetName())
+	lock.RLock()
+	bucket, exist := buckets[key]
+	lock.RUnlock()
+	if !exist {
+		lock.Lock()
+		if bucket, exist = buckets[key]; !exist {
+			bucket = NewBucket()
+			buckets[key] = bucket
+		}
+		lock.Unlock()
+	}
+	return bucket.Take(rate, burst)
+}
+
+/**连接级限制 bucket为连接持有的令牌桶*/
+func AllowConn(bucket *Bucket, domain string, usertype int) (ok bool, wait time.Duration) {
+	if !IsEnable() || bucket == nil {
+		return true, 0
+	}
+	rate, burst := Limit(CONN, domain, usertype)
+	if rate <= 0 {
+		return true, 0
+	}
+	return bucket.Take(rate, burst)
+}
+
+// 清除10分钟未使用的令牌桶
+func clean() {
+	for {
+		time.Sleep(60 * time.Second)
+		lock.Lock()
+		for k, b := range buckets {
+			if b.idle(10 * time.Minute) {
+				delete(buckets, k)
+			}
+		}
+		lock.Unlock()
+	}
+}
@@ -0,0 +1,38 @@
+package rateLimit
+
+import (
+	"testing"
+
+	"github.com/zhangjunfang/im/common"
+)
+
+func Test_bucket(t *testing.T) {
+	rate, burst := parse("1,3")
+	if rate != 1 || burst != 3 {
+		t.Fatal("parse:", rate, burst)
+	}
+	bucket := NewBucket()
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.Take(rate, burst); !ok {
+			t.Fatal("burst:", i)
+		}
+	}
+	if ok, wait := bucket.Take(rate, burst); ok || wait <= 0 {
+		t.Fatal("must limit:", ok, wait)
+	}
+}
+
+func Test_limitCluster(t *testing.T) {
+	defer common.SetCF(common.CF())
+	common.SetKV(map[string]string{"rate.message": "10", "rate.message.domain.test.com": "5"})
+	if rate, _ := Limit(MESSAGE, "test.com", 1); rate != 0 {
+		t.Fatal("cluster limited:", rate)
+	}
+	if rate, _ := Limit(MESSAGE, "test.com", 0); rate != 5 {
+		t.Fatal("domain:", rate)
+	}
+	common.SetKV(map[string]string{"rate.message.usertype.1": "100"})
+	if rate, _ := Limit(MESSAGE, "test.com", 1); rate != 100 {
+		t.Fatal("usertype:", rate)
+	}
+}
@@ -10,13 +10,14 @@ import (
 	. "github.com/zhangjunfang/im/common"
 	. "github.com/zhangjunfang/im/connect"
 	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/interceptor"
 	"github.com/zhangjunfang/im/protocol"
 	"github.com/zhangjunfang/im/utils"
 )
 
 /**********************************************Message***********************************************/
-/**Message*/
-func RouteMBean(mbean *protocol.TimMBean, isSingle, async bool) (mid string, er error, offline bool) {
+/**Message dropped为true时消息被 POST_STORE 拦截器丢弃，没有投递*/
+func RouteMBean(mbean *protocol.TimMBean, isSingle, async bool) (mid string, er error, offline, dropped bool) {
 	defer func() {
 		if err := recover(); err != nil {
 			er = errors.New(fmt.Sprint("RouteMBean:", err))
@@ -25,22 +26,31 @@ func RouteMBean(mbean *protocol.TimMBean, isSingle, async bool) (mid string, er
 		}
 	}()
 
+	if IsCarbon(mbean) {
+		//其他节点转发的抄送，只投递到发送者在本节点的连接，不保存
+		RouteCarbon(mbean, nil)
+		mid = mbean.GetMid()
+		return
+	}
 	loginname, _ := GetLoginName(mbean.GetToTid())
-	if CF.Db_Exsit == 0 {
-		mid = fmt.Sprint(utils.GetRand(100000000))
+	if IsStored(mbean) {
+		//已经保存过的消息 保存时已经经过 POST_STORE
+		mid = mbean.ExtraMap[STORED]
+		delete(mbean.ExtraMap, STORED)
+		mbean.Mid = &mid
 	} else {
-		if isSingle {
+		if isSingle && CF().Db_Exsit == 1 {
 			mid, _, er = daoService.SaveSingleMBean(mbean)
 		} else {
-			if mbean.GetType() == "groupchat" {
-				mid, er = daoService.SaveMucMBean(mbean)
-			} else {
-				mid, _, er = daoService.SaveMBean(mbean)
-			}
+			mid, er = StoreMBean(mbean)
+		}
+		if er != nil {
+			return
+		}
+		mbean.Mid = &mid
+		if dropped, er = PostStore(mbean); dropped || er != nil {
+			return
 		}
-	}
-	if er != nil {
-		return
 	}
 	if async {
 		go func() {
@@ -49,54 +59,102 @@ func RouteMBean(mbean *protocol.TimMBean, isSingle, async bool) (mid string, er
 					logger.Error(string(debug.Stack()))
 				}
 			}()
-			mbean.Mid = &mid
-			tus := TP.GetLoginUser(loginname)
-			if tus != nil {
-				if len(tus) > 0 {
-					isSendok := false
-					for _, tu := range tus {
-						err := tu.SendMBean(mbean)
-						if err != nil {
-							logger.Error("routemessage :", err)
-						} else {
-							isSendok = true
-						}
-					}
-					if !isSendok {
-						daoService.SaveOfflineMBean(mbean)
-					}
-				}
-			} else {
-				daoService.SaveOfflineMBean(mbean)
-			}
+			deliverMBean(loginname, mbean)
 		}()
 	} else {
-		mbean.Mid = &mid
-		tus := TP.GetLoginUser(loginname)
-		if tus != nil {
-			if len(tus) > 0 {
-				isSendok := false
-				for _, tu := range tus {
-					err := tu.SendMBean(mbean)
-					if err != nil {
-						logger.Error("routemessage :", err)
-					} else {
-						isSendok = true
-					}
+		offline = deliverMBean(loginname, mbean)
+	}
+	return
+}
+
+/**
+ * 保存后的拦截 每条消息只调用一次 dropped为true时丢弃
+ * 拒绝时删除已经保存的消息，丢弃时消息保留在历史记录中，只是不投递
+ */
+func PostStore(mbean *protocol.TimMBean) (dropped bool, er error) {
+	action, ctx := interceptor.MBean(interceptor.POST_STORE, mbean)
+	switch action {
+	case interceptor.REJECT:
+		daoService.DelStoredMBean(mbean)
+		er = errors.New("rejected by interceptor")
+		if ctx.Error != nil {
+			er = errors.New(ctx.Error.GetErrMsg())
+		}
+	case interceptor.DROP:
+		dropped = true
+	}
+	return
+}
+
+/**保存消息 没有数据库时生成随机mid*/
+func StoreMBean(mbean *protocol.TimMBean) (mid string, er error) {
+	if CF().Db_Exsit == 0 {
+		mid = fmt.Sprint(utils.GetRand(100000000))
+	} else if mbean.GetType() == "groupchat" {
+		mid, er = daoService.SaveMucMBean(mbean)
+	} else {
+		mid, _, er = daoService.SaveMBean(mbean)
+	}
+	return
+}
+
+func init() {
+	//异步发送队列中没有投递成功的信息保存为离线信息
+	Undelivered = func(mbeans []*protocol.TimMBean) {
+		for _, mbean := range mbeans {
+			daoService.SaveOfflineMBean(mbean)
+		}
+	}
+}
+
+/**投递到在线连接，都没有投递成功时保存为离线消息*/
+func deliverMBean(loginname string, mbean *protocol.TimMBean) (offline bool) {
+	tus := FilterResource(TP.GetLoginUser(loginname), mbean.GetToTid())
+	if tus != nil {
+		if len(tus) > 0 {
+			isSendok := false
+			for _, tu := range tus {
+				if interceptor.Deliver(mbean, tu.UserTid) != interceptor.CONTINUE {
+					isSendok = true
+					continue
 				}
-				if !isSendok {
-					daoService.SaveOfflineMBean(mbean)
-					offline = true
+				err := tu.SendMBean(mbean)
+				if err != nil {
+					logger.Error("routemessage :", err)
+				} else {
+					isSendok = true
 				}
 			}
-		} else {
-			daoService.SaveOfflineMBean(mbean)
-			offline = true
+			if !isSendok {
+				daoService.SaveOfflineMBean(mbean)
+				offline = true
+			}
 		}
+	} else {
+		daoService.SaveOfflineMBean(mbean)
+		offline = true
 	}
 	return
 }
 
+/**抄送到发送者在本节点的其他连接 exclude为发送消息的连接*/
+func RouteCarbon(carbon *protocol.TimMBean, exclude *TimUser) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("RouteCarbon,", err)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	loginname, _ := GetLoginName(CarbonTid(carbon))
+	for _, tu := range TP.GetLoginUser(loginname) {
+		if tu != exclude {
+			if err := tu.SendMBean(carbon); err != nil {
+				logger.Error("routecarbon :", err)
+			}
+		}
+	}
+}
+
 func SaveMBean(mbean *protocol.TimMBean) {
 	daoService.SaveMBean(mbean)
 }
@@ -119,41 +177,66 @@ func _RouteMBeanList(mbeans []*protocol.TimMBean) {
 	if mbeans != nil && len(mbeans) > 0 {
 		loginnamemap := make(map[string][]*protocol.TimMBean, 0)
 		for _, mbean := range mbeans {
-			SaveMBean(mbean)
+			if IsStored(mbean) {
+				delete(mbean.ExtraMap, STORED)
+			} else {
+				if mid, _, _ := daoService.SaveMBean(mbean); mid != "" {
+					mbean.Mid = &mid
+				}
+				//与 RouteMBean 相同 每条消息经过 POST_STORE 拦截器
+				if dropped, er := PostStore(mbean); dropped || er != nil {
+					continue
+				}
+			}
 			loginname, _ := GetLoginName(mbean.GetToTid())
 			if _, ok := loginnamemap[loginname]; !ok {
 				loginnamemap[loginname] = make([]*protocol.TimMBean, 0)
 			}
 			loginnamemap[loginname] = append(loginnamemap[loginname], mbean)
 		}
-		if len(loginnamemap) > 0 {
-			for k, v := range loginnamemap {
-				tus := TP.GetLoginUser(k)
-				if tus != nil {
-					if len(tus) > 0 {
-						isSendok := false
-						for _, tu := range tus {
-							err := tu.SendMBeanList(v)
-							if err != nil {
-								logger.Error("routemessage :", err)
-							} else {
-								isSendok = true
-							}
-						}
-						if !isSendok {
-							daoService.SaveOfflineMBeanList(v)
-						}
+		for k, v := range loginnamemap {
+			tus := TP.GetLoginUser(k)
+			//与 deliverMBean 相同 每条消息按toTid的resource选择连接，再按连接合并发送
+			lists := make(map[*TimUser][]*protocol.TimMBean, 0)
+			order := make([]*TimUser, 0)
+			sendok := make(map[*protocol.TimMBean]bool, 0)
+			for _, mbean := range v {
+				for _, tu := range FilterResource(tus, mbean.GetToTid()) {
+					if interceptor.Deliver(mbean, tu.UserTid) != interceptor.CONTINUE {
+						sendok[mbean] = true
+						continue
 					}
+					if _, ok := lists[tu]; !ok {
+						order = append(order, tu)
+					}
+					lists[tu] = append(lists[tu], mbean)
+				}
+			}
+			for _, tu := range order {
+				if err := tu.SendMBeanList(lists[tu]); err != nil {
+					logger.Error("routemessage :", err)
 				} else {
-					daoService.SaveOfflineMBeanList(v)
+					for _, mbean := range lists[tu] {
+						sendok[mbean] = true
+					}
 				}
 			}
+			//没有投递成功的信息保存为离线信息
+			offline := make([]*protocol.TimMBean, 0)
+			for _, mbean := range v {
+				if !sendok[mbean] {
+					offline = append(offline, mbean)
+				}
+			}
+			if len(offline) > 0 {
+				daoService.SaveOfflineMBeanList(offline)
+			}
 		}
 	}
 }
 
 func RouteOffLineMBean(tu *TimUser) (er error) {
-	if CF.Db_Exsit == 0 {
+	if CF().Db_Exsit == 0 {
 		return
 	}
 	defer func() {
@@ -289,7 +372,7 @@ func RouteSinglePBean(pbean *protocol.TimPBean) {
 	tid := pbean.GetToTid()
 	if tid != nil {
 		loginname, _ := GetLoginName(tid)
-		tus := TP.GetLoginUser(loginname)
+		tus := FilterResource(TP.GetLoginUser(loginname), tid)
 		if tus != nil && len(tus) > 0 {
 			for _, tu := range tus {
 				pbean.ToTid = tu.UserTid
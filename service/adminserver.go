@@ -0,0 +1,39 @@
+package service
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+)
+
+/**
+ * 管理端口 AdminAddr 不为空时开启 提供 /debug/pprof/
+ * 缺省只允许 auth.guard.adminIps 访问(http.allow.pprof http.auth.pprof 可修改)
+ */
+func AdminServer() {
+	if common.CF().AdminAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", guard("pprof", pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", guard("pprof", pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", guard("pprof", pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", guard("pprof", pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", guard("pprof", pprof.Trace))
+	//profile 与 trace 按 seconds 参数采样，不设置写超时
+	s := &http.Server{
+		Addr:           common.CF().AdminAddr,
+		Handler:        mux,
+		ReadTimeout:    10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+	if !addServer(s) {
+		return
+	}
+	if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("admin server:", err.Error())
+	}
+}
@@ -0,0 +1,484 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/authProvider"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/fw"
+	"github.com/zhangjunfang/im/impl"
+	"github.com/zhangjunfang/im/password"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * JSON 管理接口 /api/...
+ * 请求头 Authorization: Bearer <admin.api.token>，admin.api.token 为空时接口关闭
+ * GET 参数在url中，POST DELETE 参数为json
+ * 返回 {"code":200,"msg":"ok","data":...}，code与http状态码相同
+ */
+type apiRequest struct {
+	Domain     string            `json:"domain"`
+	Name       string            `json:"name"`
+	Resource   string            `json:"resource"`
+	From       string            `json:"from"`
+	To         []string          `json:"to"`
+	Room       string            `json:"room"`
+	Body       string            `json:"body"`
+	MsgType    int16             `json:"msgType"`
+	ExtraMap   map[string]string `json:"extraMap"`
+	Password   string            `json:"password"`
+	Nick       string            `json:"nick"`
+	Remark     string            `json:"remark"`
+	Roster     string            `json:"roster"`
+	RosterType string            `json:"rosterType"`
+	Members    []string          `json:"members"`
+}
+
+type apiResult struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+/**带http状态码的错误*/
+type apiError struct {
+	code int
+	msg  string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func badRequest(msg string) error {
+	return &apiError{http.StatusBadRequest, msg}
+}
+
+type apiHandler func(req *apiRequest) (data interface{}, err error)
+
+var apis map[string]apiHandler
+
+func init() {
+	apis = map[string]apiHandler{
+		"POST /api/message":         apiMessage,
+		"POST /api/room/message":    apiRoomMessage,
+		"POST /api/notice":          apiNotice,
+		"GET /api/online":           apiOnline,
+		"POST /api/kick":            apiKick,
+		"GET /api/domains":          apiDomains,
+		"POST /api/domains":         apiAddDomain,
+		"POST /api/users":           apiAddUser,
+		"POST /api/users/passwd":    apiPasswd,
+		"POST /api/users/disable":   apiDisable,
+		"GET /api/roster":           apiRoster,
+		"POST /api/roster":          apiAddRoster,
+		"DELETE /api/roster":        apiDelRoster,
+		"POST /api/rooms":           apiCreateRoom,
+		"GET /api/rooms/members":    apiRoomMembers,
+		"POST /api/rooms/members":   apiAddRoomMembers,
+		"DELETE /api/rooms/members": apiDelRoomMembers,
+		"GET /api/offline":          apiOffline,
+	}
+}
+
+func api(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+			writeApi(w, &apiResult{Code: http.StatusInternalServerError, Msg: fmt.Sprint(err)})
+		}
+	}()
+	token := common.CF().GetKV("admin.api.token", "")
+	if token == "" {
+		writeApi(w, &apiResult{Code: http.StatusNotFound, Msg: "admin api disabled"})
+		return
+	}
+	if !password.Equal(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), token) {
+		logger.Warn("api unauthorized:", clientIp(r), " ", r.URL.Path)
+		writeApi(w, &apiResult{Code: http.StatusUnauthorized, Msg: "unauthorized"})
+		return
+	}
+	handler, ok := apis[fmt.Sprint(r.Method, " ", strings.TrimSuffix(r.URL.Path, "/"))]
+	if !ok {
+		writeApi(w, &apiResult{Code: http.StatusNotFound, Msg: "not found"})
+		return
+	}
+	req := new(apiRequest)
+	if r.Method == "GET" {
+		q := r.URL.Query()
+		req.Domain, req.Name, req.Resource, req.Room = q.Get("domain"), q.Get("name"), q.Get("resource"), q.Get("room")
+	} else {
+		bs, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err == nil {
+			err = json.Unmarshal(bs, req)
+		}
+		if err != nil {
+			writeApi(w, &apiResult{Code: http.StatusBadRequest, Msg: err.Error()})
+			return
+		}
+	}
+	data, err := handler(req)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if e, ok := err.(*apiError); ok {
+			code = e.code
+		}
+		writeApi(w, &apiResult{Code: code, Msg: err.Error()})
+		return
+	}
+	writeApi(w, &apiResult{Code: http.StatusOK, Msg: "ok", Data: data})
+}
+
+func writeApi(w http.ResponseWriter, result *apiResult) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(result.Code)
+	json.NewEncoder(w).Encode(result)
+}
+
+func apiTid(name, domain string) (*protocol.Tid, error) {
+	if name == "" || domain == "" {
+		return nil, badRequest("name and domain are required")
+	}
+	if !daoService.CheckDomain(domain) {
+		return nil, badRequest(fmt.Sprint("domain not exist: ", domain))
+	}
+	tid := protocol.NewTid()
+	tid.Name, tid.Domain = name, &domain
+	return tid, nil
+}
+
+func newApiMBean(req *apiRequest, from *protocol.Tid) *protocol.TimMBean {
+	mbean := protocol.NewTimMBean()
+	mbean.FromTid = from
+	mbean.Body = &req.Body
+	if req.MsgType != 0 {
+		mbean.MsgType = &req.MsgType
+	}
+	mbean.ExtraMap = req.ExtraMap
+	return mbean
+}
+
+type sendResult struct {
+	To      string `json:"to"`
+	Mid     string `json:"mid,omitempty"`
+	Offline bool   `json:"offline"`
+	Error   string `json:"error,omitempty"`
+}
+
+func sendToUsers(req *apiRequest, from *protocol.Tid) (results []*sendResult, err error) {
+	if len(req.To) == 0 {
+		return nil, badRequest("to is required")
+	}
+	results = make([]*sendResult, 0, len(req.To))
+	for _, to := range req.To {
+		result := &sendResult{To: to}
+		mbean := newApiMBean(req, from)
+		mbean.ToTid = protocol.NewTid()
+		mbean.ToTid.Name = to
+		chat := "chat"
+		mbean.Type = &chat
+		var er error
+		if result.Mid, result.Offline, er = impl.SendMBean(mbean); er != nil {
+			result.Error = er.Error()
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+/**向用户发送消息 {domain, from, to:[], body, msgType, extraMap}*/
+func apiMessage(req *apiRequest) (data interface{}, err error) {
+	from, err := apiTid(req.From, req.Domain)
+	if err != nil {
+		return
+	}
+	return sendToUsers(req, from)
+}
+
+func sendToRoom(req *apiRequest, leaguer *protocol.Tid) (data interface{}, err error) {
+	room, err := apiTid(req.Room, req.Domain)
+	if err != nil {
+		return
+	}
+	mbean := newApiMBean(req, room)
+	mbean.LeaguerTid = leaguer
+	mid, err := impl.SendRoomMBean(mbean)
+	if err != nil {
+		return
+	}
+	return map[string]string{"mid": mid}, nil
+}
+
+/**向群发送消息 {domain, room, from, body, msgType, extraMap}*/
+func apiRoomMessage(req *apiRequest) (data interface{}, err error) {
+	from, err := apiTid(req.From, req.Domain)
+	if err != nil {
+		return
+	}
+	return sendToRoom(req, from)
+}
+
+/**
+ * 系统通知 {domain, to:[] 或 room, body, extraMap}
+ * 发送者为 admin.api.notice.from(缺省system)，extraMap["tim_notice"]="1"
+ */
+func apiNotice(req *apiRequest) (data interface{}, err error) {
+	from, err := apiTid(common.CF().GetKV("admin.api.notice.from", "system"), req.Domain)
+	if err != nil {
+		return
+	}
+	if req.ExtraMap == nil {
+		req.ExtraMap = make(map[string]string, 0)
+	}
+	req.ExtraMap["tim_notice"] = "1"
+	if req.Room != "" {
+		return sendToRoom(req, from)
+	}
+	return sendToUsers(req, from)
+}
+
+type onlineConn struct {
+	Name     string `json:"name,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Resource string `json:"resource"`
+	Node     string `json:"node"`
+	Since    string `json:"since,omitempty"`
+}
+
+func localNode() string {
+	if cluster.IsCluster() {
+		return cluster.LocalAddr()
+	}
+	return "local"
+}
+
+/**
+ * 在线查询 带name时返回用户在所有节点的resource
+ * 不带name时返回本节点的连接数与在线用户
+ */
+func apiOnline(req *apiRequest) (data interface{}, err error) {
+	if req.Name == "" {
+		conns := make([]*onlineConn, 0)
+		connect.TP.Range(func(tu *connect.TimUser) bool {
+			if tu.Fw == fw.AUTH && tu.UserType == 0 && tu.UserTid != nil && (req.Domain == "" || tu.UserTid.GetDomain() == req.Domain) {
+				conns = append(conns, &onlineConn{tu.UserTid.GetName(), tu.UserTid.GetDomain(), tu.UserTid.GetResource(), localNode(), utils.TimeMills2TimeFormat(tu.IdCardNo)})
+			}
+			return true
+		})
+		return map[string]interface{}{"node": localNode(), "connections": connect.TP.Len4P(), "users": connect.TP.Len4PU(), "list": conns}, nil
+	}
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	loginname, _ := connect.GetLoginName(tid)
+	conns := make([]*onlineConn, 0)
+	for _, tu := range connect.TP.GetLoginUser(loginname) {
+		conns = append(conns, &onlineConn{Resource: tu.UserTid.GetResource(), Node: localNode(), Since: utils.TimeMills2TimeFormat(tu.IdCardNo)})
+	}
+	if cluster.IsCluster() {
+		resources, er := cluster.GetResources(loginname)
+		if er != nil {
+			return nil, er
+		}
+		for resource, addrs := range resources {
+			for _, addr := range addrs {
+				if addr != cluster.LocalAddr() {
+					conns = append(conns, &onlineConn{Resource: resource, Node: addr})
+				}
+			}
+		}
+	}
+	return map[string]interface{}{"online": len(conns) > 0, "list": conns}, nil
+}
+
+/**踢下线 {domain, name, resource} 不带resource时关闭所有连接*/
+func apiKick(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	if req.Resource != "" {
+		tid.Resource = &req.Resource
+	}
+	return map[string]int{"local": impl.Kick(tid)}, nil
+}
+
+func apiDomains(req *apiRequest) (data interface{}, err error) {
+	domains, err := daoService.ListDomains()
+	if err != nil {
+		return
+	}
+	list := make([]map[string]string, 0, len(domains))
+	for _, d := range domains {
+		list = append(list, map[string]string{"domain": d.GetDomain(), "remark": d.GetRemark(), "createtime": d.GetCreatetime()})
+	}
+	return list, nil
+}
+
+func apiAddDomain(req *apiRequest) (data interface{}, err error) {
+	if req.Domain == "" {
+		return nil, badRequest("domain is required")
+	}
+	err = daoService.AddDomain(req.Domain, req.Remark)
+	return
+}
+
+func apiAddUser(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	if req.Password == "" {
+		return nil, badRequest("password is required")
+	}
+	encryptedpassword, err := password.Hash(req.Password)
+	if err != nil {
+		return
+	}
+	err = daoService.AddUser(tid, encryptedpassword, req.Nick)
+	return
+}
+
+/**重设密码 同时解除禁用*/
+func apiPasswd(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	if req.Password == "" {
+		return nil, badRequest("password is required")
+	}
+	err = authProvider.SetPassword(tid, req.Password)
+	return
+}
+
+/**禁用用户 同时踢下线*/
+func apiDisable(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	if err = daoService.DisableUser(tid); err == nil {
+		impl.Kick(tid)
+	}
+	return
+}
+
+func apiRoster(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	rosters, err := daoService.LoadRoster(tid)
+	if err != nil {
+		return
+	}
+	list := make([]map[string]string, 0, len(rosters))
+	for _, r := range rosters {
+		list = append(list, map[string]string{"roster": r.GetRostername(), "rosterType": r.GetRostertype(), "nick": r.GetRemarknick()})
+	}
+	return list, nil
+}
+
+func apiAddRoster(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	if req.Roster == "" {
+		return nil, badRequest("roster is required")
+	}
+	err = daoService.AddRoster(tid, req.Roster, req.RosterType, req.Nick)
+	return
+}
+
+func apiDelRoster(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	if req.Roster == "" {
+		return nil, badRequest("roster is required")
+	}
+	err = daoService.DelRoster(tid, req.Roster)
+	return
+}
+
+/**新建群 {domain, room, nick(群名称), members}*/
+func apiCreateRoom(req *apiRequest) (data interface{}, err error) {
+	roomid, err := apiTid(req.Room, req.Domain)
+	if err != nil {
+		return
+	}
+	err = daoService.CreateRoom(roomid, req.Nick, req.Members)
+	return
+}
+
+func apiRoomMembers(req *apiRequest) (data interface{}, err error) {
+	roomid, err := apiTid(req.Room, req.Domain)
+	if err != nil {
+		return
+	}
+	members := make([]string, 0)
+	for _, tid := range daoService.LoadMucmember(roomid) {
+		members = append(members, tid.GetName())
+	}
+	return members, nil
+}
+
+func apiAddRoomMembers(req *apiRequest) (data interface{}, err error) {
+	roomid, err := apiTid(req.Room, req.Domain)
+	if err != nil {
+		return
+	}
+	if len(req.Members) == 0 {
+		return nil, badRequest("members is required")
+	}
+	for _, member := range req.Members {
+		if err = daoService.AddMucmember(roomid, member); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func apiDelRoomMembers(req *apiRequest) (data interface{}, err error) {
+	roomid, err := apiTid(req.Room, req.Domain)
+	if err != nil {
+		return
+	}
+	if len(req.Members) == 0 {
+		return nil, badRequest("members is required")
+	}
+	for _, member := range req.Members {
+		if err = daoService.DelMucmember(roomid, member); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**离线信息条数*/
+func apiOffline(req *apiRequest) (data interface{}, err error) {
+	tid, err := apiTid(req.Name, req.Domain)
+	if err != nil {
+		return
+	}
+	if common.CF().Db_Exsit == 0 {
+		return nil, daoService.ErrNoDb
+	}
+	return map[string]int{"chat": daoService.CountOfflineMBean(tid), "groupchat": daoService.CountOfflineMucMBean(tid)}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"runtime/debug"
 	"strconv"
 	"sync"
@@ -18,7 +19,10 @@ import (
 	"github.com/zhangjunfang/im/connect"
 	"github.com/zhangjunfang/im/fw"
 	"github.com/zhangjunfang/im/impl"
+	"github.com/zhangjunfang/im/interceptor"
+	"github.com/zhangjunfang/im/presence"
 	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/rateLimit"
 	"github.com/zhangjunfang/im/route"
 	"github.com/zhangjunfang/im/thriftserver"
 	"github.com/zhangjunfang/im/utils"
@@ -30,10 +34,12 @@ type Controlloer struct {
 }
 
 func ServerStart() {
+	startWheel()
 	go Httpserver()
+	go AdminServer()
 	go tsslServer()
 	s := new(Controlloer)
-	s.SetAddr(common.CF.GetIp())
+	s.SetAddr(common.CF().GetIp())
 	if cluster.IsCluster() {
 		go clusterServer.ServerStart()
 	}
@@ -62,14 +68,17 @@ func (t *Controlloer) Server() {
 	server := thriftserver.NewTSimpleServer4(processor, serverTransport, transportFactory, protocolFactory)
 	fmt.Println("server listen:", t.ListenAddr())
 	Listen(server, 100)
+	addListener(serverTransport)
 	if err == nil {
-		for {
+		for !IsClosing() {
 			client, err := Accept(server)
 			if err == nil {
 				go controllerHandler(client)
 			}
 		}
 	}
+	//停止接受连接后由Shutdown退出进程
+	select {}
 }
 
 func Listen(server *thriftserver.TSimpleServer, count int) (err error) {
@@ -101,16 +110,16 @@ func Accept(server *thriftserver.TSimpleServer) (client thrift.TTransport, err e
 }
 
 func tsslServer() {
-	if common.CF.TLSPort <= 0 && common.CF.TLSServerPem == "" && common.CF.TLSServerKey == "" {
+	if common.CF().TLSPort <= 0 && common.CF().TLSServerPem == "" && common.CF().TLSServerKey == "" {
 		return
 	}
-	cer, err := tls.LoadX509KeyPair(common.CF.TLSServerPem, common.CF.TLSServerKey)
+	err := loadCert(common.CF().TLSServerPem, common.CF().TLSServerKey)
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
-	config := &tls.Config{Certificates: []tls.Certificate{cer}}
-	tsslServerSocket, err := thrift.NewTSSLServerSocket(fmt.Sprint(common.CF.Addr, ":", common.CF.TLSPort), config)
+	config := &tls.Config{GetCertificate: getCertificate}
+	tsslServerSocket, err := thrift.NewTSSLServerSocket(fmt.Sprint(common.CF().Addr, ":", common.CF().TLSPort), config)
 	if err != nil {
 		return
 	}
@@ -118,8 +127,9 @@ func tsslServer() {
 	if err != nil {
 		return
 	}
-	fmt.Println("tls server listen:", common.CF.TLSPort)
-	for {
+	fmt.Println("tls server listen:", common.CF().TLSPort)
+	addListener(tsslServerSocket)
+	for !IsClosing() {
 		client, err := tsslServerSocket.Accept()
 		if err == nil && client != nil {
 			go controllerHandler(client)
@@ -128,6 +138,20 @@ func tsslServer() {
 }
 
 func controllerHandler(tt thrift.TTransport) {
+	handleConn(tt, NewTimClient(tt), thrift.NewTCompactProtocol(tt))
+}
+
+/**
+ * 处理一个客户端连接 tcp tls websocket 共用
+ * tt 读取请求、写回执、关闭与心跳  client 推送信息  prot 读写请求的协议
+ */
+func handleConn(tt thrift.TTransport, client *protocol.ImClient, prot thrift.TProtocol) {
+	handlers.Add(1)
+	defer handlers.Done()
+	if IsClosing() {
+		tt.Close()
+		return
+	}
 	isclose := false
 	var gorutineclose *bool = &isclose
 	defer func() {
@@ -136,92 +160,60 @@ func controllerHandler(tt thrift.TTransport) {
 			*gorutineclose = true
 		}
 	}()
-	tu := &connect.TimUser{Client: NewTimClient(tt), OverLimit: 3, Fw: fw.CONNECT, IdCardNo: utils.TimeMills(), Sendflag: make(chan string, 0), Sync: new(sync.Mutex)}
+	tu := &connect.TimUser{Client: client, OverLimit: 3, Fw: fw.CONNECT, IdCardNo: utils.TimeMills(), Sendflag: make(chan string, 0), Sync: new(sync.Mutex), Bucket: rateLimit.NewBucket()}
+	tu.Touch()
+	tu.StartQueue()
 	connect.TP.AddConnect(tu)
 	defer func() {
 		if cluster.IsCluster() && tu.UserTid != nil {
 			loginname, err := connect.GetLoginName(tu.UserTid)
 			if loginname != "" && err == nil {
-				cluster.DelLoginnameFromCluter(loginname)
+				//本节点还有该用户(或该resource)的其他连接时保留集群中的登录信息
+				user, res := otherLocalConn(tu, loginname)
+				if !user {
+					cluster.DelLoginnameFromCluter(loginname)
+				}
+				if resource := tu.UserTid.GetResource(); resource != "" && !res {
+					cluster.DelResourceFromCluster(loginname, resource)
+				}
 			}
-			if common.CF.Presence == 1 {
+			if common.CF().Presence == 1 {
 				p := impl.OfflinePBean(tu.UserTid)
 				go clusterRoute.ClusterRoutePBean(p)
 			} else {
 				go route.RoutePBean(impl.OfflinePBean(tu.UserTid))
 			}
-		} else if tu.UserTid != nil && common.CF.Presence == 1 {
+		} else if tu.UserTid != nil && common.CF().Presence == 1 {
 			go route.RoutePBean(impl.OfflinePBean(tu.UserTid))
 		}
+		if tu.UserTid != nil {
+			go interceptor.Logout(tu.UserTid)
+			if tu.UserType == 0 {
+				presence.Logout(tu.UserTid)
+			}
+		}
 		connect.TP.DeleteTimUser(tu)
 	}()
 	defer func() { tt.Close() }()
 	monitorChan := make(chan string, 2)
-	heartbeat := common.CF.HeartBeat
-	if heartbeat == 0 {
-		heartbeat = 30 * 60
-	}
-	//	if heartbeat > 0 {
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				//				logger.Error(string(debug.Stack()))
-				logger.Error(err)
-			}
-		}()
-		defer func() {
-			if err := recover(); err != nil {
-			}
-			*gorutineclose = true
-			monitorChan <- "ping end"
-		}()
-		checkinCluster := 0
-		for {
-			if *gorutineclose {
-				break
-			}
-			for i := 0; i < heartbeat; i++ {
-				time.Sleep(1 * time.Second)
-				if *gorutineclose {
-					goto END
-				}
-				if tu.OverLimit <= 0 {
-					goto END
-				}
-				if tu.Fw == fw.CLOSE {
-					goto END
-				}
-				checkinCluster++
-				if checkinCluster >= common.ClusterConf.Keytimeout/3 {
-					checkinCluster = 0
-					if cluster.IsCluster() && tu.UserTid != nil {
-						loginname, err := connect.GetLoginName(tu.UserTid)
-						if loginname != "" && err == nil {
-							cluster.SetLoginnameToCluster(loginname)
-						}
-					}
-				}
-			}
-			if tu.Fw == fw.AUTH {
-				er := tu.Ping()
-				if er != nil {
-					logger.Error("ping err", er.Error())
-					panic("ping err")
-				}
-				tu.OverLimit--
-			} else {
+	hb := newHeartbeat(tt, tu)
+	defer hb.stop()
+	go TimProcessor(tt, prot, tu, gorutineclose, monitorChan)
+	<-monitorChan
+}
 
-				goto END
-			}
-			if tu.Fw == fw.CLOSE {
-				break
-			}
+/**本节点除tu以外 是否还有同一用户的连接、同一resource的连接*/
+func otherLocalConn(tu *connect.TimUser, loginname string) (user, resource bool) {
+	for _, other := range connect.TP.GetLoginUser(loginname) {
+		if other == tu {
+			continue
 		}
-	END:
-	}()
-	//	}
-	go TimProcessor(tt, tu, gorutineclose, monitorChan)
-	<-monitorChan
+		user = true
+		if other.UserTid != nil && other.UserTid.GetResource() == tu.UserTid.GetResource() {
+			resource = true
+		}
+	}
+	return
 }
 
 func NewTimClient(tt thrift.TTransport) *protocol.ImClient {
@@ -231,7 +223,7 @@ func NewTimClient(tt thrift.TTransport) *protocol.ImClient {
 	return protocol.NewITimClientFactory(useTransport, protocolFactory)
 }
 
-func TimProcessor(client thrift.TTransport, tu *connect.TimUser, gorutineclose *bool, monitorChan chan string) error {
+func TimProcessor(client thrift.TTransport, prot thrift.TProtocol, tu *connect.TimUser, gorutineclose *bool, monitorChan chan string) error {
 	defer func() {
 		if err := recover(); err != nil {
 			//			logger.Error(string(debug.Stack()))
@@ -247,12 +239,11 @@ func TimProcessor(client thrift.TTransport, tu *connect.TimUser, gorutineclose *
 		*gorutineclose = true
 		monitorChan <- "timProcessor end"
 	}()
-	compactprotocol := thrift.NewTCompactProtocol(client)
 	pub := strconv.Itoa(time.Now().Nanosecond())
-	handler := &impl.TimImpl{Pub: pub, Client: client, Tu: tu}
+	handler := &impl.TimImpl{Pub: pub, Client: client, Tu: tu, Ip: remoteIp(client)}
 	processor := protocol.NewITimProcessor(handler)
 	for {
-		ok, err := processor.Process(compactprotocol, compactprotocol)
+		ok, err := processor.Process(prot, prot)
 		if err, ok := err.(thrift.TTransportException); ok && err.TypeId() == thrift.END_OF_FILE {
 			return nil
 		} else if err != nil {
@@ -268,3 +259,13 @@ func TimProcessor(client thrift.TTransport, tu *connect.TimUser, gorutineclose *
 	}
 	return nil
 }
+
+/**客户端ip*/
+func remoteIp(tt thrift.TTransport) (ip string) {
+	if socket, ok := tt.(interface {
+		Addr() net.Addr
+	}); ok && socket.Addr() != nil {
+		ip, _, _ = net.SplitHostPort(socket.Addr().String())
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/fw"
+	"github.com/zhangjunfang/im/impl"
+	"github.com/zhangjunfang/im/timingWheel"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * 心跳 所有连接共用一个时间轮
+ * 未登录的连接 login.timeout 秒后断开
+ * 登录后每 HeartBeat 秒ping一次，连续 OverLimit 次没有确认时断开
+ * 连接的读写超时为 idle.timeout 秒(缺省 HeartBeat*4)，超时没有数据时断开
+ * 集群时由一个任务每 Keytimeout/3 秒批量续期本节点的登录信息
+ */
+var wheel = timingWheel.New(1*time.Second, 64, 4)
+var wheelOnce = new(sync.Once)
+
+func startWheel() {
+	wheelOnce.Do(func() {
+		wheel.Start()
+		if cluster.IsCluster() {
+			wheel.AfterFunc(refreshInterval(), refreshCluster)
+		}
+	})
+}
+
+func heartbeatInterval() time.Duration {
+	heartbeat := common.CF().HeartBeat
+	if heartbeat == 0 {
+		heartbeat = 30 * 60
+	}
+	return time.Duration(heartbeat) * time.Second
+}
+
+func refreshInterval() time.Duration {
+	interval := common.ClusterConf().Keytimeout / 3
+	if interval <= 0 {
+		interval = 1
+	}
+	return time.Duration(interval) * time.Second
+}
+
+/**批量续期本节点所有登录用户*/
+func refreshCluster() {
+	defer wheel.AfterFunc(refreshInterval(), refreshCluster)
+	users := make(map[string][]string, 0)
+	connect.TP.Range(func(tu *connect.TimUser) bool {
+		if tu.UserTid != nil && tu.Fw == fw.AUTH {
+			if loginname, err := connect.GetLoginName(tu.UserTid); loginname != "" && err == nil {
+				resources := users[loginname]
+				if resource := tu.UserTid.GetResource(); resource != "" {
+					resources = append(resources, resource)
+				}
+				users[loginname] = resources
+			}
+		}
+		return true
+	})
+	if err := cluster.RefreshToCluster(users); err != nil {
+		logger.Error("refresh cluster:", err.Error())
+	}
+}
+
+type heartbeat struct {
+	tt     thrift.TTransport
+	tu     *connect.TimUser
+	timer  *timingWheel.Timer
+	lock   *sync.Mutex
+	closed bool
+}
+
+func newHeartbeat(tt thrift.TTransport, tu *connect.TimUser) (h *heartbeat) {
+	h = &heartbeat{tt: tt, tu: tu, lock: new(sync.Mutex)}
+	idle := time.Duration(utils.Atoi(common.CF().GetKV("idle.timeout", "0"))) * time.Second
+	if idle <= 0 {
+		idle = heartbeatInterval() * 4
+	}
+	if socket, ok := tt.(interface {
+		SetTimeout(time.Duration) error
+	}); ok {
+		socket.SetTimeout(idle)
+	}
+	h.schedule(time.Duration(utils.Atoi(common.CF().GetKV("login.timeout", "60")))*time.Second, h.checkLogin)
+	return
+}
+
+func (this *heartbeat) schedule(d time.Duration, f func()) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.closed {
+		this.timer = wheel.AfterFunc(d, f)
+	}
+}
+
+func (this *heartbeat) stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closed = true
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+}
+
+/**断开连接 TimProcessor读取失败后结束*/
+func (this *heartbeat) close(reason string) {
+	logger.Debug("heartbeat close:", reason)
+	this.stop()
+	this.tu.Fw = fw.CLOSE
+	this.tt.Close()
+}
+
+func (this *heartbeat) checkLogin() {
+	if this.tu.Fw != fw.AUTH {
+		this.close("login timeout")
+		return
+	}
+	this.schedule(heartbeatInterval(), this.ping)
+}
+
+func (this *heartbeat) ping() {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+			this.close("ping error")
+		}
+	}()
+	tu := this.tu
+	if tu.Fw != fw.AUTH || tu.OverLimit <= 0 {
+		this.close("over limit")
+		return
+	}
+	if er := tu.Ping(); er != nil {
+		logger.Error("ping err", er.Error())
+		this.close("ping error")
+		return
+	}
+	tu.OverLimit--
+	impl.IdleAway(tu)
+	this.schedule(heartbeatInterval(), this.ping)
+}
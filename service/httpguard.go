@@ -0,0 +1,110 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/conf"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/httpSign"
+	"github.com/zhangjunfang/im/password"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * http接口的访问控制 每个接口按名字配置
+ * http.allow.<name>  允许访问的ip或CIDR，逗号分隔
+ *     未配置时 tim 使用 HttpAllowIps，api(由 admin.api.token 验证) ws poll 不限制，其他接口使用 auth.guard.adminIps(缺省本机)
+ * http.auth.<name>   none(缺省) key(请求头 X-Api-Key 为 http.apiKeys 之一) hmac(按 http.hmac.secret 签名，见 httpSign)
+ * 客户端ip只在请求来自 HttpTrustedProxies 时才从 X-Forwarded-For 中取
+ */
+func guard(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ipaddr := clientIp(r)
+		if !allowIp(name, ipaddr) {
+			logger.Warn(name, " forbidden ip:", ipaddr)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if !checkAuth(name, r) {
+			logger.Warn(name, " unauthorized ip:", ipaddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+/**客户端ip 来自可信代理时从右向左取 X-Forwarded-For 中第一个不是可信代理的地址*/
+func clientIp(r *http.Request) string {
+	ipaddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ipaddr = r.RemoteAddr
+	}
+	proxies := common.CF().HttpTrustedProxyList()
+	if !utils.MatchIp(ipaddr, proxies) {
+		return ipaddr
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		ipaddr = ip
+		if !utils.MatchIp(ip, proxies) {
+			break
+		}
+	}
+	return ipaddr
+}
+
+func allowIp(name, ip string) bool {
+	if allows := common.CF().GetKV("http.allow."+name, ""); allows != "" {
+		return utils.MatchIp(ip, conf.SplitList(allows))
+	}
+	switch name {
+	case "tim":
+		return daoService.AllowHttpIp(ip)
+	case "api", "ws", "poll":
+		return true
+	}
+	return isAdminIp(ip)
+}
+
+func isAdminIp(ip string) bool {
+	return utils.MatchIp(ip, conf.SplitList(common.CF().GetKV("auth.guard.adminIps", "127.0.0.1,::1")))
+}
+
+func checkAuth(name string, r *http.Request) bool {
+	switch common.CF().GetKV("http.auth."+name, "none") {
+	case "none":
+		return true
+	case "key":
+		key := r.Header.Get(httpSign.HEADER_KEY)
+		for _, k := range conf.SplitList(common.CF().GetKV("http.apiKeys", "")) {
+			if key != "" && password.Equal(key, k) {
+				return true
+			}
+		}
+	case "hmac":
+		//读出请求体用于签名，再放回给接口使用
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 100*1024*1024))
+		if err != nil {
+			return false
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		skew := time.Duration(utils.Atoi(common.CF().GetKV("http.hmac.skew", "300"))) * time.Second
+		return httpSign.Verify(r, common.CF().GetKV("http.hmac.secret", ""), body, skew)
+	default:
+		logger.Error("unknown http.auth.", name)
+	}
+	return false
+}
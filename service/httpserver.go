@@ -3,35 +3,42 @@ package service
 import (
 	"fmt"
 	"net/http"
-	_ "net/http/pprof"
 	"runtime/debug"
-	"strings"
 	"time"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/zhangjunfang/im/common"
-	"github.com/zhangjunfang/im/daoService"
 	"github.com/zhangjunfang/im/impl"
 	"github.com/zhangjunfang/im/protocol"
 )
 
 func Httpserver() {
-	if common.CF.GetHttpPort() <= 0 {
+	if common.CF().GetHttpPort() <= 0 {
 		return
 	}
-	http.HandleFunc("/tim", tim)
-	http.HandleFunc("/info", info)
-	http.HandleFunc("/uinfo", userInfo)
-	http.HandleFunc("/hi", hbaseclient)
+	//不使用 http.DefaultServeMux，pprof 只在管理端口开启
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tim", guard("tim", tim))
+	mux.HandleFunc("/info", guard("info", info))
+	mux.HandleFunc("/uinfo", guard("uinfo", userInfo))
+	mux.HandleFunc("/hi", guard("hi", hbaseclient))
+	mux.HandleFunc("/unlock", guard("unlock", unlock))
+	mux.HandleFunc("/reload", guard("reload", reload))
+	mux.HandleFunc("/api/", guard("api", api))
+	mux.HandleFunc("/ws", guard("ws", websocket))
+	mux.HandleFunc("/poll/", guard("poll", poll))
 	s := &http.Server{
-		Addr:           fmt.Sprint(":", common.CF.GetHttpPort()),
+		Addr:           fmt.Sprint(":", common.CF().GetHttpPort()),
+		Handler:        mux,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
-	s.ListenAndServe()
+	if addServer(s) {
+		s.ListenAndServe()
+	}
 }
 
 func tim(w http.ResponseWriter, r *http.Request) {
@@ -41,18 +48,10 @@ func tim(w http.ResponseWriter, r *http.Request) {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	X_Forwarded_For := r.Header.Get("X-Forwarded-For")
-	ss := strings.Split(r.RemoteAddr, ":")
-	ipaddr := ss[0]
-	if X_Forwarded_For != "" && X_Forwarded_For != "127.0.0.1" {
-		ipaddr = X_Forwarded_For
-	}
+	ipaddr := clientIp(r)
 	if r.ContentLength >= 100*1024*1024 {
 		return
 	}
-	if !daoService.AllowHttpIp(ipaddr) {
-		return
-	}
 	if "POST" == r.Method {
 		protocolFactory := thrift.NewTCompactProtocolFactory()
 		transport := thrift.NewStreamTransport(r.Body, w)
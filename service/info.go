@@ -5,11 +5,12 @@ import (
 	"io"
 	"net/http"
 	"runtime/debug"
-	"strings"
 
 	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/authGuard"
 	"github.com/zhangjunfang/im/connect"
 	"github.com/zhangjunfang/im/hbase"
+	"github.com/zhangjunfang/im/protocol"
 )
 
 func info(w http.ResponseWriter, r *http.Request) {
@@ -18,18 +19,13 @@ func info(w http.ResponseWriter, r *http.Request) {
 			logger.Error(debug.Stack())
 		}
 	}()
-	X_Forwarded_For := r.Header.Get("X-Forwarded-For")
-	ss := strings.Split(r.RemoteAddr, ":")
-	ipaddr := ss[0]
-	if X_Forwarded_For != "" && X_Forwarded_For != "127.0.0.1" {
-		ipaddr = X_Forwarded_For
-	}
-	logger.Debug("ip:", ipaddr)
+	logger.Debug("ip:", clientIp(r))
 	if r.ContentLength >= 2*1024*1024 {
 		return
 	}
 	str := fmt.Sprintln("user:", connect.TP.Len4P(), "===>", connect.TP.Len4PU())
 	io.WriteString(w, str)
+	io.WriteString(w, authGuard.Info())
 }
 
 func userInfo(w http.ResponseWriter, r *http.Request) {
@@ -38,13 +34,7 @@ func userInfo(w http.ResponseWriter, r *http.Request) {
 			logger.Error(debug.Stack())
 		}
 	}()
-	X_Forwarded_For := r.Header.Get("X-Forwarded-For")
-	ss := strings.Split(r.RemoteAddr, ":")
-	ipaddr := ss[0]
-	if X_Forwarded_For != "" && X_Forwarded_For != "127.0.0.1" {
-		ipaddr = X_Forwarded_For
-	}
-	logger.Debug("ip:", ipaddr)
+	logger.Debug("ip:", clientIp(r))
 	if r.ContentLength >= 2*1024*1024 {
 		return
 	}
@@ -57,15 +47,45 @@ func hbaseclient(w http.ResponseWriter, r *http.Request) {
 			logger.Error(debug.Stack())
 		}
 	}()
-	X_Forwarded_For := r.Header.Get("X-Forwarded-For")
-	ss := strings.Split(r.RemoteAddr, ":")
-	ipaddr := ss[0]
-	if X_Forwarded_For != "" && X_Forwarded_For != "127.0.0.1" {
-		ipaddr = X_Forwarded_For
-	}
-	logger.Debug("ip:", ipaddr)
+	logger.Debug("ip:", clientIp(r))
 	if r.ContentLength >= 2*1024*1024 {
 		return
 	}
 	io.WriteString(w, hbase.PrintPoolInfo())
 }
+
+/**
+ * 解锁被防暴力破解锁定的账号或ip
+ * /unlock?name=xxx&domain=xxx  或  /unlock?ip=xxx
+ * 缺省只允许 auth.guard.adminIps(逗号分隔，缺省本机) 访问，见 guard
+ */
+func unlock(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(debug.Stack())
+		}
+	}()
+	if name := r.FormValue("name"); name != "" {
+		tid := protocol.NewTid()
+		domain := r.FormValue("domain")
+		tid.Name, tid.Domain = name, &domain
+		authGuard.UnlockAccount(tid)
+	}
+	if ip := r.FormValue("ip"); ip != "" {
+		authGuard.UnlockIp(ip)
+	}
+	io.WriteString(w, "ok")
+}
+
+/**
+ * 重新加载 im.xml 与 cluster.xml，返回已生效与需要重启的配置
+ * 缺省只允许 auth.guard.adminIps 访问
+ */
+func reload(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(debug.Stack())
+		}
+	}()
+	io.WriteString(w, ReloadConf())
+}
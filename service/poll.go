@@ -0,0 +1,360 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+/**
+ * http长连接的替代方式(SSE 或 长轮询) HttpPort 上的 /poll/...，用于不能使用WebSocket的网络
+ * 与tcp连接相同的 ITim 服务(TJSONProtocol)，每个会话对应一个 TimUser，共用登录、心跳、连接池与路由
+ *   POST /poll/open                     新建会话 返回 {"sid":"..."}，之后与其他连接一样先 timStream timLogin
+ *   POST /poll/send?sid=                请求体为一条或多条thrift信息
+ *   GET  /poll/events?sid=&cursor=      服务器发给客户端的thrift信息(推送与返回值)，每条一个事件，id递增
+ *        请求头 Accept: text/event-stream 时为SSE，断线重连时按 Last-Event-ID 继续；否则为长轮询
+ *        长轮询没有新事件时最多等待 poll.wait 秒(缺省25)，返回 {"cursor":最后的id,"events":[{"id":1,"data":thrift信息}],"closed":false}
+ *        请求中的cursor(或Last-Event-ID)之前(含)的事件视为已收到并删除，写出的事件在确认前一直保留
+ *        每个会话最多保留 poll.buffer 条(缺省1000)，超过时关闭会话，没有写出的信息与其他连接断开时一样保存为离线信息
+ *   POST /poll/ack?sid=&cursor=         确认cursor之前(含)的事件，SSE连接不断开时用于确认
+ *   POST /poll/close?sid=               关闭会话，关闭后一分钟内仍可取剩下的事件
+ * poll.maxMessageSize 请求体最大字节数，缺省1M
+ */
+var pollSessions = make(map[string]*pollConn)
+var pollLock = new(sync.RWMutex)
+
+func poll(w http.ResponseWriter, r *http.Request) {
+	if IsClosing() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Path == "/poll/open" && r.Method == "POST" {
+		pollOpen(w, r)
+		return
+	}
+	pollLock.RLock()
+	pc := pollSessions[r.FormValue("sid")]
+	pollLock.RUnlock()
+	if pc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.URL.Path == "/poll/send" && r.Method == "POST":
+		if pc.isClosed() {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		pollSend(w, r, pc)
+	case r.URL.Path == "/poll/events" && r.Method == "GET":
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			pollStream(w, r, pc)
+		} else {
+			pollWait(w, r, pc)
+		}
+	case r.URL.Path == "/poll/ack" && r.Method == "POST":
+		pc.ack(pollCursor(r))
+	case r.URL.Path == "/poll/close" && r.Method == "POST":
+		pc.close()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func pollOpen(w http.ResponseWriter, r *http.Request) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	pc := newPollConn(hex.EncodeToString(bs), clientIp(r))
+	pf := thrift.NewTJSONProtocolFactory()
+	tt := pc.transport()
+	go handleConn(tt, protocol.NewITimClientFactory(pc.transport(), pf), pf.GetProtocol(tt))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"sid": pc.sid})
+}
+
+/**新建会话并登记*/
+func newPollConn(sid, ip string) *pollConn {
+	pc := &pollConn{sid: sid, addr: &net.TCPAddr{IP: net.ParseIP(ip)}, in: make(chan []byte, 16),
+		lock: new(sync.Mutex), notify: make(chan bool), closed: make(chan bool), closeOnce: new(sync.Once)}
+	pc.max = utils.Atoi(common.CF().GetKV("poll.buffer", "1000"))
+	pollLock.Lock()
+	pollSessions[pc.sid] = pc
+	pollLock.Unlock()
+	return pc
+}
+
+func pollSend(w http.ResponseWriter, r *http.Request, pc *pollConn) {
+	max := utils.Atoi(common.CF().GetKV("poll.maxMessageSize", "1048576"))
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(max)+1))
+	if err != nil || len(body) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(body) > max {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	select {
+	case pc.in <- body:
+		w.WriteHeader(http.StatusAccepted)
+	case <-pc.closed:
+		w.WriteHeader(http.StatusGone)
+	case <-time.After(10 * time.Second):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/**客户端确认的最后一个事件id*/
+func pollCursor(r *http.Request) int64 {
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.FormValue("cursor")
+	}
+	n, _ := strconv.ParseInt(cursor, 10, 64)
+	return n
+}
+
+func pollWait(w http.ResponseWriter, r *http.Request, pc *pollConn) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	cursor := pollCursor(r)
+	pc.ack(cursor)
+	events, notify := pc.after(cursor)
+	if len(events) == 0 {
+		wait := time.NewTimer(time.Duration(utils.Atoi(common.CF().GetKV("poll.wait", "25"))) * time.Second)
+		defer wait.Stop()
+		select {
+		case <-notify:
+			events, _ = pc.after(cursor)
+		case <-pc.closed:
+		case <-wait.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if len(events) > 0 {
+		cursor = events[len(events)-1].Id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cursor": cursor, "events": events, "closed": pc.isClosed() && len(events) == 0})
+}
+
+func pollStream(w http.ResponseWriter, r *http.Request, pc *pollConn) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	cursor := pollCursor(r)
+	pc.ack(cursor)
+	for {
+		events, notify := pc.after(cursor)
+		for _, e := range events {
+			fmt.Fprint(w, "id: ", e.Id, "\ndata: ", string(e.Data), "\n\n")
+			cursor = e.Id
+		}
+		if len(events) == 0 && pc.isClosed() {
+			io.WriteString(w, "event: close\ndata: \n\n")
+			rc.Flush()
+			return
+		}
+		//写出的事件等客户端确认(Last-Event-ID 或 /poll/ack)后删除，写出失败时重连可以再取
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-notify:
+		case <-pc.closed:
+		case <-keepalive.C:
+			io.WriteString(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+type pollEvent struct {
+	Id   int64           `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+/**一个会话 读取POST的请求，写出的每条信息为一个事件*/
+type pollConn struct {
+	sid       string
+	addr      net.Addr
+	in        chan []byte
+	rbuf      []byte
+	lock      *sync.Mutex
+	events    []pollEvent
+	seq       int64
+	max       int
+	notify    chan bool //有新事件时关闭并换新
+	timeout   int64     //读取超时 纳秒
+	closed    chan bool
+	closeOnce *sync.Once
+}
+
+/**读写请求与推送各用一个，写缓存分开，Flush时整条为一个事件*/
+func (this *pollConn) transport() *pollTransport {
+	return &pollTransport{pc: this, wbuf: new(bytes.Buffer)}
+}
+
+/**缓存已满(客户端长时间没有确认)时返回false 不丢弃已有的事件*/
+func (this *pollConn) append(data []byte) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.max > 0 && len(this.events) >= this.max {
+		return false
+	}
+	this.seq++
+	this.events = append(this.events, pollEvent{this.seq, json.RawMessage(data)})
+	close(this.notify)
+	this.notify = make(chan bool)
+	return true
+}
+
+/**删除客户端已收到的事件 即cursor之前(含)的事件*/
+func (this *pollConn) ack(cursor int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	i := 0
+	for i < len(this.events) && this.events[i].Id <= cursor {
+		i++
+	}
+	this.events = this.events[i:]
+}
+
+/**cursor之后的事件与新事件通知*/
+func (this *pollConn) after(cursor int64) (events []pollEvent, notify chan bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	events = make([]pollEvent, 0)
+	for _, e := range this.events {
+		if e.Id > cursor {
+			events = append(events, e)
+		}
+	}
+	return events, this.notify
+}
+
+func (this *pollConn) isClosed() bool {
+	select {
+	case <-this.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (this *pollConn) close() {
+	this.closeOnce.Do(func() {
+		close(this.closed)
+		//保留一段时间，客户端可以取完剩下的事件
+		wheel.AfterFunc(time.Minute, func() {
+			pollLock.Lock()
+			delete(pollSessions, this.sid)
+			pollLock.Unlock()
+		})
+	})
+}
+
+/**thrift.TTransport*/
+type pollTransport struct {
+	pc   *pollConn
+	wbuf *bytes.Buffer
+}
+
+func (this *pollTransport) Open() error {
+	return nil
+}
+
+func (this *pollTransport) IsOpen() bool {
+	return !this.pc.isClosed()
+}
+
+func (this *pollTransport) Close() error {
+	this.pc.close()
+	return nil
+}
+
+func (this *pollTransport) Read(p []byte) (n int, err error) {
+	pc := this.pc
+	if len(pc.rbuf) == 0 {
+		var timeout <-chan time.Time
+		if d := atomic.LoadInt64(&pc.timeout); d > 0 {
+			timer := time.NewTimer(time.Duration(d))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case pc.rbuf = <-pc.in:
+		case <-pc.closed:
+			return 0, thrift.NewTTransportExceptionFromError(io.EOF)
+		case <-timeout:
+			return 0, thrift.NewTTransportException(thrift.TIMED_OUT, "poll read timeout")
+		}
+	}
+	n = copy(p, pc.rbuf)
+	pc.rbuf = pc.rbuf[n:]
+	return
+}
+
+func (this *pollTransport) Write(p []byte) (int, error) {
+	return this.wbuf.Write(p)
+}
+
+func (this *pollTransport) Flush() error {
+	if this.wbuf.Len() == 0 {
+		return nil
+	}
+	if this.pc.isClosed() {
+		this.wbuf.Reset()
+		return thrift.NewTTransportException(thrift.NOT_OPEN, "poll session closed")
+	}
+	data := make([]byte, this.wbuf.Len())
+	copy(data, this.wbuf.Bytes())
+	this.wbuf.Reset()
+	if !this.pc.append(data) {
+		//按慢连接处理 关闭会话，写出失败的信息转为离线
+		logger.Warn("poll session ", this.pc.sid, " buffer full, close")
+		this.pc.close()
+		return thrift.NewTTransportException(thrift.NOT_OPEN, "poll buffer full")
+	}
+	return nil
+}
+
+func (this *pollTransport) RemainingBytes() uint64 {
+	return ^uint64(0)
+}
+
+/**客户端地址 供 remoteIp 使用*/
+func (this *pollTransport) Addr() net.Addr {
+	return this.pc.addr
+}
+
+/**心跳设置的超时 超过时间没有请求时断开*/
+func (this *pollTransport) SetTimeout(timeout time.Duration) error {
+	atomic.StoreInt64(&this.pc.timeout, int64(timeout))
+	return nil
+}
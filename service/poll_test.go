@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pollEmit(pc *pollConn, data string) {
+	tr := pc.transport()
+	tr.Write([]byte(data))
+	tr.Flush()
+}
+
+func Test_pollOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(poll))
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/poll/open", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r map[string]string
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil || r["sid"] == "" {
+		t.Fatal("open:", err, r)
+	}
+	pollLock.RLock()
+	pc := pollSessions[r["sid"]]
+	pollLock.RUnlock()
+	if pc == nil {
+		t.Fatal("session not found")
+	}
+	if resp, err = http.Post(server.URL+"/poll/close?sid="+pc.sid, "", nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("close:", err, resp)
+	}
+	if !pc.isClosed() {
+		t.Fatal("session not closed")
+	}
+	if resp, _ = http.Post(server.URL+"/poll/send?sid="+pc.sid, "", strings.NewReader("[]")); resp.StatusCode != http.StatusGone {
+		t.Fatal("send after close:", resp.StatusCode)
+	}
+}
+
+func Test_pollSend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(poll))
+	defer server.Close()
+	pc := newPollConn("test_send", "127.0.0.1")
+	defer pc.close()
+	resp, err := http.Post(server.URL+"/poll/send?sid="+pc.sid, "", strings.NewReader(`[1,"timStream",1,0,{}]`))
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatal("send:", err, resp)
+	}
+	buf := make([]byte, 64)
+	n, err := pc.transport().Read(buf)
+	if err != nil || string(buf[:n]) != `[1,"timStream",1,0,{}]` {
+		t.Fatalf("read %q %v", buf[:n], err)
+	}
+	if resp, _ = http.Post(server.URL+"/poll/send?sid=none", "", strings.NewReader("[]")); resp.StatusCode != http.StatusNotFound {
+		t.Fatal("unknown sid:", resp.StatusCode)
+	}
+}
+
+func Test_pollWait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(poll))
+	defer server.Close()
+	pc := newPollConn("test_wait", "127.0.0.1")
+	defer pc.close()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		pollEmit(pc, `[1,"timAck",2,0,{}]`)
+	}()
+	begin := time.Now()
+	resp, err := http.Get(server.URL + "/poll/events?sid=" + pc.sid + "&cursor=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Cursor int64
+		Events []pollEvent
+		Closed bool
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(begin) < 150*time.Millisecond {
+		t.Fatal("long poll did not wait")
+	}
+	if r.Cursor != 1 || len(r.Events) != 1 || string(r.Events[0].Data) != `[1,"timAck",2,0,{}]` || r.Closed {
+		t.Fatalf("events %+v", r)
+	}
+	//带上cursor后已收到的事件被删除
+	pollEmit(pc, `[1,"timAck",2,0,{"n":2}]`)
+	resp2, err := http.Get(server.URL + "/poll/events?sid=" + pc.sid + "&cursor=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	if err = json.NewDecoder(resp2.Body).Decode(&r); err != nil || r.Cursor != 2 || len(r.Events) != 1 || r.Events[0].Id != 2 {
+		t.Fatalf("events %+v %v", r, err)
+	}
+}
+
+/**读取SSE事件 直到收到n个事件*/
+func readSSE(t *testing.T, url, lastEventId string, n int) (ids []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequest("GET", url, nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("content type:", resp.Header.Get("Content-Type"))
+	}
+	br := bufio.NewReader(resp.Body)
+	for len(ids) < n {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimSpace(strings.TrimPrefix(line, "id: ")))
+		}
+	}
+	return
+}
+
+func Test_pollStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(poll))
+	defer server.Close()
+	pc := newPollConn("test_stream", "127.0.0.1")
+	defer pc.close()
+	url := server.URL + "/poll/events?sid=" + pc.sid
+	for i := 0; i < 3; i++ {
+		pollEmit(pc, `[1,"timAck",2,0,{}]`)
+	}
+	//从 Last-Event-ID 之后继续
+	if ids := readSSE(t, url, "1", 2); strings.Join(ids, ",") != "2,3" {
+		t.Fatal("resume:", ids)
+	}
+	//写出的事件在确认前保留
+	if events, _ := pc.after(0); len(events) != 2 {
+		t.Fatalf("events %+v", events)
+	}
+	if resp, err := http.Post(server.URL+"/poll/ack?sid="+pc.sid+"&cursor=3", "", nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("ack:", err, resp)
+	}
+	if events, _ := pc.after(0); len(events) != 0 {
+		t.Fatalf("not acked %+v", events)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pollEmit(pc, `[1,"timAck",2,0,{}]`)
+	}()
+	if ids := readSSE(t, url, "3", 1); strings.Join(ids, ",") != "4" {
+		t.Fatal("new event:", ids)
+	}
+}
+
+func Test_pollOverflow(t *testing.T) {
+	pc := newPollConn("test_overflow", "127.0.0.1")
+	defer pc.close()
+	pc.max = 2
+	tr := pc.transport()
+	for i := 0; i < 2; i++ {
+		tr.Write([]byte(`[1,"timAck",2,0,{}]`))
+		if err := tr.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//缓存满时关闭会话，已有的事件保留
+	tr.Write([]byte(`[1,"timAck",2,0,{}]`))
+	if err := tr.Flush(); err == nil || !pc.isClosed() {
+		t.Fatal("not closed:", err)
+	}
+	if events, _ := pc.after(0); len(events) != 2 || events[0].Id != 1 {
+		t.Fatalf("events %+v", events)
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/conf"
+)
+
+/**
+ * 热加载 SIGHUP 或 /reload 重新加载 im.xml 与 cluster.xml(包括环境变量与命令行配置)
+ * 可在运行时生效的配置直接替换，其他配置的变化只报告，重启后生效
+ * 配置文件中的KV 重新加载后立即生效，数据库中的KV 保留
+ */
+var imConfFile, clusterConfFile string
+var confFlags map[string]string
+var reloadLock = new(sync.Mutex)
+
+/**运行时可以生效的配置*/
+var reloadableConf = map[string]bool{"HeartBeat": true, "Presence": true, "ConfirmAck": true, "SingleClient": true, "MustAuth": true,
+	"LogLevel": true, "TLSServerPem": true, "TLSServerKey": true, "HttpAllowIps": true, "HttpTrustedProxies": true}
+var reloadableCluster = map[string]bool{"Keytimeout": true, "Interflow": true}
+
+/**含有密码的配置 报告中不输出值*/
+var secretConf = map[string]bool{"Db_dataSourceName": true, "RedisPwd": true, "Password": true}
+
+var tlsCert atomic.Value
+
+/**记录启动时使用的配置文件与命令行配置*/
+func SetConfFile(imconf, clusterconf string, flags map[string]string) {
+	imConfFile, clusterConfFile, confFlags = imconf, clusterconf, flags
+}
+
+func SetLogLevel(loglevel string) {
+	switch loglevel {
+	case "debug":
+		logger.SetLevel(logger.DEBUG)
+	case "info":
+		logger.SetLevel(logger.INFO)
+	case "warn":
+		logger.SetLevel(logger.WARN)
+	case "error":
+		logger.SetLevel(logger.ERROR)
+	default:
+		logger.SetLevel(logger.WARN)
+	}
+}
+
+func loadCert(pem, key string) (err error) {
+	cer, err := tls.LoadX509KeyPair(pem, key)
+	if err == nil {
+		tlsCert.Store(&cer)
+	}
+	return
+}
+
+/**tls握手时取当前证书，证书热加载后新连接使用新证书*/
+func getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cer, ok := tlsCert.Load().(*tls.Certificate); ok {
+		return cer, nil
+	}
+	return nil, errors.New("no certificate")
+}
+
+/**重新加载配置 返回已经生效的配置与需要重启的配置；配置有错误时不做任何修改*/
+func Reload() (applied, restart []string, err error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	cf := common.NewConf()
+	if err = cf.Load(imConfFile, confFlags); err != nil {
+		return
+	}
+	cc := common.NewClusterConf()
+	ok, err := cc.Load(clusterConfFile)
+	if err != nil {
+		return
+	}
+	if !ok {
+		//没有集群配置时集群配置不变
+		cc = nil
+	}
+	tlsEnable := common.CF().TLSPort > 0
+	if tlsEnable && cf.TLSServerPem != "" && cf.TLSServerKey != "" {
+		//先加载证书，失败时不替换配置
+		if err = loadCert(cf.TLSServerPem, cf.TLSServerKey); err != nil {
+			return
+		}
+		applied = append(applied, "TLS certificate")
+	}
+	//复制当前配置，修改副本后整体替换，正在读取旧配置的请求不受影响
+	var logLevel string
+	common.UpdateCF(func(old, next *conf.ConfBean) {
+		a, r := diffConf(old, next, cf, reloadableConf)
+		applied, restart = append(applied, a...), append(restart, r...)
+		//KV 只报告变化的key，值中可能有密码
+		for _, k := range next.ReloadFileKV(cf) {
+			applied = append(applied, fmt.Sprint("KV.", k, ": changed"))
+		}
+		if old.LogLevel != next.LogLevel {
+			logLevel = next.LogLevel
+		}
+	})
+	if cc != nil {
+		oldcc := common.ClusterConf()
+		nextcc := *oldcc
+		a, r := diffConf(oldcc, &nextcc, cc, reloadableCluster)
+		applied, restart = append(applied, a...), append(restart, r...)
+		common.SetClusterConf(&nextcc)
+	}
+	if logLevel != "" {
+		SetLogLevel(logLevel)
+	}
+	return
+}
+
+/**比较当前配置与加载的配置 可以生效的赋值到当前配置的副本next*/
+func diffConf(old, next, loaded interface{}, reloadable map[string]bool) (applied, restart []string) {
+	ov, xv, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem(), reflect.ValueOf(loaded).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		field := ov.Type().Field(i)
+		if field.Name == "KV" || field.PkgPath != "" || reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		desc := fmt.Sprint(field.Name, ": ", ov.Field(i).Interface(), " -> ", nv.Field(i).Interface())
+		if secretConf[field.Name] {
+			desc = fmt.Sprint(field.Name, ": changed")
+		}
+		if reloadable[field.Name] {
+			xv.Field(i).Set(nv.Field(i))
+			applied = append(applied, desc)
+		} else {
+			restart = append(restart, desc)
+		}
+	}
+	return
+}
+
+/**重新加载并记录结果*/
+func ReloadConf() string {
+	applied, restart, err := Reload()
+	if err != nil {
+		logger.Error("reload failed:", err.Error())
+		return fmt.Sprintln("reload failed:", err.Error())
+	}
+	report := fmt.Sprint("applied:\n", strings.Join(applied, "\n"), "\nrestart required:\n", strings.Join(restart, "\n"), "\n")
+	logger.Warn("reload ", report)
+	return report
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zhangjunfang/im/conf"
+)
+
+func Test_diffConf(t *testing.T) {
+	old := &conf.ConfBean{HeartBeat: 30, Db_dataSourceName: "root:old@tcp(127.0.0.1:3306)/im"}
+	loaded := &conf.ConfBean{HeartBeat: 60, Db_dataSourceName: "root:new@tcp(127.0.0.1:3306)/im"}
+	next := *old
+	applied, restart := diffConf(old, &next, loaded, reloadableConf)
+	if len(applied) != 1 || next.HeartBeat != 60 || old.HeartBeat != 30 {
+		t.Fatal("applied:", applied, next.HeartBeat, old.HeartBeat)
+	}
+	//密码不出现在报告中
+	if len(restart) != 1 || strings.Contains(restart[0], "old") || strings.Contains(restart[0], "new") {
+		t.Fatal("restart:", restart)
+	}
+}
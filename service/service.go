@@ -13,7 +13,7 @@ func Start() {
 		if err := recover(); err != nil {
 		}
 	}()
-	service := fmt.Sprint(common.CF.Addr, ":", common.CF.Port)
+	service := fmt.Sprint(common.CF().Addr, ":", common.CF().Port)
 	tcpAddr, err := net.ResolveTCPAddr("tcp4", service)
 	checkError(err)
 	listener, err := net.ListenTCP("tcp", tcpAddr)
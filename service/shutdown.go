@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/cluster"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/connect"
+	"github.com/zhangjunfang/im/fw"
+	"github.com/zhangjunfang/im/hbase"
+	"github.com/zhangjunfang/im/myDb"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+var closing int32
+var handlers = new(sync.WaitGroup)
+var listeners = make([]thrift.TServerTransport, 0)
+var listenerLock = new(sync.Mutex)
+var servers = make([]*http.Server, 0)
+
+func IsClosing() bool {
+	return atomic.LoadInt32(&closing) == 1
+}
+
+func addListener(l thrift.TServerTransport) {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+	listeners = append(listeners, l)
+}
+
+/**已经开始退出时返回false，不再启动*/
+func addServer(s *http.Server) bool {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+	if IsClosing() {
+		return false
+	}
+	servers = append(servers, s)
+	return true
+}
+
+/**
+ * 优雅退出 在 shutdown.timeout 秒(缺省30)内完成
+ * 1.停止接受新连接(http与管理端口等待进行中的请求结束) 2.通知客户端重连 3.发送队列写出 4.断开连接(删除集群中的登录信息)
+ * 5.未投递的信息保存为离线 6.发送interflow缓存 7.关闭hbase与mysql连接池
+ */
+func Shutdown() {
+	if !atomic.CompareAndSwapInt32(&closing, 0, 1) {
+		return
+	}
+	timeout := time.Duration(utils.Atoi(common.CF().GetKV("shutdown.timeout", "30"))) * time.Second
+	deadline := time.Now().Add(timeout)
+	logger.Warn("shutdown begin, connections:", connect.TP.Len4P())
+
+	listenerLock.Lock()
+	for _, l := range listeners {
+		l.Close()
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	httpDone := new(sync.WaitGroup)
+	for _, s := range servers {
+		httpDone.Add(1)
+		//长轮询等连接在后面断开时结束，不在这里等待
+		go func(s *http.Server) {
+			defer httpDone.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				logger.Warn("shutdown http server:", s.Addr, " ", err.Error())
+			}
+		}(s)
+	}
+	listenerLock.Unlock()
+
+	ack := protocol.NewTimAckBean()
+	status, acktype := "503", "shutdown"
+	ack.AckStatus, ack.AckType = &status, &acktype
+	if addr := common.CF().GetKV("shutdown.redirect", ""); addr != "" {
+		ack.ExtraMap = map[string]string{"addr": addr}
+	}
+	wg := new(sync.WaitGroup)
+	connect.TP.Range(func(tu *connect.TimUser) bool {
+		if tu.Fw == fw.AUTH && tu.UserType == 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tu.SendAckBean(ack)
+				tu.Flush(deadline.Sub(time.Now()))
+			}()
+		}
+		return true
+	})
+	if !waitTimeout(wg, deadline.Sub(time.Now())) {
+		logger.Warn("shutdown: flush overtime")
+	}
+
+	connect.TP.Range(func(tu *connect.TimUser) bool {
+		tu.Fw = fw.CLOSE
+		tu.Close()
+		return true
+	})
+	if !waitTimeout(handlers, deadline.Sub(time.Now())) {
+		logger.Warn("shutdown: close connections overtime")
+	}
+	if !connect.WaitQueues(deadline.Sub(time.Now())) {
+		logger.Warn("shutdown: save undelivered overtime")
+	}
+	if !waitTimeout(httpDone, deadline.Sub(time.Now())) {
+		logger.Warn("shutdown: http servers overtime")
+	}
+	if cluster.IsCluster() && !cluster.FlushInterflow(deadline.Sub(time.Now())) {
+		logger.Warn("shutdown: interflow overtime")
+	}
+	if common.CF().DataBase == 1 {
+		hbase.Close()
+	}
+	myDb.Close()
+	logger.Warn("shutdown end")
+}
+
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
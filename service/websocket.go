@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/conf"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+	"github.com/zhangjunfang/im/webSocket"
+)
+
+/**
+ * WebSocket连接 HttpPort 上的 /ws，供浏览器使用
+ * 与tcp连接相同的 ITim 服务，共用 TimUser、连接池、心跳与路由，每条thrift信息一帧
+ * ?protocol=json 时使用 TJSONProtocol 与文本帧(thrift.js)，缺省 TCompactProtocol 与二进制帧
+ * ws.origins 允许的Origin，逗号分隔，为空时不检查；ws.maxMessageSize 信息最大字节数，缺省1M
+ */
+func websocket(w http.ResponseWriter, r *http.Request) {
+	if IsClosing() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if !allowOrigin(r.Header.Get("Origin")) {
+		logger.Warn("websocket forbidden origin:", r.Header.Get("Origin"))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var pf thrift.TProtocolFactory = thrift.NewTCompactProtocolFactory()
+	opcode := webSocket.BINARY
+	if r.FormValue("protocol") == "json" {
+		pf, opcode = thrift.NewTJSONProtocolFactory(), webSocket.TEXT
+	}
+	conn, err := webSocket.Upgrade(w, r, "")
+	if err != nil {
+		logger.Warn("websocket:", err.Error())
+		return
+	}
+	conn.MaxSize = int64(utils.Atoi(common.CF().GetKV("ws.maxMessageSize", "1048576")))
+	ws := &wsConn{conn: conn, opcode: opcode, addr: &net.TCPAddr{IP: net.ParseIP(clientIp(r))}}
+	tt := ws.transport()
+	handleConn(tt, protocol.NewITimClientFactory(ws.transport(), pf), pf.GetProtocol(tt))
+}
+
+func allowOrigin(origin string) bool {
+	origins := conf.SplitList(common.CF().GetKV("ws.origins", ""))
+	for _, o := range origins {
+		if o == origin {
+			return true
+		}
+	}
+	return len(origins) == 0
+}
+
+type wsConn struct {
+	conn    *webSocket.Conn
+	opcode  int
+	addr    net.Addr
+	timeout int64 //读写超时 纳秒
+}
+
+/**读写请求与推送各用一个，写缓存分开，Flush时整条写为一帧*/
+func (this *wsConn) transport() *wsTransport {
+	return &wsTransport{ws: this, wbuf: new(bytes.Buffer)}
+}
+
+func (this *wsConn) deadline() (t time.Time) {
+	if timeout := atomic.LoadInt64(&this.timeout); timeout > 0 {
+		t = time.Now().Add(time.Duration(timeout))
+	}
+	return
+}
+
+/**thrift.TTransport*/
+type wsTransport struct {
+	ws   *wsConn
+	rbuf []byte
+	wbuf *bytes.Buffer
+}
+
+func (this *wsTransport) Open() error {
+	return nil
+}
+
+func (this *wsTransport) IsOpen() bool {
+	return !this.ws.conn.IsClosed()
+}
+
+func (this *wsTransport) Close() error {
+	return this.ws.conn.Close()
+}
+
+func (this *wsTransport) Read(p []byte) (n int, err error) {
+	for len(this.rbuf) == 0 {
+		this.ws.conn.SetReadDeadline(this.ws.deadline())
+		if _, this.rbuf, err = this.ws.conn.ReadMessage(); err != nil {
+			return 0, thrift.NewTTransportExceptionFromError(err)
+		}
+	}
+	n = copy(p, this.rbuf)
+	this.rbuf = this.rbuf[n:]
+	return
+}
+
+func (this *wsTransport) Write(p []byte) (int, error) {
+	return this.wbuf.Write(p)
+}
+
+func (this *wsTransport) Flush() error {
+	if this.wbuf.Len() == 0 {
+		return nil
+	}
+	defer this.wbuf.Reset()
+	this.ws.conn.SetWriteDeadline(this.ws.deadline())
+	if err := this.ws.conn.WriteMessage(this.ws.opcode, this.wbuf.Bytes()); err != nil {
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	return nil
+}
+
+func (this *wsTransport) RemainingBytes() uint64 {
+	return ^uint64(0)
+}
+
+/**客户端地址 供 remoteIp 使用*/
+func (this *wsTransport) Addr() net.Addr {
+	return this.ws.addr
+}
+
+/**心跳设置的读写超时*/
+func (this *wsTransport) SetTimeout(timeout time.Duration) error {
+	atomic.StoreInt64(&this.ws.timeout, int64(timeout))
+	return nil
+}
@@ -1,12 +1,16 @@
 package tfClient
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"runtime/debug"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/httpSign"
 	"github.com/zhangjunfang/im/protocol"
 )
 
@@ -54,6 +58,76 @@ func HttpClient2(f func(*protocol.ImClient) error, user_auth_url string) (err er
 	return
 }
 
+/**
+ * 带 api key 与签名的http调用 apiKey secret 为空时不设置
+ * 请求先写入内存，读取结果时签名并发送
+ */
+func SignedHttpClient(f func(*protocol.ImClient) error, urlstr, apiKey, secret string) (err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			err = errors.New(fmt.Sprint(er))
+			logger.Error(er)
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	if urlstr == "" {
+		return errors.New("httpclient url is null")
+	}
+	resp := &signedResponse{url: urlstr, apiKey: apiKey, secret: secret}
+	transport := thrift.NewStreamTransport(resp, &resp.req)
+	defer resp.Close()
+	imClient := protocol.NewITimClientFactory(transport, thrift.NewTCompactProtocolFactory())
+	return f(imClient)
+}
+
+type signedResponse struct {
+	url    string
+	apiKey string
+	secret string
+	req    bytes.Buffer
+	body   io.ReadCloser
+}
+
+func (this *signedResponse) Read(p []byte) (n int, err error) {
+	if this.body == nil {
+		if err = this.post(); err != nil {
+			return
+		}
+	}
+	return this.body.Read(p)
+}
+
+func (this *signedResponse) post() error {
+	body := this.req.Bytes()
+	r, err := http.NewRequest("POST", this.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-thrift")
+	if this.apiKey != "" {
+		r.Header.Set(httpSign.HEADER_KEY, this.apiKey)
+	}
+	if this.secret != "" {
+		httpSign.SignRequest(r, this.secret, body)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return errors.New(resp.Status)
+	}
+	this.body = resp.Body
+	return nil
+}
+
+func (this *signedResponse) Close() {
+	if this.body != nil {
+		this.body.Close()
+	}
+}
+
 func TcpClient(f func(*protocol.ImClient), urlstr string) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -7,6 +7,8 @@ import (
 	"github.com/donnie4w/go-logger/logger"
 	. "github.com/zhangjunfang/im/common"
 	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/utils"
+	"github.com/zhangjunfang/im/wordFilter"
 )
 
 func TickerStart() {
@@ -16,7 +18,10 @@ func TickerStart() {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	go Ticker4Second(CF.GetConfLoad(600), daoService.AddConf)
+	go Ticker4Second(CF().GetConfLoad(600), daoService.AddConf)
+	if reload := utils.Atoi(CF().GetKV("filter.reload", "60")); reload > 0 {
+		go Ticker4Second(reload, wordFilter.Reload)
+	}
 }
 
 //每个几秒执行一次function函数
@@ -0,0 +1,154 @@
+/**
+ * 分层时间轮
+ * 所有定时任务共用一个协程推进，第0层每格为一个tick，第i层每格为 size^i 个tick
+ * 高层的任务在所在格到期时降到低层，最高层放不下的任务先放在最远的格中，降层时重新计算
+ */
+package timingWheel
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+)
+
+type Timer struct {
+	expire  int64
+	task    func()
+	bucket  map[*Timer]bool
+	stopped int32
+	tw      *TimingWheel
+}
+
+/**取消任务 任务已经执行或已经取消时返回false*/
+func (this *Timer) Stop() bool {
+	if !atomic.CompareAndSwapInt32(&this.stopped, 0, 1) {
+		return false
+	}
+	this.tw.lock.Lock()
+	defer this.tw.lock.Unlock()
+	if this.bucket != nil {
+		delete(this.bucket, this)
+		this.bucket = nil
+	}
+	return true
+}
+
+type TimingWheel struct {
+	tick   time.Duration
+	size   int64
+	levels [][]map[*Timer]bool
+	spans  []int64 //每层一格的tick数
+	now    int64
+	lock   *sync.Mutex
+	quit   chan bool
+	once   *sync.Once
+}
+
+/**tick 每格时长  size 每层格数  level 层数*/
+func New(tick time.Duration, size, level int) *TimingWheel {
+	tw := &TimingWheel{tick: tick, size: int64(size), lock: new(sync.Mutex), quit: make(chan bool), once: new(sync.Once)}
+	tw.levels = make([][]map[*Timer]bool, level)
+	tw.spans = make([]int64, level)
+	span := int64(1)
+	for i := 0; i < level; i++ {
+		tw.levels[i] = make([]map[*Timer]bool, size)
+		for j := 0; j < size; j++ {
+			tw.levels[i][j] = make(map[*Timer]bool)
+		}
+		tw.spans[i] = span
+		span *= int64(size)
+	}
+	return tw
+}
+
+func (this *TimingWheel) Start() {
+	this.once.Do(func() { go this.run() })
+}
+
+func (this *TimingWheel) Stop() {
+	close(this.quit)
+}
+
+/**d之后在新协程中执行f，d不足一个tick时在下一个tick执行*/
+func (this *TimingWheel) AfterFunc(d time.Duration, f func()) *Timer {
+	ticks := int64(d / this.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	t := &Timer{expire: this.now + ticks, task: f, tw: this}
+	this.add(t)
+	return t
+}
+
+func (this *TimingWheel) add(t *Timer) {
+	delta := t.expire - this.now
+	top := len(this.levels) - 1
+	for i := 0; i <= top; i++ {
+		if delta < this.spans[i]*this.size || i == top {
+			expire := t.expire
+			if i == top && delta >= this.spans[i]*this.size {
+				expire = this.now + this.spans[i]*this.size - 1
+			}
+			t.bucket = this.levels[i][(expire/this.spans[i])%this.size]
+			t.bucket[t] = true
+			return
+		}
+	}
+}
+
+func (this *TimingWheel) run() {
+	ticker := time.NewTicker(this.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			this.advance()
+		case <-this.quit:
+			return
+		}
+	}
+}
+
+func (this *TimingWheel) advance() {
+	this.lock.Lock()
+	this.now++
+	//从高层开始降层
+	for i := len(this.levels) - 1; i > 0; i-- {
+		if this.now%this.spans[i] == 0 {
+			bucket := this.levels[i][(this.now/this.spans[i])%this.size]
+			for t, _ := range bucket {
+				delete(bucket, t)
+				this.add(t)
+			}
+		}
+	}
+	bucket := this.levels[0][this.now%this.size]
+	tasks := make([]func(), 0, len(bucket))
+	for t, _ := range bucket {
+		delete(bucket, t)
+		if t.expire > this.now {
+			this.add(t)
+			continue
+		}
+		t.bucket = nil
+		if atomic.CompareAndSwapInt32(&t.stopped, 0, 1) {
+			tasks = append(tasks, t.task)
+		}
+	}
+	this.lock.Unlock()
+	for _, task := range tasks {
+		go func(task func()) {
+			defer func() {
+				if err := recover(); err != nil {
+					logger.Error(string(debug.Stack()))
+				}
+			}()
+			task()
+		}(task)
+	}
+}
@@ -0,0 +1,32 @@
+package timingWheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_wheel(t *testing.T) {
+	tw := New(time.Millisecond, 4, 3)
+	var fired, stopped int32
+	tw.AfterFunc(3*time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+	tw.AfterFunc(30*time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+	//超过最高层范围
+	tw.AfterFunc(100*time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+	timer := tw.AfterFunc(20*time.Millisecond, func() { atomic.AddInt32(&stopped, 1) })
+	if !timer.Stop() {
+		t.Fatal("stop fail")
+	}
+	for i := 0; i < 99; i++ {
+		tw.advance()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 2 {
+		t.Fatal("fired before 100 ticks:", n)
+	}
+	tw.advance()
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 3 || atomic.LoadInt32(&stopped) != 0 {
+		t.Fatal("fired:", n, " stopped:", stopped)
+	}
+}
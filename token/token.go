@@ -0,0 +1,248 @@
+/**
+ * 签名令牌登陆验证
+ * 令牌格式与JWT一致: base64url(header).base64url(claims).base64url(signature)
+ * 支持 HS256 HS384 HS512 RS256 RS384 RS512 ，服务端本地验证，不需要回调业务系统
+ */
+package token
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/myMap"
+	"github.com/zhangjunfang/im/utils"
+)
+
+var (
+	ErrFormat    = errors.New("token format error")
+	ErrAlg       = errors.New("token alg not support")
+	ErrKey       = errors.New("token key not found")
+	ErrSignature = errors.New("token signature error")
+	ErrExpired   = errors.New("token expired")
+	ErrNotBefore = errors.New("token not valid yet")
+	ErrIssuer    = errors.New("token issuer error")
+	ErrSubject   = errors.New("token name or domain error")
+)
+
+/**令牌头*/
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+/**令牌内容 sub:用户名 dom:域名*/
+type Claims struct {
+	Sub string `json:"sub"`
+	Dom string `json:"dom"`
+	Iss string `json:"iss,omitempty"`
+	Exp int64  `json:"exp"`
+	Nbf int64  `json:"nbf,omitempty"`
+	Iat int64  `json:"iat,omitempty"`
+}
+
+// 解析过的rsa公钥 key:配置值 value:*rsa.PublicKey
+var rsaKeys = myMap.NewHashTable()
+
+/**是否开启令牌验证 tim_property: auth.token.enable=1*/
+func IsEnable() bool {
+	return common.CF().GetKV("auth.token.enable", "0") == "1"
+}
+
+/**pwd 是否为令牌格式*/
+func IsToken(pwd string) bool {
+	return strings.Count(pwd, ".") == 2 && len(pwd) > 16
+}
+
+/**验证令牌，并校验令牌中的用户名与域名*/
+func Verify(tokenstr, name, domain string) (claims *Claims, err error) {
+	claims, err = Parse(tokenstr)
+	if err != nil {
+		return
+	}
+	if claims.Sub != name || claims.Dom != domain {
+		return nil, ErrSubject
+	}
+	return
+}
+
+/**解析并验证令牌签名与时间*/
+func Parse(tokenstr string) (claims *Claims, err error) {
+	defer func() {
+		if er := recover(); er != nil {
+			claims, err = nil, errors.New(fmt.Sprint("token parse error:", er))
+		}
+	}()
+	parts := strings.Split(tokenstr, ".")
+	if len(parts) != 3 {
+		return nil, ErrFormat
+	}
+	header := new(Header)
+	if err = decodeSegment(parts[0], header); err != nil {
+		return nil, ErrFormat
+	}
+	sig, er := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	if er != nil {
+		return nil, ErrFormat
+	}
+	if err = verifySignature(header, fmt.Sprint(parts[0], ".", parts[1]), sig); err != nil {
+		return nil, err
+	}
+	claims = new(Claims)
+	if err = decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrFormat
+	}
+	now := time.Now().Unix()
+	leeway := int64(leeway())
+	if claims.Exp <= 0 || now > claims.Exp+leeway {
+		return nil, ErrExpired
+	}
+	if claims.Nbf > 0 && now+leeway < claims.Nbf {
+		return nil, ErrNotBefore
+	}
+	if issuer := common.CF().GetKV("auth.token.issuer", ""); issuer != "" && issuer != claims.Iss {
+		return nil, ErrIssuer
+	}
+	return
+}
+
+/**生成HS系列令牌 主要用于测试与管理工具*/
+func Sign(alg, kid string, claims *Claims, secret []byte) (tokenstr string, err error) {
+	h := hashFunc(alg)
+	if h == nil || !strings.HasPrefix(alg, "HS") {
+		return "", ErrAlg
+	}
+	hb, _ := json.Marshal(&Header{Alg: alg, Kid: kid, Typ: "JWT"})
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return
+	}
+	signing := fmt.Sprint(base64.RawURLEncoding.EncodeToString(hb), ".", base64.RawURLEncoding.EncodeToString(cb))
+	mac := hmac.New(h, secret)
+	mac.Write([]byte(signing))
+	tokenstr = fmt.Sprint(signing, ".", base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	return
+}
+
+func verifySignature(header *Header, signing string, sig []byte) (err error) {
+	h := hashFunc(header.Alg)
+	if h == nil {
+		return ErrAlg
+	}
+	switch header.Alg[:2] {
+	case "HS":
+		secret := keyValue("auth.token.key", header.Kid)
+		if secret == "" {
+			return ErrKey
+		}
+		mac := hmac.New(h, []byte(secret))
+		mac.Write([]byte(signing))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ErrSignature
+		}
+	case "RS":
+		pub, er := rsaPublicKey(keyValue("auth.token.rsakey", header.Kid))
+		if er != nil {
+			return er
+		}
+		hasher := h()
+		hasher.Write([]byte(signing))
+		if rsa.VerifyPKCS1v15(pub, cryptoHash(header.Alg), hasher.Sum(nil), sig) != nil {
+			return ErrSignature
+		}
+	default:
+		return ErrAlg
+	}
+	return
+}
+
+/**密钥轮换: 令牌头中有kid时使用 keyword.kid 对应的密钥，否则使用 keyword*/
+func keyValue(keyword, kid string) string {
+	if kid != "" {
+		return common.CF().GetKV(fmt.Sprint(keyword, ".", kid), "")
+	}
+	return common.CF().GetKV(keyword, "")
+}
+
+/**配置值可以是pem内容，也可以是pem文件路径*/
+func rsaPublicKey(value string) (pub *rsa.PublicKey, err error) {
+	if value == "" {
+		return nil, ErrKey
+	}
+	if k := rsaKeys.Get(value); k != nil {
+		return k.(*rsa.PublicKey), nil
+	}
+	bs := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		if bs, err = ioutil.ReadFile(value); err != nil {
+			return
+		}
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, ErrKey
+	}
+	var key interface{}
+	if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		cert, er := x509.ParseCertificate(block.Bytes)
+		if er != nil {
+			return nil, err
+		}
+		key, err = cert.PublicKey, nil
+	}
+	var ok bool
+	if pub, ok = key.(*rsa.PublicKey); !ok {
+		return nil, ErrKey
+	}
+	rsaKeys.Put(value, pub)
+	return
+}
+
+func hashFunc(alg string) func() hash.Hash {
+	switch alg {
+	case "HS256", "RS256":
+		return sha256.New
+	case "HS384", "RS384":
+		return sha512.New384
+	case "HS512", "RS512":
+		return sha512.New
+	}
+	return nil
+}
+
+func cryptoHash(alg string) crypto.Hash {
+	switch alg {
+	case "RS384":
+		return crypto.SHA384
+	case "RS512":
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+/**允许的时间误差 秒*/
+func leeway() int {
+	return utils.Atoi(common.CF().GetKV("auth.token.leeway", "30"))
+}
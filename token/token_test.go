@@ -0,0 +1,33 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhangjunfang/im/common"
+)
+
+func Test_verify(t *testing.T) {
+	common.SetKV(map[string]string{"auth.token.key": "secret", "auth.token.key.k2": "secret2"})
+	claims := &Claims{Sub: "wu", Dom: "tim.com", Exp: time.Now().Unix() + 60}
+	tokenstr, _ := Sign("HS256", "", claims, []byte("secret"))
+	if _, err := Verify(tokenstr, "wu", "tim.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(tokenstr, "dong", "tim.com"); err != ErrSubject {
+		t.Fatal("subject:", err)
+	}
+	tokenstr, _ = Sign("HS512", "k2", claims, []byte("secret2"))
+	if _, err := Verify(tokenstr, "wu", "tim.com"); err != nil {
+		t.Fatal("kid:", err)
+	}
+	tokenstr, _ = Sign("HS256", "k2", claims, []byte("secret"))
+	if _, err := Verify(tokenstr, "wu", "tim.com"); err != ErrSignature {
+		t.Fatal("signature:", err)
+	}
+	claims.Exp = time.Now().Unix() - 3600
+	tokenstr, _ = Sign("HS256", "", claims, []byte("secret"))
+	if _, err := Verify(tokenstr, "wu", "tim.com"); err != ErrExpired {
+		t.Fatal("expired:", err)
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"net"
+	"strings"
+)
+
+/**ip是否在列表中 列表项为ip或CIDR(如10.0.0.0/8)*/
+func MatchIp(ip string, list []string) bool {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return false
+	}
+	for _, item := range list {
+		if strings.Contains(item, "/") {
+			if _, ipnet, err := net.ParseCIDR(item); err == nil && ipnet.Contains(addr) {
+				return true
+			}
+		} else if allow := net.ParseIP(item); allow != nil && allow.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"testing"
+)
+
+func Test_matchIp(t *testing.T) {
+	list := []string{"127.0.0.1", "10.0.0.0/8", "fd00::/8"}
+	for ip, want := range map[string]bool{"127.0.0.1": true, "10.2.3.4": true, "fd00::1": true, "11.0.0.1": false, "x": false, "::1": false} {
+		if MatchIp(ip, list) != want {
+			t.Fatal(ip, " want ", want)
+		}
+	}
+}
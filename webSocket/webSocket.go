@@ -0,0 +1,237 @@
+/**
+ * WebSocket服务端(RFC 6455)
+ * 只实现服务端需要的部分：握手，读取整条信息(合并分片，自动回复ping与close)，整条信息写为一帧
+ */
+package webSocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CONTINUATION = 0
+	TEXT         = 1
+	BINARY       = 2
+	CLOSE        = 8
+	PING         = 9
+	PONG         = 10
+)
+
+const acceptGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrHandshake = errors.New("websocket: bad handshake")
+var ErrProtocol = errors.New("websocket: protocol error")
+var ErrTooLarge = errors.New("websocket: message too large")
+
+type Conn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	wlock   *sync.Mutex
+	closed  int32
+	MaxSize int64 //信息最大长度
+}
+
+/**Sec-WebSocket-Accept*/
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGuid))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+/**握手 成功后接管http连接 subprotocol为选中的子协议，为空时不返回*/
+func Upgrade(w http.ResponseWriter, r *http.Request, subprotocol string) (*Conn, error) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if r.Method != "GET" || key == "" || r.Header.Get("Sec-Websocket-Version") != "13" ||
+		!headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return nil, ErrHandshake
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: http.Hijacker not supported")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	//去掉http.Server设置的超时
+	conn.SetDeadline(time.Time{})
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + AcceptKey(key) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	if _, err = conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, br: rw.Reader, wlock: new(sync.Mutex), MaxSize: 16 << 20}, nil
+}
+
+func headerContains(header http.Header, name, value string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/**读取一条信息 对方关闭时返回io.EOF*/
+func (this *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	opcode = -1
+	for {
+		fin, op, payload, er := this.readFrame()
+		if er != nil {
+			return 0, nil, er
+		}
+		switch op {
+		case PING:
+			if er = this.WriteMessage(PONG, payload); er != nil {
+				return 0, nil, er
+			}
+			continue
+		case PONG:
+			continue
+		case CLOSE:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			this.WriteMessage(CLOSE, payload)
+			this.close()
+			return 0, nil, io.EOF
+		case CONTINUATION:
+			if opcode < 0 {
+				return 0, nil, ErrProtocol
+			}
+		case TEXT, BINARY:
+			if opcode >= 0 {
+				return 0, nil, ErrProtocol
+			}
+			opcode = op
+		default:
+			return 0, nil, ErrProtocol
+		}
+		data = append(data, payload...)
+		if int64(len(data)) > this.MaxSize {
+			return 0, nil, ErrTooLarge
+		}
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+func (this *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	header := make([]byte, 2, 8)
+	if _, err = io.ReadFull(this.br, header); err != nil {
+		return
+	}
+	fin, opcode = header[0]&0x80 != 0, int(header[0]&0x0f)
+	//客户端的帧必须有掩码
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+		err = ErrProtocol
+		return
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(this.br, header[:2]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		header = header[:8]
+		if _, err = io.ReadFull(this.br, header); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(header))
+	}
+	if opcode >= CLOSE && (length > 125 || !fin) {
+		err = ErrProtocol
+		return
+	}
+	if length < 0 || length > this.MaxSize {
+		err = ErrTooLarge
+		return
+	}
+	mask := make([]byte, 4)
+	if _, err = io.ReadFull(this.br, mask); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(this.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+/**整条信息写为一帧 可在多个协程中调用*/
+func (this *Conn) WriteMessage(opcode int, data []byte) error {
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch l := len(data); {
+	case l <= 125:
+		frame = append(frame, byte(l))
+	case l <= 0xffff:
+		frame = append(frame, 126, byte(l>>8), byte(l))
+	default:
+		frame = append(frame, 127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[2:], uint64(l))
+	}
+	frame = append(frame, data...)
+	this.wlock.Lock()
+	defer this.wlock.Unlock()
+	_, err := this.conn.Write(frame)
+	return err
+}
+
+func (this *Conn) SetReadDeadline(t time.Time) error {
+	return this.conn.SetReadDeadline(t)
+}
+
+func (this *Conn) SetWriteDeadline(t time.Time) error {
+	return this.conn.SetWriteDeadline(t)
+}
+
+func (this *Conn) RemoteAddr() net.Addr {
+	return this.conn.RemoteAddr()
+}
+
+func (this *Conn) IsClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+/**发送close帧后关闭连接*/
+func (this *Conn) Close() error {
+	if this.IsClosed() {
+		return nil
+	}
+	this.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	this.WriteMessage(CLOSE, []byte{0x03, 0xe8})
+	return this.close()
+}
+
+func (this *Conn) close() error {
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		return nil
+	}
+	return this.conn.Close()
+}
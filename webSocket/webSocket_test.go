@@ -0,0 +1,70 @@
+package webSocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_echo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, "")
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(opcode, data)
+		}
+	}))
+	defer server.Close()
+	c, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-Websocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("handshake:", err, resp)
+	}
+	//分片的文本信息中间夹一个ping
+	c.Write(maskedFrame(false, TEXT, []byte("hel")))
+	c.Write(maskedFrame(true, PING, []byte("p")))
+	c.Write(maskedFrame(true, CONTINUATION, []byte("lo")))
+	for _, want := range []string{"\x8a\x01p", "\x81\x05hello"} {
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(br, buf); err != nil || string(buf) != want {
+			t.Fatalf("got %q want %q %v", buf, want, err)
+		}
+	}
+	c.Write(maskedFrame(true, CLOSE, []byte{0x03, 0xe8}))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil || buf[0] != 0x88 {
+		t.Fatalf("close: %q %v", buf, err)
+	}
+}
+
+func maskedFrame(fin bool, opcode int, payload []byte) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{b0, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
@@ -0,0 +1,217 @@
+/**
+ * 事件回调 登陆、登出、消息存储、离线消息时以json POST到业务系统
+ * 作为拦截器注册在 postLogin postStore onLogout onOffline 阶段，请求在队列中异步发送，不阻塞路由
+ * 登陆事件在 onLogin 拦截器都通过后(postLogin)发送，被拒绝的登陆不回调
+ * tim_property:
+ *    webhook.url              回调地址，为空不回调
+ *    webhook.url.domain       指定domain的回调地址，优先于 webhook.url
+ *    webhook.secret           签名密钥，不为空时请求头 X-Tim-Signature: sha256=hex(hmac_sha256(secret,body))
+ *    webhook.secret.domain    指定domain的签名密钥
+ *    webhook.events           回调的事件，逗号分隔 login,logout,message,offline，缺省全部
+ *    webhook.retry            失败重试次数，缺省3，重试间隔1秒起每次翻倍
+ *    webhook.timeout          请求超时，单位秒，缺省5
+ *    webhook.queue            队列长度，缺省10000，队列满时丢弃
+ *    webhook.workers          发送协程数，缺省4
+ */
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/interceptor"
+	"github.com/zhangjunfang/im/protocol"
+	"github.com/zhangjunfang/im/utils"
+)
+
+const (
+	LOGIN   = "login"
+	LOGOUT  = "logout"
+	MESSAGE = "message"
+	OFFLINE = "offline"
+)
+
+/**回调内容*/
+type Event struct {
+	Event     string             `json:"event"`
+	Domain    string             `json:"domain"`
+	Name      string             `json:"name"`
+	Resource  string             `json:"resource,omitempty"`
+	Timestamp string             `json:"timestamp"`
+	Mid       string             `json:"mid,omitempty"`
+	Mbean     *protocol.TimMBean `json:"mbean,omitempty"`
+}
+
+type task struct {
+	url    string
+	secret string
+	event  string
+	body   []byte
+}
+
+var queue chan *task
+var once sync.Once
+
+func init() {
+	interceptor.Register(new(Webhook), interceptor.POST_LOGIN, interceptor.POST_STORE, interceptor.ON_LOGOUT, interceptor.ON_OFFLINE)
+}
+
+func start() {
+	queue = make(chan *task, utils.Atoi(common.CF().GetKV("webhook.queue", "10000")))
+	workers := utils.Atoi(common.CF().GetKV("webhook.workers", "4"))
+	if workers <= 0 {
+		workers = 4
+	}
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+}
+
+func getUrl(domain string) string {
+	url := common.CF().GetKV(fmt.Sprint("webhook.url.", domain), "")
+	if url == "" {
+		url = common.CF().GetKV("webhook.url", "")
+	}
+	return url
+}
+
+func secret(domain string) string {
+	secret := common.CF().GetKV(fmt.Sprint("webhook.secret.", domain), "")
+	if secret == "" {
+		secret = common.CF().GetKV("webhook.secret", "")
+	}
+	return secret
+}
+
+func isEvent(event string) bool {
+	events := common.CF().GetKV("webhook.events", "")
+	if events == "" {
+		return true
+	}
+	for _, e := range strings.Split(events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+/**加入发送队列 未配置回调地址或队列满时丢弃*/
+func Fire(e *Event) {
+	u := getUrl(e.Domain)
+	if u == "" || !isEvent(e.Event) {
+		return
+	}
+	if e.Timestamp == "" {
+		e.Timestamp = utils.TimeMills()
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		logger.Error("webhook marshal:", err.Error())
+		return
+	}
+	once.Do(start)
+	select {
+	case queue <- &task{url: u, secret: secret(e.Domain), event: e.Event, body: body}:
+	default:
+		logger.Warn("webhook queue full, drop:", e.Event, " ", e.Name)
+	}
+}
+
+func worker() {
+	for t := range queue {
+		send(t)
+	}
+}
+
+func send(t *task) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	retry := utils.Atoi(common.CF().GetKV("webhook.retry", "3"))
+	wait := time.Second
+	for i := 0; ; i++ {
+		err := post(t)
+		if err == nil {
+			return
+		}
+		if i >= retry {
+			logger.Error("webhook failed:", t.event, " ", t.url, " ", err.Error())
+			return
+		}
+		time.Sleep(wait)
+		wait = wait << 1
+	}
+}
+
+func post(t *task) (err error) {
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(t.body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Tim-Event", t.event)
+	if t.secret != "" {
+		req.Header.Set("X-Tim-Signature", "sha256="+Sign(t.secret, t.body))
+	}
+	timeout := utils.Atoi(common.CF().GetKV("webhook.timeout", "5"))
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = errors.New(fmt.Sprint("status:", resp.StatusCode))
+	}
+	return
+}
+
+/**hmac_sha256 十六进制*/
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**webhook拦截器 只发送事件，不修改消息*/
+type Webhook struct{}
+
+func (this *Webhook) Name() string {
+	return "webhook"
+}
+
+func (this *Webhook) Intercept(ctx *interceptor.Context) interceptor.Action {
+	e := &Event{Domain: ctx.Domain(), Mid: ctx.Mid, Mbean: ctx.Mbean}
+	if ctx.Tid != nil {
+		e.Name, e.Resource = ctx.Tid.GetName(), ctx.Tid.GetResource()
+	}
+	switch ctx.Stage {
+	case interceptor.POST_LOGIN:
+		e.Event = LOGIN
+	case interceptor.ON_LOGOUT:
+		e.Event = LOGOUT
+	case interceptor.POST_STORE:
+		e.Event = MESSAGE
+	case interceptor.ON_OFFLINE:
+		e.Event = OFFLINE
+	default:
+		return interceptor.CONTINUE
+	}
+	Fire(e)
+	return interceptor.CONTINUE
+}
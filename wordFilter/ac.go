@@ -0,0 +1,147 @@
+package wordFilter
+
+import (
+	"strings"
+	"unicode"
+)
+
+/**Aho-Corasick 多模式匹配，按rune匹配，大小写不敏感*/
+type Matcher struct {
+	nodes []*acNode
+}
+
+type acNode struct {
+	next map[rune]int
+	fail int
+	//匹配到的词长度(rune数)，0表示不是词尾
+	length int
+	//fail链上最长的词长度
+	output int
+	word   string
+}
+
+type Match struct {
+	//rune下标 [Start,End)
+	Start, End int
+	Word       string
+}
+
+func NewMatcher(words []string) *Matcher {
+	m := &Matcher{nodes: []*acNode{newAcNode()}}
+	for _, word := range words {
+		m.add(word)
+	}
+	m.build()
+	return m
+}
+
+func newAcNode() *acNode {
+	return &acNode{next: make(map[rune]int, 0)}
+}
+
+func (this *Matcher) add(word string) {
+	word = foldCase(strings.TrimSpace(word))
+	if word == "" {
+		return
+	}
+	cur, length := 0, 0
+	for _, r := range word {
+		next, ok := this.nodes[cur].next[r]
+		if !ok {
+			this.nodes = append(this.nodes, newAcNode())
+			next = len(this.nodes) - 1
+			this.nodes[cur].next[r] = next
+		}
+		cur = next
+		length++
+	}
+	this.nodes[cur].length, this.nodes[cur].word = length, word
+}
+
+// 广度优先建立fail指针
+func (this *Matcher) build() {
+	queue := make([]int, 0)
+	for _, next := range this.nodes[0].next {
+		queue = append(queue, next)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		node := this.nodes[cur]
+		node.output = node.length
+		if f := this.nodes[node.fail]; f.output > node.output {
+			node.output = f.output
+		}
+		for r, next := range node.next {
+			fail := node.fail
+			for fail > 0 {
+				if _, ok := this.nodes[fail].next[r]; ok {
+					break
+				}
+				fail = this.nodes[fail].fail
+			}
+			if f, ok := this.nodes[fail].next[r]; ok && f != next {
+				this.nodes[next].fail = f
+			}
+			queue = append(queue, next)
+		}
+	}
+}
+
+func (this *Matcher) Empty() bool {
+	return this == nil || len(this.nodes) <= 1
+}
+
+/**按rune转小写 不改变rune的个数 匹配位置与原文一致*/
+func foldCase(s string) string {
+	rs := []rune(s)
+	for i, r := range rs {
+		rs[i] = unicode.ToLower(r)
+	}
+	return string(rs)
+}
+
+/**查找所有匹配的词*/
+func (this *Matcher) Find(text string) (matches []Match) {
+	return this.find([]rune(text))
+}
+
+func (this *Matcher) find(rs []rune) (matches []Match) {
+	if this.Empty() {
+		return
+	}
+	cur := 0
+	for i, r := range rs {
+		r = unicode.ToLower(r)
+		for cur > 0 {
+			if _, ok := this.nodes[cur].next[r]; ok {
+				break
+			}
+			cur = this.nodes[cur].fail
+		}
+		if next, ok := this.nodes[cur].next[r]; ok {
+			cur = next
+		}
+		for n := cur; n > 0 && this.nodes[n].output > 0; n = this.nodes[n].fail {
+			if node := this.nodes[n]; node.length > 0 {
+				matches = append(matches, Match{Start: i + 1 - node.length, End: i + 1, Word: node.word})
+			}
+		}
+	}
+	return
+}
+
+/**匹配的词替换为mask*/
+func (this *Matcher) Replace(text string, mask rune) (string, []Match) {
+	rs := []rune(text)
+	matches := this.find(rs)
+	if len(matches) == 0 {
+		return text, matches
+	}
+	for _, m := range matches {
+		for i := m.Start; i < m.End; i++ {
+			rs[i] = mask
+		}
+	}
+	return string(rs), matches
+}
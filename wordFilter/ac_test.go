@@ -0,0 +1,24 @@
+package wordFilter
+
+import (
+	"testing"
+)
+
+func Test_matcher(t *testing.T) {
+	m := NewMatcher([]string{"he", "she", "his", "hers", "敏感词", "感"})
+	matches := m.Find("ushers 这是敏感词")
+	if len(matches) != 5 {
+		t.Fatal("find:", matches)
+	}
+	s, _ := m.Replace("uShers 这是敏感词", '*')
+	if s != "u***** 这是***" {
+		t.Fatal("replace:", s)
+	}
+	//大小写转换后长度变化的字符不影响替换的位置
+	if s, _ = NewMatcher([]string{"敏感"}).Replace("İ敏感词", '*'); s != "İ**词" {
+		t.Fatal("replace:", s)
+	}
+	if len(NewMatcher(nil).Find("abc")) != 0 {
+		t.Fatal("empty matcher")
+	}
+}
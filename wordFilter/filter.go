@@ -0,0 +1,247 @@
+/**
+ * 敏感词过滤
+ * 作为 preRoute 拦截器检查 TimMBean.Body，单聊与群聊都适用
+ * tim_property:
+ *    filter.enable            1开启 0关闭，缺省1(未配置词库时不处理)
+ *    filter.words             敏感词，逗号分隔
+ *    filter.file              词库文件路径，逗号分隔，每行一个词，#开头为注释
+ *    filter.words.domain      指定domain的敏感词，与全局词库合并
+ *    filter.file.domain       指定domain的词库文件，与全局词库合并
+ *    filter.policy            处理方式 mask(替换) reject(拒绝) flag(放行并记录)，缺省mask
+ *    filter.policy.domain     指定domain的处理方式
+ *    filter.mask              替换字符，缺省*
+ *    filter.reload            检查词库变化的间隔，单位秒，缺省60
+ * 词库由ticker定时检查，配置或文件修改后重新加载
+ */
+package wordFilter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/zhangjunfang/im/common"
+	"github.com/zhangjunfang/im/daoService"
+	"github.com/zhangjunfang/im/interceptor"
+)
+
+const (
+	MASK   = "mask"
+	REJECT = "reject"
+	FLAG   = "flag"
+)
+
+type dictionary struct {
+	//词库来源签名 配置值与文件修改时间
+	sign    string
+	matcher *Matcher
+}
+
+var dictionaries = make(map[string]*dictionary, 0)
+var lock = new(sync.RWMutex)
+
+func init() {
+	interceptor.Register(new(Filter), interceptor.PRE_ROUTE)
+}
+
+func IsEnable() bool {
+	return common.CF().GetKV("filter.enable", "1") == "1"
+}
+
+func Policy(domain string) string {
+	policy := common.CF().GetKV(fmt.Sprint("filter.policy.", domain), "")
+	if policy == "" {
+		policy = common.CF().GetKV("filter.policy", MASK)
+	}
+	return policy
+}
+
+func maskRune() rune {
+	for _, r := range common.CF().GetKV("filter.mask", "*") {
+		return r
+	}
+	return '*'
+}
+
+/**domain的词库 第一次使用时加载*/
+func GetMatcher(domain string) *Matcher {
+	lock.RLock()
+	d, ok := dictionaries[domain]
+	lock.RUnlock()
+	if ok {
+		return d.matcher
+	}
+	return load(domain).matcher
+}
+
+func load(domain string) (d *dictionary) {
+	words, sign := sources(domain)
+	d = &dictionary{sign: sign, matcher: NewMatcher(words)}
+	lock.Lock()
+	dictionaries[domain] = d
+	lock.Unlock()
+	if len(words) > 0 {
+		logger.Info("filter load:", domain, " ", len(words))
+	}
+	return
+}
+
+/**重新加载有变化的词库，由ticker定时调用*/
+func Reload() {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	lock.RLock()
+	changed := make([]string, 0)
+	for domain, d := range dictionaries {
+		if _, sign := signature(domain); sign != d.sign {
+			changed = append(changed, domain)
+		}
+	}
+	lock.RUnlock()
+	for _, domain := range changed {
+		load(domain)
+	}
+}
+
+func configs(domain string) (words, files string) {
+	words = common.CF().GetKV("filter.words", "")
+	files = common.CF().GetKV("filter.file", "")
+	if domain != "" {
+		words = fmt.Sprint(words, ",", common.CF().GetKV(fmt.Sprint("filter.words.", domain), ""))
+		files = fmt.Sprint(files, ",", common.CF().GetKV(fmt.Sprint("filter.file.", domain), ""))
+	}
+	return
+}
+
+func signature(domain string) (paths []string, sign string) {
+	words, files := configs(domain)
+	sign = words
+	paths = make([]string, 0)
+	for _, path := range strings.Split(files, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		paths = append(paths, path)
+		if fi, err := os.Stat(path); err == nil {
+			sign = fmt.Sprint(sign, "|", path, ":", fi.ModTime().UnixNano())
+		}
+	}
+	return
+}
+
+func sources(domain string) (words []string, sign string) {
+	paths, sign := signature(domain)
+	config, _ := configs(domain)
+	words = make([]string, 0)
+	for _, word := range strings.Split(config, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+	for _, path := range paths {
+		ws, err := readFile(path)
+		if err != nil {
+			logger.Error("filter file:", err.Error())
+			continue
+		}
+		words = append(words, ws...)
+	}
+	return
+}
+
+func readFile(path string) (words []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	words = make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+/**敏感词拦截器*/
+type Filter struct{}
+
+func (this *Filter) Name() string {
+	return "filter"
+}
+
+func (this *Filter) Intercept(ctx *interceptor.Context) interceptor.Action {
+	mbean := ctx.Mbean
+	if !IsEnable() || mbean == nil || mbean.GetBody() == "" {
+		return interceptor.CONTINUE
+	}
+	domain := ctx.Domain()
+	matcher := GetMatcher(domain)
+	if matcher.Empty() {
+		return interceptor.CONTINUE
+	}
+	body := mbean.GetBody()
+	masked, matches := matcher.Replace(body, maskRune())
+	if len(matches) == 0 {
+		return interceptor.CONTINUE
+	}
+	policy := Policy(domain)
+	go record(ctx, policy, matches, body)
+	switch policy {
+	case REJECT:
+		return ctx.Reject(4060, "sensitive word")
+	case FLAG:
+		return interceptor.CONTINUE
+	}
+	mbean.Body = &masked
+	return interceptor.CONTINUE
+}
+
+/**记录匹配结果 供审核*/
+func record(ctx *interceptor.Context, policy string, matches []Match, body string) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(string(debug.Stack()))
+		}
+	}()
+	mbean := ctx.Mbean
+	fromTid, toTid, msgmode := mbean.GetFromTid(), mbean.GetToTid(), 1
+	if mbean.GetType() == "groupchat" {
+		fromTid, toTid, msgmode = mbean.GetLeaguerTid(), mbean.GetFromTid(), 2
+	}
+	fromuser, touser := "", ""
+	if fromTid != nil {
+		fromuser = fromTid.GetName()
+	}
+	if toTid != nil {
+		touser = toTid.GetName()
+	}
+	words := make([]string, 0)
+	exist := make(map[string]bool, 0)
+	for _, m := range matches {
+		if !exist[m.Word] {
+			exist[m.Word] = true
+			words = append(words, m.Word)
+		}
+	}
+	w := []rune(strings.Join(words, ","))
+	if len(w) > 255 {
+		w = w[:255]
+	}
+	b := []rune(body)
+	if len(b) > 2000 {
+		b = b[:2000]
+	}
+	daoService.SaveFilterLog(ctx.Domain(), fromuser, touser, msgmode, string(w), policy, string(b))
+}